@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultASNSource is the RIPEstat announced-prefixes endpoint used by --asn.
+// Any server answering in the same JSON shape can be given with --asn-source.
+const DefaultASNSource = "https://stat.ripe.net/data/announced-prefixes/data.json"
+
+// ASNTimeout bounds each announced-prefixes request.
+const ASNTimeout = 30 * time.Second
+
+// announcedPrefixes is the part of a RIPEstat announced-prefixes response
+// that --asn reads.
+type announcedPrefixes struct {
+	Data struct {
+		Prefixes []struct {
+			Prefix string `json:"prefix"`
+		} `json:"prefixes"`
+	} `json:"data"`
+}
+
+// normalizeASN accepts "AS15169", "as15169", or "15169" and returns
+// "AS15169".
+func normalizeASN(asn string) (string, error) {
+	digits := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(asn)), "AS")
+	if _, err := strconv.ParseUint(digits, 10, 32); err != nil {
+		return "", fmt.Errorf("invalid ASN %q: want a number like AS15169", asn)
+	}
+	return "AS" + digits, nil
+}
+
+// ASNPrefixes fetches the prefixes announced by asn from source, a
+// RIPEstat-compatible announced-prefixes endpoint, in the order returned.
+func ASNPrefixes(ctx context.Context, client *http.Client, source, asn string) ([]string, error) {
+	asn, err := normalizeASN(asn)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ASN source %q: %w", source, err)
+	}
+	q := u.Query()
+	q.Set("resource", asn)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching prefixes for %s: %w", asn, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching prefixes for %s: %s", asn, resp.Status)
+	}
+
+	var body announcedPrefixes
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("fetching prefixes for %s: invalid response: %w", asn, err)
+	}
+	var prefixes []string
+	for _, p := range body.Data.Prefixes {
+		if _, _, err := net.ParseCIDR(p.Prefix); err != nil {
+			return nil, fmt.Errorf("fetching prefixes for %s: invalid prefix %q", asn, p.Prefix)
+		}
+		prefixes = append(prefixes, p.Prefix)
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeASN(t *testing.T) {
+	for _, in := range []string{"AS15169", "as15169", "15169", " AS15169 "} {
+		got, err := normalizeASN(in)
+		if err != nil || got != "AS15169" {
+			t.Errorf("normalizeASN(%q) = %q, %v; want AS15169", in, got, err)
+		}
+	}
+	for _, in := range []string{"", "AS", "ASX", "google", "AS-1", "AS99999999999"} {
+		if _, err := normalizeASN(in); err == nil {
+			t.Errorf("normalizeASN(%q): expected error", in)
+		}
+	}
+}
+
+func TestASNPrefixes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("resource") {
+		case "AS64500":
+			w.Write([]byte(`{"data": {"prefixes": [
+				{"prefix": "192.0.2.0/24", "timelines": []},
+				{"prefix": "2001:db8::/32", "timelines": []}
+			]}}`))
+		case "AS64501":
+			w.Write([]byte(`{"data": {"prefixes": [{"prefix": "not-a-prefix"}]}}`))
+		default:
+			http.Error(w, "unknown", http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	got, err := ASNPrefixes(ctx, srv.Client(), srv.URL, "as64500")
+	if err != nil {
+		t.Fatalf("ASNPrefixes error: %v", err)
+	}
+	if strings.Join(got, " ") != "192.0.2.0/24 2001:db8::/32" {
+		t.Errorf("prefixes = %v", got)
+	}
+
+	if _, err := ASNPrefixes(ctx, srv.Client(), srv.URL, "AS64501"); err == nil || !strings.Contains(err.Error(), "invalid prefix") {
+		t.Errorf("err = %v, want invalid prefix error", err)
+	}
+	if _, err := ASNPrefixes(ctx, srv.Client(), srv.URL, "AS64502"); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("err = %v, want HTTP status error", err)
+	}
+}
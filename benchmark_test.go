@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"net"
+	"slices"
 	"testing"
 )
 
@@ -31,6 +32,22 @@ func BenchmarkExpandCIDR_16(b *testing.B) {
 	}
 }
 
+func BenchmarkParseCIDRs_Multi(b *testing.B) {
+	// 256 /24s: a default --max-ips worth of addresses across many targets.
+	// PlanScan expands all of them before the first lookup starts; this is the
+	// most that overlapping expansion with lookups could save.
+	cidrs := make([]string, 256)
+	for i := range cidrs {
+		cidrs[i] = net.IPv4(10, 0, byte(i), 0).String() + "/24"
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = ParseCIDRs(cidrs, 0)
+	}
+}
+
 func BenchmarkLookupWorkers(b *testing.B) {
 	// Create mock resolver that returns immediately
 	resolver := NewMockResolver()
@@ -73,6 +90,30 @@ func BenchmarkLookupWorkers_Concurrency(b *testing.B) {
 	}
 }
 
+func BenchmarkLookupWorkers_QueueSize(b *testing.B) {
+	resolver := NewMockResolver()
+	ips, _ := ExpandCIDR("10.0.0.0/16", 0) // 65536 IPs, all default NXDOMAIN
+	ctx := context.Background()
+
+	sizes := []struct {
+		name string
+		size int
+	}{
+		{"unbounded", len(ips)}, // previous behavior: buffers sized to the input
+		{"default", DefaultQueueSize(50)},
+	}
+	for _, s := range sizes {
+		b.Run(s.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				resultChan := LookupWorkersQueued(ctx, ips, 50, s.size, resolver)
+				for range resultChan {
+				}
+			}
+		})
+	}
+}
+
 func BenchmarkFormatText(b *testing.B) {
 	results := make([]LookupResult, 256)
 	for i := 0; i < 256; i++ {
@@ -90,6 +131,60 @@ func BenchmarkFormatText(b *testing.B) {
 	}
 }
 
+func BenchmarkFormatTextConsolidated(b *testing.B) {
+	// 10k /30s alternating between a hostname and NXDOMAIN
+	results := make([]ConsolidatedResult, 10000)
+	for i := range results {
+		results[i] = ConsolidatedResult{
+			Network: &net.IPNet{IP: net.IPv4(10, byte(i>>14), byte(i>>6), byte(i<<2)).To4(), Mask: net.CIDRMask(30, 32)},
+			Kind:    KindExact,
+		}
+		if i%2 == 0 {
+			results[i].PTR = "host.example.com"
+		} else {
+			results[i].Kind = KindNXDomain
+		}
+	}
+
+	var buf bytes.Buffer
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		_ = formatTextConsolidated(&buf, results, OutputOptions{})
+	}
+}
+
+func BenchmarkAggregateResults(b *testing.B) {
+	// A fragmented /16: each /28 is one NXDOMAIN and four blocks of a PTR
+	// that alternates between /28s, so every /28 becomes its own supernet
+	var consolidated []ConsolidatedResult
+	for block := 0; block < 4096; block++ {
+		base := net.IPv4(10, 0, byte(block>>4), byte(block<<4)).To4()
+		ptr := "a.example.com"
+		if block%2 == 1 {
+			ptr = "b.example.com"
+		}
+		for _, part := range []struct{ offset, ones int }{{0, 32}, {1, 32}, {2, 31}, {4, 30}, {8, 29}} {
+			ip := slices.Clone(base)
+			ip[3] += byte(part.offset)
+			c := ConsolidatedResult{Network: &net.IPNet{IP: ip, Mask: net.CIDRMask(part.ones, 32)}, PTR: ptr, Kind: KindExact}
+			if part.offset == 0 {
+				c.PTR, c.Kind = "", KindNXDomain
+			}
+			consolidated = append(consolidated, c)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if got := AggregateResults(consolidated, 0.9); len(got) != 4096 {
+			b.Fatalf("got %d entries, want 4096 /28s", len(got))
+		}
+	}
+}
+
 func BenchmarkFormatJSON(b *testing.B) {
 	results := make([]LookupResult, 256)
 	for i := 0; i < 256; i++ {
@@ -126,3 +221,21 @@ func BenchmarkSortResults(b *testing.B) {
 		SortResults(results)
 	}
 }
+
+func BenchmarkDoHLookup(b *testing.B) {
+	f := startFakeDNS(b)
+	f.AddPTR("1.2.0.192.in-addr.arpa.", "host.example.com.")
+	srv, _ := startFakeDoH(b, f)
+	client := newTestDoHClient(b, srv, 50)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := client.LookupAddr(ctx, "192.0.2.1"); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Binary output (--output binary) is a compact per-IP encoding for
+// pipelines handling millions of results, where JSON is mostly repeated
+// keys. The stream is the 4-byte magic "SRB1" followed by one record per
+// result:
+//
+//	status  1 byte    binaryPTR, binaryNXDomain, or binaryError
+//	iplen   1 byte    4 or 16
+//	ip      iplen bytes, network order
+//	textlen uvarint   length of text (0 for NXDOMAIN)
+//	text    textlen bytes: the PTR, or the error message
+//
+// The stream ends after the last record; a truncated record is an error.
+// BinaryReader decodes it.
+const binaryMagic = "SRB1"
+
+// Record status bytes in binary output.
+const (
+	binaryPTR      byte = 0
+	binaryNXDomain byte = 1
+	binaryError    byte = 2
+)
+
+// maxBinaryText bounds a record's text when reading, so a corrupt length
+// can't make BinaryReader allocate without limit. DNS names are at most
+// 255 bytes; error messages are a little longer.
+const maxBinaryText = 64 << 10
+
+// formatBinary writes results in the binary encoding, sorted as
+// formatNDJSON sorts them.
+func formatBinary(w io.Writer, results []LookupResult, opts OutputOptions) error {
+	results = append([]LookupResult(nil), results...)
+	SortResultsBy(results, resultOrder(opts))
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(binaryMagic)
+	var buf []byte
+	for _, r := range results {
+		status, text := binaryPTR, r.PTR
+		switch {
+		case r.Error != nil:
+			status, text = binaryError, r.Error.Error()
+		case r.PTR == "":
+			status = binaryNXDomain
+		}
+		ip := canonicalIP(r.IP)
+		buf = append(buf[:0], status, byte(len(ip)))
+		buf = append(buf, ip...)
+		buf = binary.AppendUvarint(buf, uint64(len(text)))
+		buf = append(buf, text...)
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// BinaryReader decodes --output binary streams.
+type BinaryReader struct {
+	r      *bufio.Reader
+	header bool // Magic has been read
+}
+
+// NewBinaryReader returns a reader decoding the binary stream in r.
+func NewBinaryReader(r io.Reader) *BinaryReader {
+	return &BinaryReader{r: bufio.NewReader(r)}
+}
+
+// Read returns the next result. A PTR, NXDOMAIN, or error record becomes
+// a LookupResult with PTR set, neither set, or Error set to the message.
+// It returns io.EOF after the last record.
+func (b *BinaryReader) Read() (LookupResult, error) {
+	if !b.header {
+		magic := make([]byte, len(binaryMagic))
+		if _, err := io.ReadFull(b.r, magic); err != nil || string(magic) != binaryMagic {
+			return LookupResult{}, fmt.Errorf("not sr binary output: missing %q header", binaryMagic)
+		}
+		b.header = true
+	}
+
+	status, err := b.r.ReadByte()
+	if err != nil {
+		return LookupResult{}, err // io.EOF: clean end between records
+	}
+	ipLen, err := b.r.ReadByte()
+	if err != nil {
+		return LookupResult{}, truncated(err)
+	}
+	if ipLen != net.IPv4len && ipLen != net.IPv6len {
+		return LookupResult{}, fmt.Errorf("invalid binary record: IP length %d", ipLen)
+	}
+	ip := make(net.IP, ipLen)
+	if _, err := io.ReadFull(b.r, ip); err != nil {
+		return LookupResult{}, truncated(err)
+	}
+	textLen, err := binary.ReadUvarint(b.r)
+	if err != nil {
+		return LookupResult{}, truncated(err)
+	}
+	if textLen > maxBinaryText {
+		return LookupResult{}, fmt.Errorf("invalid binary record for %s: text length %d", ip, textLen)
+	}
+	text := make([]byte, textLen)
+	if _, err := io.ReadFull(b.r, text); err != nil {
+		return LookupResult{}, truncated(err)
+	}
+
+	r := LookupResult{IP: ip}
+	switch status {
+	case binaryPTR:
+		r.PTR = string(text)
+	case binaryNXDomain:
+	case binaryError:
+		r.Error = errors.New(string(text))
+	default:
+		return LookupResult{}, fmt.Errorf("invalid binary record for %s: status %d", ip, status)
+	}
+	return r, nil
+}
+
+// truncated reports a stream that ended inside a record.
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("truncated binary record: %w", err)
+}
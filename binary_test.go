@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("2001:db8::1"), PTR: strings.Repeat("a", 200) + ".example.com"},
+		{IP: net.ParseIP("192.0.2.1").To4(), PTR: "host.example.com"},
+		{IP: net.ParseIP("192.0.2.2").To4()},
+		{IP: net.ParseIP("192.0.2.3").To4(), Error: errors.New("lookup 192.0.2.3: timeout")},
+	}
+
+	var buf bytes.Buffer
+	if err := formatBinary(&buf, results, OutputOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("SRB1")) {
+		t.Fatalf("missing header: % x", buf.Bytes()[:4])
+	}
+	if want := []byte{binaryPTR, 16, 0x20, 0x01, 0x0d, 0xb8}; !bytes.HasPrefix(buf.Bytes()[4:], want) {
+		t.Errorf("first record = % x, want prefix % x", buf.Bytes()[4:10], want)
+	}
+
+	br := NewBinaryReader(&buf)
+	for _, want := range results {
+		got, err := br.Read()
+		if err != nil {
+			t.Fatalf("reading record for %s: %v", want.IP, err)
+		}
+		if !got.IP.Equal(want.IP) || got.PTR != want.PTR || (got.Error == nil) != (want.Error == nil) {
+			t.Errorf("record = %+v, want %+v", got, want)
+		} else if want.Error != nil && got.Error.Error() != want.Error.Error() {
+			t.Errorf("%s: error = %q, want %q", want.IP, got.Error, want.Error)
+		}
+	}
+	if _, err := br.Read(); err != io.EOF {
+		t.Errorf("after last record: err = %v, want io.EOF", err)
+	}
+
+	// An IPv4 address held in 16 bytes is still written in 4
+	buf.Reset()
+	if err := formatBinary(&buf, []LookupResult{{IP: net.ParseIP("192.0.2.1")}}, OutputOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "SRB1\x01\x04\xc0\x00\x02\x01\x00"; buf.String() != want {
+		t.Errorf("encoded = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestBinaryReaderErrors(t *testing.T) {
+	var buf bytes.Buffer
+	results := []LookupResult{{IP: net.ParseIP("192.0.2.1").To4(), PTR: "host.example.com"}}
+	if err := formatBinary(&buf, results, OutputOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	full := buf.Bytes()
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"no header", []byte(`[{"ip":"192.0.2.1"}]`), "not sr binary output"},
+		{"empty", nil, "not sr binary output"},
+		{"truncated text", full[:len(full)-3], "truncated"},
+		{"truncated IP", full[:8], "truncated"},
+		{"bad IP length", []byte("SRB1\x00\x05"), "IP length 5"},
+		{"bad status", append([]byte("SRB1\x07\x04\xc0\x00\x02\x01"), 0), "status 7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewBinaryReader(bytes.NewReader(tt.data)).Read()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Read error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
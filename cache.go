@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Cache keeps PTR answers between runs (--cache-file), so a repeated scan
+// only queries the addresses it has no answer for, or whose answer is older
+// than MaxAge (--refresh-older-than).
+type Cache struct {
+	MaxAge time.Duration // Entries older than this are re-queried; 0 reuses any entry
+
+	mu      sync.Mutex
+	entries map[string]CacheEntry // IP (canonical form) -> answer
+	now     func() time.Time
+}
+
+// CacheEntry is one cached answer. Only answers are cached: a failed
+// lookup is queried again next time.
+type CacheEntry struct {
+	PTR     string    `json:"ptr,omitempty"` // Empty for NXDOMAIN
+	Fetched time.Time `json:"fetched"`       // When the answer was looked up
+}
+
+// NewCache returns an empty cache whose entries go stale after maxAge.
+func NewCache(maxAge time.Duration) *Cache {
+	return &Cache{MaxAge: maxAge, entries: make(map[string]CacheEntry), now: time.Now}
+}
+
+// LoadCache reads the cache at path. A missing file is an empty cache, so
+// the first run with --cache-file creates it.
+func LoadCache(path string, maxAge time.Duration) (*Cache, error) {
+	c := NewCache(maxAge)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, fmt.Errorf("%s: invalid cache: %w", path, err)
+	}
+	return c, nil
+}
+
+// Get returns the cached answer for ip if there is one and it is fresh. A
+// nil cache has no entries.
+func (c *Cache) Get(ip net.IP) (ptr string, ok bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[canonicalIP(ip).String()]
+	if !ok || (c.MaxAge > 0 && c.now().Sub(e.Fetched) > c.MaxAge) {
+		return "", false
+	}
+	return e.PTR, true
+}
+
+// Put records a looked-up answer, stamped with the current time. Errors
+// and inferred patterns are not answers and are skipped.
+func (c *Cache) Put(r LookupResult) {
+	if c == nil || r.Error != nil || r.Inferred {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[canonicalIP(r.IP).String()] = CacheEntry{PTR: r.PTR, Fetched: c.now()}
+}
+
+// Save writes the cache to path, under a temporary name renamed into
+// place so an interrupted save leaves the previous cache intact.
+func (c *Cache) Save(path string) error {
+	c.mu.Lock()
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
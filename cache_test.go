@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheFreshness(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := NewCache(time.Hour)
+	c.now = func() time.Time { return now }
+
+	c.Put(LookupResult{IP: net.ParseIP("192.0.2.1"), PTR: "host.example.com"})
+	c.Put(LookupResult{IP: net.ParseIP("192.0.2.2")}) // NXDOMAIN is an answer
+	c.Put(LookupResult{IP: net.ParseIP("192.0.2.3"), Error: errors.New("timeout")})
+	c.Put(LookupResult{IP: net.ParseIP("192.0.2.4"), PTR: "*.example.com", Inferred: true})
+
+	if ptr, ok := c.Get(net.ParseIP("192.0.2.1").To4()); !ok || ptr != "host.example.com" {
+		t.Errorf("Get(192.0.2.1) = %q, %v, want host.example.com", ptr, ok)
+	}
+	if ptr, ok := c.Get(net.ParseIP("192.0.2.2")); !ok || ptr != "" {
+		t.Errorf("Get(192.0.2.2) = %q, %v, want cached NXDOMAIN", ptr, ok)
+	}
+	for _, ip := range []string{"192.0.2.3", "192.0.2.4", "192.0.2.5"} {
+		if _, ok := c.Get(net.ParseIP(ip)); ok {
+			t.Errorf("Get(%s) hit, want miss", ip)
+		}
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, ok := c.Get(net.ParseIP("192.0.2.1")); ok {
+		t.Error("entry older than MaxAge was reused")
+	}
+	c.MaxAge = 0
+	if _, ok := c.Get(net.ParseIP("192.0.2.1")); !ok {
+		t.Error("with no MaxAge, any entry should be reused")
+	}
+
+	var nilCache *Cache
+	nilCache.Put(LookupResult{IP: net.ParseIP("192.0.2.1"), PTR: "host.example.com"})
+	if _, ok := nilCache.Get(net.ParseIP("192.0.2.1")); ok {
+		t.Error("nil cache hit")
+	}
+}
+
+func TestCacheSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	c, err := LoadCache(path, 0)
+	if err != nil {
+		t.Fatalf("missing cache file: %v", err)
+	}
+	c.Put(LookupResult{IP: net.ParseIP("2001:db8::1"), PTR: "host.example.com"})
+	if err := c.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	loaded, err := LoadCache(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ptr, ok := loaded.Get(net.ParseIP("2001:db8::1")); !ok || ptr != "host.example.com" {
+		t.Errorf("reloaded Get = %q, %v, want host.example.com", ptr, ok)
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCache(path, 0); err == nil {
+		t.Error("expected error for a corrupt cache")
+	}
+}
+
+func TestLookupWorkersCache(t *testing.T) {
+	now := time.Now()
+	cache := NewCache(time.Hour)
+	cache.now = func() time.Time { return now }
+	cache.Put(LookupResult{IP: net.ParseIP("10.0.0.0").To4(), PTR: "fresh.example.com"})
+	now = now.Add(-2 * time.Hour)
+	cache.Put(LookupResult{IP: net.ParseIP("10.0.0.1").To4(), PTR: "stale.example.com"})
+	now = now.Add(2 * time.Hour)
+
+	resolver := &countingResolver{}
+	ips, _ := ExpandCIDR("10.0.0.0/30", 0)
+	got := make(map[string]string)
+	for r := range LookupWorkersWith(context.Background(), ips, 2, resolver, WorkerOptions{Cache: cache}) {
+		got[r.IP.String()] = r.PTR
+	}
+
+	// Only the fresh entry is reused; the stale one and the misses are queried
+	if resolver.served != 3 {
+		t.Errorf("served %d lookups, want 3", resolver.served)
+	}
+	if got["10.0.0.0"] != "fresh.example.com" || got["10.0.0.1"] != "" {
+		t.Errorf("results = %v, want the fresh entry reused and the stale one re-queried", got)
+	}
+	// The queried answers replace the stale entry and fill the misses
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		if ptr, ok := cache.Get(net.ParseIP(ip)); !ok || ptr != "" {
+			t.Errorf("cache %s = %q, %v, want the fresh NXDOMAIN", ip, ptr, ok)
+		}
+	}
+}
@@ -1,20 +1,146 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"math"
+	"math/big"
+	"math/bits"
 	"net"
+	"slices"
+	"strconv"
+	"strings"
 )
 
 // SentinelSize is returned by CIDRSize for ranges too large to count (≥64 host bits).
 // It signals "uncountably large" without failing, allowing truncation downstream.
 const SentinelSize = math.MaxUint64
 
+// parseCIDR is net.ParseCIDR that also accepts an IPv4 address written as
+// a 32-bit integer (see parseNumericIPv4) and reverse names (see
+// parseArpaName), and maps IPv4-mapped IPv6 blocks ("::ffff:192.0.2.0/120")
+// to their IPv4 form ("192.0.2.0/24"), so they are queried under
+// in-addr.arpa and consolidated as IPv4. Blocks reaching outside
+// ::ffff:0:0/96 stay IPv6.
+func parseCIDR(cidr string) (net.IP, *net.IPNet, error) {
+	if c, arpa, err := parseArpaName(cidr); arpa {
+		if err != nil {
+			return nil, nil, err
+		}
+		cidr = c
+	}
+	if addr, prefix, ok := strings.Cut(cidr, "/"); ok {
+		if ip, numeric, err := parseNumericIPv4(addr); numeric {
+			if err != nil {
+				return nil, nil, err
+			}
+			cidr = ip.String() + "/" + prefix
+		}
+	}
+	ip, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if v4 := ipnet.IP.To4(); v4 != nil && len(ipnet.IP) == net.IPv6len {
+		ones, _ := ipnet.Mask.Size()
+		ipnet = &net.IPNet{IP: v4, Mask: net.CIDRMask(ones-96, 32)}
+		ip = ip.To4()
+	}
+	return ip, ipnet, nil
+}
+
+// parseNumericIPv4 parses an IPv4 address written as a 32-bit integer, in
+// decimal ("3232235776") or hex ("0xC0A80100"), as found in databases and
+// firewall logs. numeric reports whether s had that form at all; if so, err
+// is set for values beyond 32 bits, and for decimal values up to 255: "10/8"
+// is far more likely a shortened 10.0.0.0/8 than 0.0.0.10 widened to a /8.
+func parseNumericIPv4(s string) (ip net.IP, numeric bool, err error) {
+	digits, base, valid := s, 10, "0123456789"
+	if len(s) > 2 && (s[:2] == "0x" || s[:2] == "0X") {
+		digits, base, valid = s[2:], 16, "0123456789abcdefABCDEF"
+	}
+	if digits == "" || strings.Trim(digits, valid) != "" {
+		return nil, false, nil
+	}
+	n, err := strconv.ParseUint(digits, base, 32)
+	if err != nil {
+		return nil, true, fmt.Errorf("%q is out of the IPv4 range (at most 4294967295 or 0xFFFFFFFF)", s)
+	}
+	if base == 10 && n <= 255 {
+		return nil, true, fmt.Errorf("%q is ambiguous as an address: write %d.0.0.0, or 0x%X for 0.0.0.%d", s, n, n, n)
+	}
+	return net.IPv4(byte(n>>24), byte(n>>16), byte(n>>8), byte(n)).To4(), true, nil
+}
+
+// parseArpaName converts a reverse name, as printed by dig and other DNS
+// tools, into the CIDR it covers: "1.2.0.192.in-addr.arpa" is 192.0.2.1/32
+// and a shorter zone such as "2.0.192.in-addr.arpa" is 192.0.2.0/24;
+// ip6.arpa names work the same way, one hex nibble per label. arpa reports
+// whether name is under in-addr.arpa or ip6.arpa at all; if so, err is set
+// for labels that aren't octets or nibbles.
+func parseArpaName(name string) (cidr string, arpa bool, err error) {
+	lower := strings.ToLower(strings.TrimSuffix(name, "."))
+	var labels []string
+	var v6 bool
+	switch {
+	case strings.HasSuffix(lower, ".in-addr.arpa"):
+		labels = strings.Split(strings.TrimSuffix(lower, ".in-addr.arpa"), ".")
+	case strings.HasSuffix(lower, ".ip6.arpa"):
+		labels = strings.Split(strings.TrimSuffix(lower, ".ip6.arpa"), ".")
+		v6 = true
+	default:
+		return "", false, nil
+	}
+	slices.Reverse(labels)
+
+	if !v6 {
+		if len(labels) > 4 {
+			return "", true, fmt.Errorf("malformed reverse name %q: more than 4 labels under in-addr.arpa", name)
+		}
+		ip := make(net.IP, net.IPv4len)
+		for i, label := range labels {
+			n, err := strconv.ParseUint(label, 10, 8)
+			if err != nil || (len(label) > 1 && label[0] == '0') {
+				return "", true, fmt.Errorf("malformed reverse name %q: label %q is not an octet (0-255)", name, label)
+			}
+			ip[i] = byte(n)
+		}
+		return fmt.Sprintf("%s/%d", ip, 8*len(labels)), true, nil
+	}
+
+	if len(labels) > 32 {
+		return "", true, fmt.Errorf("malformed reverse name %q: more than 32 nibbles under ip6.arpa", name)
+	}
+	ip := make(net.IP, net.IPv6len)
+	for i, label := range labels {
+		n, err := strconv.ParseUint(label, 16, 4)
+		if err != nil || len(label) != 1 {
+			return "", true, fmt.Errorf("malformed reverse name %q: label %q is not a hex nibble", name, label)
+		}
+		if i%2 == 0 {
+			ip[i/2] = byte(n) << 4
+		} else {
+			ip[i/2] |= byte(n)
+		}
+	}
+	return fmt.Sprintf("%s/%d", ip, 4*len(labels)), true, nil
+}
+
+// canonicalIP returns ip in its 4-byte form if it is IPv4, including the
+// 16-byte and IPv4-mapped forms, so IPv4 addresses group and sort together.
+func canonicalIP(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip
+}
+
 // CIDRSize returns the number of addresses in a CIDR block without expanding it.
 // Returns SentinelSize for ranges with ≥64 host bits (too large to count).
 // Returns an error only if the CIDR is invalid.
 func CIDRSize(cidr string) (uint64, error) {
-	_, ipnet, err := net.ParseCIDR(cidr)
+	_, ipnet, err := parseCIDR(cidr)
 	if err != nil {
 		return 0, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
 	}
@@ -30,11 +156,134 @@ func CIDRSize(cidr string) (uint64, error) {
 	return 1 << uint(hostBits), nil
 }
 
+// ReadTargets reads CIDR blocks from a target list, one per line. Only the
+// first whitespace-delimited token of a line is used, so trailing notes like
+// "10.0.0.1 # web server" are ignored, as are blank lines and lines starting
+// with "#". Bare IPs become single-host CIDRs, and a port ("10.0.0.1:443",
+// "[2001:db8::1]:443") is dropped.
+func ReadTargets(r io.Reader) ([]string, error) {
+	var targets []string
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		cidr, err := normalizeTarget(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		targets = append(targets, cidr)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// normalizeTarget turns a CIDR, IP, IP:port, or reverse name into a CIDR
+// string.
+func normalizeTarget(target string) (string, error) {
+	cidr, err := targetCIDR(target)
+	if err != nil {
+		return "", fmt.Errorf("invalid target %q: %w", target, err)
+	}
+	return cidr, nil
+}
+
+// targetCIDR is normalizeTarget without the target in its errors.
+func targetCIDR(target string) (string, error) {
+	if _, _, err := parseCIDR(target); err == nil {
+		return target, nil
+	} else if _, arpa, _ := parseArpaName(target); arpa {
+		return "", err
+	} else if addr, _, ok := strings.Cut(target, "/"); ok {
+		if _, numeric, _ := parseNumericIPv4(addr); numeric {
+			return "", err
+		}
+	}
+	host := target
+	if h, _, err := net.SplitHostPort(target); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		var numeric bool
+		var err error
+		if ip, numeric, err = parseNumericIPv4(host); err != nil {
+			return "", err
+		} else if !numeric {
+			return "", fmt.Errorf("not a CIDR or IP address")
+		}
+	}
+	return singleIPNet(ip).String(), nil
+}
+
+// TotalAddresses returns the exact number of addresses across all CIDR
+// blocks, before any --max-ips truncation. Unlike CIDRSize it does not cap
+// huge ranges, so it can report how much of the input was sampled.
+func TotalAddresses(cidrs []string) (*big.Int, error) {
+	total := new(big.Int)
+	for _, cidr := range cidrs {
+		_, ipnet, err := parseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		ones, bits := ipnet.Mask.Size()
+		total.Add(total, new(big.Int).Lsh(big.NewInt(1), uint(bits-ones)))
+	}
+	return total, nil
+}
+
 // ExpandCIDR returns IP addresses within a CIDR block, up to maxIPs.
 // If maxIPs > 0 and the CIDR contains more addresses, truncates to maxIPs.
 // For example, "192.168.1.0/30" returns [192.168.1.0, 192.168.1.1, 192.168.1.2, 192.168.1.3]
 func ExpandCIDR(cidr string, maxIPs uint64) ([]net.IP, error) {
-	ip, ipnet, err := net.ParseCIDR(cidr)
+	return expandCIDR(cidr, maxIPs, false)
+}
+
+// ExpandUsableCIDR is ExpandCIDR without the addresses that aren't hosts
+// (--usable-only): see usableHost. maxIPs counts the addresses returned.
+// For example, "192.168.1.0/30" returns [192.168.1.1, 192.168.1.2].
+func ExpandUsableCIDR(cidr string, maxIPs uint64) ([]net.IP, error) {
+	return expandCIDR(cidr, maxIPs, true)
+}
+
+// usableHost reports whether ip, inside n, can be a host. In blocks with
+// room for hosts besides them (/30 and /126 or larger), the all-zeros
+// address is not: it is the IPv4 network address or the IPv6 subnet-router
+// anycast address, which routers answer rather than a host. Neither is the
+// IPv4 broadcast address. The unspecified addresses 0.0.0.0 and :: never
+// are, whatever the prefix. Like FirstHost, /31 and /127 blocks keep both
+// of their addresses (RFC 3021, RFC 6164).
+func usableHost(ip net.IP, n *net.IPNet) bool {
+	if ip.IsUnspecified() {
+		return false
+	}
+	ones, bits := n.Mask.Size()
+	if bits-ones < 2 {
+		return true
+	}
+	if ip.Equal(n.IP) {
+		return false
+	}
+	if bits == 32 {
+		broadcast := true
+		for i := range ip {
+			if ip[i]|n.Mask[i] != 0xff {
+				broadcast = false
+				break
+			}
+		}
+		return !broadcast
+	}
+	return true
+}
+
+func expandCIDR(cidr string, maxIPs uint64, usableOnly bool) ([]net.IP, error) {
+	ip, ipnet, err := parseCIDR(cidr)
 	if err != nil {
 		return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
 	}
@@ -53,6 +302,9 @@ func ExpandCIDR(cidr string, maxIPs uint64) ([]net.IP, error) {
 	// Pre-allocate slice for efficiency
 	ips := make([]net.IP, 0, allocSize)
 	for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); incIP(ip) {
+		if usableOnly && !usableHost(ip, ipnet) {
+			continue
+		}
 		// Make a copy since incIP modifies in place
 		ipCopy := make(net.IP, len(ip))
 		copy(ipCopy, ip)
@@ -69,14 +321,39 @@ func ExpandCIDR(cidr string, maxIPs uint64) ([]net.IP, error) {
 
 // ParseCIDRs validates and expands multiple CIDR blocks into a flat list of IPs.
 // If maxIPs > 0 and total exceeds the limit, truncates to maxIPs addresses.
+// IPv4-mapped blocks (::ffff:0:0/96 and longer) expand to IPv4 addresses.
 func ParseCIDRs(cidrs []string, maxIPs uint64) ([]net.IP, error) {
+	ips, _, err := ParseCIDRsWithSources(cidrs, maxIPs)
+	return ips, err
+}
+
+// ParseCIDRsWithSources is ParseCIDRs that also returns, for each IP, the
+// input CIDR it was expanded from (sources[i] is the input for ips[i]).
+func ParseCIDRsWithSources(cidrs []string, maxIPs uint64) ([]net.IP, []string, error) {
+	return parseCIDRs(cidrs, maxIPs, false, false)
+}
+
+// ParseCIDRsPerCIDR is ParseCIDRsWithSources with maxIPs applied to each
+// CIDR on its own rather than shared, so early blocks in a long list cannot
+// use up the budget of later ones.
+func ParseCIDRsPerCIDR(cidrs []string, maxIPs uint64) ([]net.IP, []string, error) {
+	return parseCIDRs(cidrs, maxIPs, true, false)
+}
+
+// parseCIDRs expands cidrs with maxIPs as a shared budget, or as a limit
+// per CIDR if perCIDR is set. With usableOnly, addresses that can't be
+// hosts are skipped, as by ExpandUsableCIDR.
+func parseCIDRs(cidrs []string, maxIPs uint64, perCIDR, usableOnly bool) ([]net.IP, []string, error) {
 	// First pass: calculate total size and validate syntax
 	var totalSize uint64
 	hasHugeRange := false
 	for _, cidr := range cidrs {
 		size, err := CIDRSize(cidr)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		if perCIDR && maxIPs > 0 {
+			size = min(size, maxIPs)
 		}
 		if size == SentinelSize {
 			hasHugeRange = true
@@ -104,26 +381,212 @@ func ParseCIDRs(cidrs []string, maxIPs uint64) ([]net.IP, error) {
 
 	// Second pass: expand with budget tracking
 	allIPs := make([]net.IP, 0, allocCap)
+	sources := make([]string, 0, allocCap)
 	remaining := maxIPs
 	for _, cidr := range cidrs {
 		var limit uint64
-		if maxIPs > 0 {
+		if perCIDR {
+			limit = maxIPs
+		} else if maxIPs > 0 {
 			limit = remaining
 			if limit == 0 {
 				break // budget exhausted
 			}
 		}
-		ips, err := ExpandCIDR(cidr, limit)
+		ips, err := expandCIDR(cidr, limit, usableOnly)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		allIPs = append(allIPs, ips...)
-		if maxIPs > 0 {
+		for range ips {
+			sources = append(sources, cidr)
+		}
+		if maxIPs > 0 && !perCIDR {
 			remaining -= uint64(len(ips))
 		}
 	}
 
-	return allIPs, nil
+	return allIPs, sources, nil
+}
+
+// FilterFamily checks that every CIDR belongs to family ("ipv4" or "ipv6").
+// CIDRs of the other family are an error naming the offending CIDR, or are
+// removed if drop is set. An empty family returns cidrs unchanged.
+func FilterFamily(cidrs []string, family string, drop bool) ([]string, error) {
+	if family == "" {
+		return cidrs, nil
+	}
+
+	kept := make([]string, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipnet, err := parseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		_, bits := ipnet.Mask.Size()
+		isIPv4 := bits == 32
+		if isIPv4 == (family == "ipv4") {
+			kept = append(kept, cidr)
+			continue
+		}
+		if drop {
+			continue
+		}
+		if isIPv4 {
+			return nil, fmt.Errorf("CIDR %q is IPv4, but only IPv6 is allowed", cidr)
+		}
+		return nil, fmt.Errorf("CIDR %q is IPv6, but only IPv4 is allowed", cidr)
+	}
+	return kept, nil
+}
+
+// ExcludeCIDRs removes the excluded blocks (CIDRs or bare IPs) from cidrs
+// without expanding either side, so "10.0.0.0/8" minus "10.1.0.0/16" becomes
+// the eight blocks around the hole. A block is only split along the path to
+// each exclusion inside it, giving at most one piece per prefix bit.
+func ExcludeCIDRs(cidrs, excludes []string) ([]string, error) {
+	if len(excludes) == 0 {
+		return cidrs, nil
+	}
+	var holes []*net.IPNet
+	for _, e := range excludes {
+		cidr, err := normalizeTarget(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclusion: %w", err)
+		}
+		_, hole, _ := parseCIDR(cidr)
+		holes = append(holes, hole)
+	}
+
+	var kept []string
+	for _, cidr := range cidrs {
+		_, ipnet, err := parseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		pieces := []*net.IPNet{ipnet}
+		for _, hole := range holes {
+			var rest []*net.IPNet
+			for _, p := range pieces {
+				rest = append(rest, subtractNet(p, hole)...)
+			}
+			pieces = rest
+		}
+		for _, p := range pieces {
+			kept = append(kept, p.String())
+		}
+	}
+	return kept, nil
+}
+
+// subtractNet returns the blocks of n not covered by hole, in address order.
+func subtractNet(n, hole *net.IPNet) []*net.IPNet {
+	nOnes, nBits := n.Mask.Size()
+	hOnes, hBits := hole.Mask.Size()
+	switch {
+	case nBits != hBits:
+		return []*net.IPNet{n}
+	case hOnes <= nOnes && hole.Contains(n.IP):
+		return nil
+	case hOnes > nOnes && n.Contains(hole.IP):
+		lo, hi := splitNet(n)
+		return append(subtractNet(lo, hole), subtractNet(hi, hole)...)
+	default:
+		return []*net.IPNet{n}
+	}
+}
+
+// splitNet splits n into its two halves, one prefix bit longer.
+func splitNet(n *net.IPNet) (lo, hi *net.IPNet) {
+	ones, bits := n.Mask.Size()
+	mask := net.CIDRMask(ones+1, bits)
+	lo = &net.IPNet{IP: n.IP.Mask(mask), Mask: mask}
+	hiIP := copyIP(lo.IP)
+	hiIP[ones/8] |= 0x80 >> (ones % 8)
+	hi = &net.IPNet{IP: hiIP, Mask: mask}
+	return lo, hi
+}
+
+// splitToPrefix splits n into the networks of the given prefix length that
+// cover it. Networks already that long or longer are returned as is; prefix
+// is capped to the address length, so /24 applies to IPv4 and IPv6 alike.
+func splitToPrefix(n *net.IPNet, prefix int) []*net.IPNet {
+	ones, bits := n.Mask.Size()
+	if ones >= min(prefix, bits) {
+		return []*net.IPNet{n}
+	}
+	lo, hi := splitNet(n)
+	return append(splitToPrefix(lo, prefix), splitToPrefix(hi, prefix)...)
+}
+
+// FirstHost returns the first usable host address of a CIDR block: the
+// address after the network address, or the network address itself for
+// blocks too small to have a separate one (/31, /32, /127, /128).
+func FirstHost(cidr string) (net.IP, error) {
+	ip, ipnet, err := parseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+	}
+
+	first := ip.Mask(ipnet.Mask)
+	ones, bits := ipnet.Mask.Size()
+	if bits-ones >= 2 {
+		incIP(first)
+	}
+	return first, nil
+}
+
+// FirstHosts returns the first usable host of each CIDR block, for a cheap
+// "who owns this network" overview without scanning every range.
+func FirstHosts(cidrs []string) ([]net.IP, error) {
+	ips := make([]net.IP, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		ip, err := FirstHost(cidr)
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// RangeToCIDRs returns the fewest CIDR blocks covering the addresses from
+// start to end inclusive (--range). Both must be addresses of the same
+// family, with start no higher than end.
+func RangeToCIDRs(start, end string) ([]string, error) {
+	first, last := net.ParseIP(start), net.ParseIP(end)
+	if first == nil {
+		return nil, fmt.Errorf("invalid range start %q: not an IP address", start)
+	}
+	if last == nil {
+		return nil, fmt.Errorf("invalid range end %q: not an IP address", end)
+	}
+	first, last = canonicalIP(first), canonicalIP(last)
+	if len(first) != len(last) {
+		return nil, fmt.Errorf("invalid range %s to %s: addresses are of different families", start, end)
+	}
+	lo, hi := new(big.Int).SetBytes(first), new(big.Int).SetBytes(last)
+	if lo.Cmp(hi) > 0 {
+		return nil, fmt.Errorf("invalid range %s to %s: start is after end", start, end)
+	}
+
+	// Greedily take the largest aligned block at lo that stays within hi
+	totalBits := len(first) * 8
+	var cidrs []string
+	one := big.NewInt(1)
+	for lo.Cmp(hi) <= 0 {
+		blockBits := totalBits
+		if lo.Sign() != 0 {
+			blockBits = int(lo.TrailingZeroBits())
+		}
+		remaining := new(big.Int).Sub(hi, lo)
+		blockBits = min(blockBits, remaining.Add(remaining, one).BitLen()-1)
+
+		ip := net.IP(lo.FillBytes(make([]byte, len(first))))
+		cidrs = append(cidrs, fmt.Sprintf("%s/%d", ip, totalBits-blockBits))
+		lo.Add(lo, new(big.Int).Lsh(one, uint(blockBits)))
+	}
+	return cidrs, nil
 }
 
 // copyIP returns a copy of an IP address.
@@ -165,7 +628,8 @@ func findContiguousRuns(sortedIPs []net.IP) [][]net.IP {
 	for i := 1; i < len(sortedIPs); i++ {
 		prev := copyIP(sortedIPs[i-1])
 		incIP(prev)
-		if !prev.Equal(sortedIPs[i]) {
+		// The all-ones address wraps to all-zeros; that is not adjacency
+		if !prev.Equal(sortedIPs[i]) || prev.IsUnspecified() {
 			runs = append(runs, sortedIPs[start:i])
 			start = i
 		}
@@ -190,11 +654,10 @@ func ContiguousIPsToNetworks(ips []net.IP) []*net.IPNet {
 		remaining := len(ips) - pos
 		alignment := trailingZeroBits(ips[pos])
 
-		// Find the largest power-of-2 block that fits
-		blockBits := 0
-		for blockBits < alignment && (1<<(blockBits+1)) <= remaining {
-			blockBits++
-		}
+		// Find the largest power-of-2 block that fits. Capping to the run
+		// matters at 0.0.0.0 and ::, which are aligned to the whole address
+		// space and would otherwise become a /0.
+		blockBits := min(alignment, bits.Len(uint(remaining))-1)
 
 		ones := totalBits - blockBits
 		mask := net.CIDRMask(ones, totalBits)
@@ -228,3 +691,23 @@ func incIP(ip net.IP) {
 		}
 	}
 }
+
+// networkSize returns the number of addresses in a network.
+// Returns SentinelSize for networks with ≥64 host bits.
+func networkSize(n *net.IPNet) uint64 {
+	ones, bits := n.Mask.Size()
+	if bits-ones >= 64 {
+		return SentinelSize
+	}
+	return 1 << uint(bits-ones)
+}
+
+// supernet returns the enclosing network of n with the given prefix length.
+func supernet(n *net.IPNet, ones int) *net.IPNet {
+	_, bits := n.Mask.Size()
+	mask := net.CIDRMask(ones, bits)
+	return &net.IPNet{
+		IP:   n.IP.Mask(mask),
+		Mask: mask,
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"net"
+	"strings"
 	"testing"
 )
 
@@ -337,6 +338,12 @@ func TestFindContiguousRuns(t *testing.T) {
 			wantRuns: 1,
 			wantLens: []int{1},
 		},
+		{
+			name:     "all-ones does not wrap to all-zeros",
+			ips:      []string{"255.255.255.255", "0.0.0.0"},
+			wantRuns: 2,
+			wantLens: []int{1, 1},
+		},
 		{
 			name:     "empty",
 			ips:      []string{},
@@ -401,6 +408,21 @@ func TestContiguousIPsToNetworks(t *testing.T) {
 			ips:          []string{"10.0.0.5"},
 			wantNetworks: []string{"10.0.0.5/32"},
 		},
+		{
+			name:         "all-zeros alone",
+			ips:          []string{"0.0.0.0"},
+			wantNetworks: []string{"0.0.0.0/32"},
+		},
+		{
+			name:         "run from all-zeros",
+			ips:          []string{"0.0.0.0", "0.0.0.1", "0.0.0.2"},
+			wantNetworks: []string{"0.0.0.0/31", "0.0.0.2/32"},
+		},
+		{
+			name:         "run to all-ones",
+			ips:          []string{"255.255.255.253", "255.255.255.254", "255.255.255.255"},
+			wantNetworks: []string{"255.255.255.253/32", "255.255.255.254/31"},
+		},
 		{
 			name:         "empty",
 			ips:          []string{},
@@ -435,6 +457,38 @@ func TestContiguousIPsToNetworks(t *testing.T) {
 	}
 }
 
+func TestContiguousIPsToNetworksIPv6Edges(t *testing.T) {
+	tests := []struct {
+		name string
+		ips  []string
+		want []string
+	}{
+		{"unspecified alone", []string{"::"}, []string{"::/128"}},
+		{"run from unspecified", []string{"::", "::1", "::2"}, []string{"::/127", "::2/128"}},
+		{
+			"run to all-ones",
+			[]string{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+			[]string{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe/127"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ips []net.IP
+			for _, s := range tt.ips {
+				ips = append(ips, net.ParseIP(s))
+			}
+			var got []string
+			for _, n := range ContiguousIPsToNetworks(ips) {
+				got = append(got, n.String())
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestContiguousIPsToNetworksIPv6(t *testing.T) {
 	ips := []net.IP{
 		net.ParseIP("2001:db8::"),
@@ -510,3 +564,522 @@ func TestIncIP(t *testing.T) {
 		})
 	}
 }
+
+func TestFirstHost(t *testing.T) {
+	tests := []struct {
+		cidr string
+		want string
+	}{
+		{"192.168.1.0/24", "192.168.1.1"},
+		{"192.168.1.77/24", "192.168.1.1"},
+		{"10.0.0.0/30", "10.0.0.1"},
+		{"10.0.0.4/31", "10.0.0.4"},
+		{"8.8.8.8/32", "8.8.8.8"},
+		{"2001:db8::/64", "2001:db8::1"},
+		{"2001:db8::/127", "2001:db8::"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cidr, func(t *testing.T) {
+			got, err := FirstHost(tt.cidr)
+			if err != nil {
+				t.Fatalf("FirstHost(%q) error: %v", tt.cidr, err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("FirstHost(%q) = %s, want %s", tt.cidr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandUsableCIDR(t *testing.T) {
+	tests := []struct {
+		cidr string
+		want string
+	}{
+		{"192.0.2.0/30", "[192.0.2.1 192.0.2.2]"},
+		{"192.0.2.4/31", "[192.0.2.4 192.0.2.5]"},
+		{"192.0.2.9/32", "[192.0.2.9]"},
+		{"2001:db8::/126", "[2001:db8::1 2001:db8::2 2001:db8::3]"},
+		{"2001:db8::/127", "[2001:db8:: 2001:db8::1]"},
+		{"::/126", "[::1 ::2 ::3]"},
+		{"::/127", "[::1]"},
+		{"::/128", "[]"},
+		{"0.0.0.0/31", "[0.0.0.1]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cidr, func(t *testing.T) {
+			ips, err := ExpandUsableCIDR(tt.cidr, 0)
+			if err != nil {
+				t.Fatalf("ExpandUsableCIDR(%q) error: %v", tt.cidr, err)
+			}
+			if got := fmt.Sprint(ips); got != tt.want {
+				t.Errorf("ExpandUsableCIDR(%q) = %s, want %s", tt.cidr, got, tt.want)
+			}
+		})
+	}
+
+	// maxIPs counts the addresses kept, not the ones skipped
+	ips, err := ExpandUsableCIDR("2001:db8::/64", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(ips); got != "[2001:db8::1 2001:db8::2]" {
+		t.Errorf("truncated = %s, want [2001:db8::1 2001:db8::2]", got)
+	}
+}
+
+func TestParseCIDRsUsableOnly(t *testing.T) {
+	ips, sources, err := parseCIDRs([]string{"192.0.2.0/29", "::/125"}, 0, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[192.0.2.1 192.0.2.2 192.0.2.3 192.0.2.4 192.0.2.5 192.0.2.6 ::1 ::2 ::3 ::4 ::5 ::6 ::7]"
+	if got := fmt.Sprint(ips); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if len(sources) != len(ips) || sources[6] != "::/125" {
+		t.Errorf("sources = %v", sources)
+	}
+}
+
+func TestFirstHosts(t *testing.T) {
+	ips, err := FirstHosts([]string{"8.8.8.0/24", "1.1.1.0/24"})
+	if err != nil {
+		t.Fatalf("FirstHosts error: %v", err)
+	}
+	if len(ips) != 2 || ips[0].String() != "8.8.8.1" || ips[1].String() != "1.1.1.1" {
+		t.Errorf("FirstHosts = %v, want [8.8.8.1 1.1.1.1]", ips)
+	}
+
+	if _, err := FirstHosts([]string{"8.8.8.0/24", "bogus"}); err == nil {
+		t.Error("FirstHosts should reject invalid CIDR")
+	}
+}
+
+func TestRangeToCIDRs(t *testing.T) {
+	tests := []struct {
+		start, end string
+		want       string
+	}{
+		{"10.0.0.1", "10.0.0.50", "10.0.0.1/32 10.0.0.2/31 10.0.0.4/30 10.0.0.8/29 10.0.0.16/28 10.0.0.32/28 10.0.0.48/31 10.0.0.50/32"},
+		{"192.0.2.0", "192.0.2.255", "192.0.2.0/24"},
+		{"192.0.2.7", "192.0.2.7", "192.0.2.7/32"},
+		{"0.0.0.0", "255.255.255.255", "0.0.0.0/0"},
+		{"::ffff:192.0.2.0", "192.0.2.3", "192.0.2.0/30"},
+		{"2001:db8::", "2001:db8::ffff:ffff:ffff:ffff", "2001:db8::/64"},
+		{"2001:db8::1", "2001:db8::2", "2001:db8::1/128 2001:db8::2/128"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.start+"-"+tt.end, func(t *testing.T) {
+			got, err := RangeToCIDRs(tt.start, tt.end)
+			if err != nil {
+				t.Fatalf("RangeToCIDRs error: %v", err)
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("RangeToCIDRs(%s, %s) = %v, want %s", tt.start, tt.end, got, tt.want)
+			}
+		})
+	}
+
+	ips, err := ParseCIDRs(mustRange(t, "10.0.0.1", "10.0.0.50"), 0)
+	if err != nil || len(ips) != 50 || ips[0].String() != "10.0.0.1" || ips[49].String() != "10.0.0.50" {
+		t.Errorf("range expands to %d IPs (%v), want 10.0.0.1 through 10.0.0.50", len(ips), err)
+	}
+	if ips, _ := ParseCIDRs(mustRange(t, "10.0.0.1", "10.0.0.50"), 10); len(ips) != 10 {
+		t.Errorf("range with max 10 expands to %d IPs", len(ips))
+	}
+
+	bad := []struct {
+		start, end string
+		want       string
+	}{
+		{"10.0.0.50", "10.0.0.1", "start is after end"},
+		{"10.0.0.1", "2001:db8::1", "different families"},
+		{"10.0.0.0/24", "10.0.1.0", "not an IP address"},
+		{"10.0.0.1", "host.example.com", "not an IP address"},
+	}
+	for _, tt := range bad {
+		if _, err := RangeToCIDRs(tt.start, tt.end); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("RangeToCIDRs(%s, %s) error = %v, want %q", tt.start, tt.end, err, tt.want)
+		}
+	}
+}
+
+func mustRange(t *testing.T, start, end string) []string {
+	t.Helper()
+	cidrs, err := RangeToCIDRs(start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cidrs
+}
+
+func TestParseCIDRsWithSources(t *testing.T) {
+	ips, sources, err := ParseCIDRsWithSources([]string{"10.0.0.0/31", "10.0.0.2/31", "192.0.2.0/30"}, 6)
+	if err != nil {
+		t.Fatalf("ParseCIDRsWithSources error: %v", err)
+	}
+	want := []string{"10.0.0.0/31", "10.0.0.0/31", "10.0.0.2/31", "10.0.0.2/31", "192.0.2.0/30", "192.0.2.0/30"}
+	if len(ips) != len(want) || len(sources) != len(want) {
+		t.Fatalf("got %d IPs and %d sources, want %d each", len(ips), len(sources), len(want))
+	}
+	for i := range want {
+		if sources[i] != want[i] {
+			t.Errorf("sources[%d] = %q, want %q", i, sources[i], want[i])
+		}
+	}
+}
+
+func TestParseCIDRsIPv4Mapped(t *testing.T) {
+	ips, err := ParseCIDRs([]string{"::ffff:192.0.2.0/126", "::ffff:198.51.100.7/128"}, 0)
+	if err != nil {
+		t.Fatalf("ParseCIDRs error: %v", err)
+	}
+	want := []string{"192.0.2.0", "192.0.2.1", "192.0.2.2", "192.0.2.3", "198.51.100.7"}
+	if len(ips) != len(want) {
+		t.Fatalf("got %d IPs, want %d", len(ips), len(want))
+	}
+	for i, ip := range ips {
+		if len(ip) != net.IPv4len || ip.String() != want[i] {
+			t.Errorf("ips[%d] = %v (%d bytes), want 4-byte %s", i, ip, len(ip), want[i])
+		}
+	}
+
+	if size, _ := CIDRSize("::ffff:192.0.2.0/120"); size != 256 {
+		t.Errorf("CIDRSize = %d, want 256", size)
+	}
+	if kept, err := FilterFamily([]string{"::ffff:192.0.2.0/120"}, "ipv4", false); err != nil || len(kept) != 1 {
+		t.Errorf("FilterFamily(ipv4) = %v, %v; want the mapped block kept", kept, err)
+	}
+
+	// A block reaching beyond ::ffff:0:0/96 is IPv6 space
+	if _, ipnet, _ := parseCIDR("::ffff:0:0/95"); ipnet.String() != "::fffe:0:0/95" {
+		t.Errorf("parseCIDR(::ffff:0:0/95) = %v, want it left as IPv6", ipnet)
+	}
+}
+
+func TestParseCIDRsNumericIPv4(t *testing.T) {
+	tests := []struct {
+		cidr string
+		want []string
+	}{
+		{"3232235776/30", []string{"192.168.1.0", "192.168.1.1", "192.168.1.2", "192.168.1.3"}},
+		{"0xC0A80100/31", []string{"192.168.1.0", "192.168.1.1"}},
+		{"0xc0a80105/32", []string{"192.168.1.5"}},
+		{"0x0/32", []string{"0.0.0.0"}},
+		{"256/32", []string{"0.0.1.0"}},
+		{"4294967295/32", []string{"255.255.255.255"}},
+	}
+	for _, tt := range tests {
+		ips, err := ParseCIDRs([]string{tt.cidr}, 0)
+		if err != nil {
+			t.Errorf("ParseCIDRs(%q) error: %v", tt.cidr, err)
+			continue
+		}
+		var got []string
+		for _, ip := range ips {
+			got = append(got, ip.String())
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("ParseCIDRs(%q) = %v, want %v", tt.cidr, got, tt.want)
+		}
+	}
+
+	for _, cidr := range []string{"4294967296/24", "0x100000000/24"} {
+		_, err := ParseCIDRs([]string{cidr}, 0)
+		if err == nil || !strings.Contains(err.Error(), "out of the IPv4 range") {
+			t.Errorf("ParseCIDRs(%q) error = %v, want out of range", cidr, err)
+		}
+	}
+	if _, err := ParseCIDRs([]string{"0x/24"}, 0); err == nil {
+		t.Error("ParseCIDRs(0x/24) should fail")
+	}
+	// Decimal up to 255 is more likely a shortened dotted address
+	for _, cidr := range []string{"10/8", "0/32", "255/32"} {
+		_, err := ParseCIDRs([]string{cidr}, 0)
+		if err == nil || !strings.Contains(err.Error(), "ambiguous") {
+			t.Errorf("ParseCIDRs(%q) error = %v, want ambiguous", cidr, err)
+		}
+	}
+}
+
+func TestParseCIDRsArpaName(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"8.8.8.8.in-addr.arpa", []string{"8.8.8.8"}},
+		{"1.2.0.192.IN-ADDR.ARPA.", []string{"192.0.2.1"}},
+		{"2.0.192.in-addr.arpa", []string{"192.0.2.0", "192.0.2.1"}}, // /24, truncated
+		{"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa", []string{"2001:db8::1"}},
+		{"8.b.d.0.1.0.0.2.ip6.arpa.", []string{"2001:db8::", "2001:db8::1"}}, // /32, truncated
+	}
+	for _, tt := range tests {
+		ips, err := ParseCIDRs([]string{tt.name}, 2)
+		if err != nil {
+			t.Errorf("ParseCIDRs(%q) error: %v", tt.name, err)
+			continue
+		}
+		if got := fmt.Sprint(ips); got != fmt.Sprint(tt.want) {
+			t.Errorf("ParseCIDRs(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Each address round-trips through its own reverse name
+	for _, s := range []string{"203.0.113.77", "2001:db8:85a3::8a2e:370:7334"} {
+		ip := net.ParseIP(s)
+		ips, err := ParseCIDRs([]string{reverseName(ip)}, 0)
+		if err != nil || len(ips) != 1 || !ips[0].Equal(ip) {
+			t.Errorf("reverse name of %s parsed to %v, %v", s, ips, err)
+		}
+	}
+
+	malformed := []string{
+		"256.2.0.192.in-addr.arpa",
+		"01.2.0.192.in-addr.arpa",
+		"x.2.0.192.in-addr.arpa",
+		"1.1.2.0.192.in-addr.arpa",
+		"1..0.192.in-addr.arpa",
+		"0/26.2.0.192.in-addr.arpa",
+		"10.b.d.0.1.0.0.2.ip6.arpa",
+		"g.b.d.0.1.0.0.2.ip6.arpa",
+		strings.Repeat("0.", 33) + "ip6.arpa",
+	}
+	for _, name := range malformed {
+		_, err := ParseCIDRs([]string{name}, 0)
+		if err == nil || !strings.Contains(err.Error(), "malformed reverse name") {
+			t.Errorf("ParseCIDRs(%q) error = %v, want malformed reverse name", name, err)
+		}
+	}
+}
+
+func TestReadTargetsNumericIPv4(t *testing.T) {
+	targets, err := ReadTargets(strings.NewReader("3232235777\n0xC0A80102:443\n3232235776/24\n"))
+	if err != nil {
+		t.Fatalf("ReadTargets error: %v", err)
+	}
+	want := []string{"192.168.1.1/32", "192.168.1.2/32", "3232235776/24"}
+	if strings.Join(targets, " ") != strings.Join(want, " ") {
+		t.Errorf("targets = %v, want %v", targets, want)
+	}
+
+	if _, err := ReadTargets(strings.NewReader("99999999999\n")); err == nil || !strings.Contains(err.Error(), "out of the IPv4 range") {
+		t.Errorf("ReadTargets error = %v, want out of range", err)
+	}
+	if _, err := ReadTargets(strings.NewReader("10\n")); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("ReadTargets error = %v, want ambiguous", err)
+	}
+}
+
+func TestParseCIDRsPerCIDR(t *testing.T) {
+	cidrs := []string{"10.0.0.0/24", "10.0.1.0/24", "192.0.2.0/30", "2001:db8::/64"}
+
+	count := func(sources []string) map[string]int {
+		counts := make(map[string]int)
+		for _, s := range sources {
+			counts[s]++
+		}
+		return counts
+	}
+
+	// A shared budget is used up by the first block
+	_, sources, err := ParseCIDRsWithSources(cidrs, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := count(sources); c["10.0.0.0/24"] != 8 || len(c) != 1 {
+		t.Errorf("shared budget: per-CIDR counts = %v, want only 10.0.0.0/24", c)
+	}
+
+	// Per CIDR, every block gets up to the limit
+	ips, sources, err := ParseCIDRsPerCIDR(cidrs, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"10.0.0.0/24": 8, "10.0.1.0/24": 8, "192.0.2.0/30": 4, "2001:db8::/64": 8}
+	if c := count(sources); fmt.Sprint(c) != fmt.Sprint(want) {
+		t.Errorf("per-CIDR counts = %v, want %v", c, want)
+	}
+	if len(ips) != 28 || ips[8].String() != "10.0.1.0" {
+		t.Errorf("got %d IPs starting the second block at %v, want 28 from 10.0.1.0", len(ips), ips[8])
+	}
+
+	if _, _, err := ParseCIDRsPerCIDR([]string{"bogus"}, 8); err == nil {
+		t.Error("expected error for invalid CIDR")
+	}
+}
+
+func TestFilterFamily(t *testing.T) {
+	mixed := []string{"192.0.2.0/24", "2001:db8::/64", "10.0.0.0/30"}
+
+	tests := []struct {
+		name    string
+		family  string
+		drop    bool
+		want    []string
+		wantErr string
+	}{
+		{name: "no family", want: mixed},
+		{name: "ipv4 rejects", family: "ipv4", wantErr: `"2001:db8::/64" is IPv6`},
+		{name: "ipv6 rejects", family: "ipv6", wantErr: `"192.0.2.0/24" is IPv4`},
+		{name: "ipv4 drops", family: "ipv4", drop: true, want: []string{"192.0.2.0/24", "10.0.0.0/30"}},
+		{name: "ipv6 drops", family: "ipv6", drop: true, want: []string{"2001:db8::/64"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FilterFamily(mixed, tt.family, tt.drop)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := FilterFamily([]string{"bogus"}, "ipv4", false); err == nil {
+		t.Error("expected error for invalid CIDR")
+	}
+}
+
+func TestExcludeCIDRs(t *testing.T) {
+	tests := []struct {
+		name     string
+		cidrs    []string
+		excludes []string
+		want     []string
+	}{
+		{
+			name:  "no exclusions",
+			cidrs: []string{"10.0.0.0/8"},
+			want:  []string{"10.0.0.0/8"},
+		},
+		{
+			name:     "nested /16 in /8",
+			cidrs:    []string{"10.0.0.0/8"},
+			excludes: []string{"10.1.0.0/16"},
+			want: []string{
+				"10.0.0.0/16", "10.2.0.0/15", "10.4.0.0/14", "10.8.0.0/13",
+				"10.16.0.0/12", "10.32.0.0/11", "10.64.0.0/10", "10.128.0.0/9",
+			},
+		},
+		{
+			name:     "nested exclusions inside each other",
+			cidrs:    []string{"192.0.2.0/24"},
+			excludes: []string{"192.0.2.0/25", "192.0.2.0/26"},
+			want:     []string{"192.0.2.128/25"},
+		},
+		{
+			name:     "adjacent exclusions",
+			cidrs:    []string{"192.0.2.0/24"},
+			excludes: []string{"192.0.2.64/26", "192.0.2.128/26"},
+			want:     []string{"192.0.2.0/26", "192.0.2.192/26"},
+		},
+		{
+			name:     "bare IP",
+			cidrs:    []string{"192.0.2.0/30"},
+			excludes: []string{"192.0.2.2"},
+			want:     []string{"192.0.2.0/31", "192.0.2.3/32"},
+		},
+		{
+			name:     "exclusion covers input",
+			cidrs:    []string{"192.0.2.0/28", "198.51.100.0/30"},
+			excludes: []string{"192.0.2.0/24"},
+			want:     []string{"198.51.100.0/30"},
+		},
+		{
+			name:     "disjoint and other family untouched",
+			cidrs:    []string{"192.0.2.0/30", "2001:db8::/126"},
+			excludes: []string{"198.51.100.0/24", "2001:db8::3"},
+			want:     []string{"192.0.2.0/30", "2001:db8::/127", "2001:db8::2/128"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExcludeCIDRs(tt.cidrs, tt.excludes)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ExcludeCIDRs([]string{"10.0.0.0/8"}, []string{"bogus"}); err == nil {
+		t.Error("expected error for invalid exclusion")
+	}
+}
+
+func TestTotalAddresses(t *testing.T) {
+	tests := []struct {
+		cidrs []string
+		want  string
+	}{
+		{[]string{"10.0.0.0/8"}, "16777216"},
+		{[]string{"10.0.0.0/30", "192.0.2.1/32"}, "5"},
+		{[]string{"2001:db8::/64"}, "18446744073709551616"},
+		{[]string{"::/0"}, "340282366920938463463374607431768211456"},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.cidrs, ","), func(t *testing.T) {
+			got, err := TotalAddresses(tt.cidrs)
+			if err != nil {
+				t.Fatalf("TotalAddresses error: %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("TotalAddresses = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := TotalAddresses([]string{"bogus"}); err == nil {
+		t.Error("expected error for invalid CIDR")
+	}
+}
+
+func TestReadTargets(t *testing.T) {
+	input := `# exported from the asset spreadsheet
+10.0.0.1 # web server
+192.0.2.0/30	office
+   # indented comment
+
+198.51.100.7:443 lb
+[2001:db8::1]:8443
+2001:db8:1::/126
+`
+	got, err := ReadTargets(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ReadTargets error: %v", err)
+	}
+	want := []string{"10.0.0.1/32", "192.0.2.0/30", "198.51.100.7/32", "2001:db8::1/128", "2001:db8:1::/126"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("ReadTargets = %v, want %v", got, want)
+	}
+
+	_, err = ReadTargets(strings.NewReader("10.0.0.0/24\nnot-an-ip # oops\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") || !strings.Contains(err.Error(), "not-an-ip") {
+		t.Errorf("err = %v, want error naming line 2 and the bad target", err)
+	}
+
+	// Reverse names are kept for parseCIDR; malformed ones say why
+	got, err = ReadTargets(strings.NewReader("8.8.8.8.in-addr.arpa ; dig answer\n"))
+	if err != nil || len(got) != 1 || got[0] != "8.8.8.8.in-addr.arpa" {
+		t.Errorf("ReadTargets(arpa) = %v, %v", got, err)
+	}
+	_, err = ReadTargets(strings.NewReader("300.8.8.8.in-addr.arpa\n"))
+	if err == nil || !strings.Contains(err.Error(), "not an octet") {
+		t.Errorf("err = %v, want malformed label named", err)
+	}
+}
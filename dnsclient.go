@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultDNSTimeout bounds a single query made by DNSClient.
+const DefaultDNSTimeout = 5 * time.Second
+
+// maxCNAMEHops limits how many CNAMEs DNSClient follows for one lookup.
+const maxCNAMEHops = 8
+
+// DNSClient is a minimal DNS client built on dnsmessage. Unlike net.Resolver
+// it sees the whole response, so it can follow CNAMEs itself. This matters for
+// RFC 2317 classless delegation (/25-/31), where the reverse name is a CNAME
+// into the delegated zone and the server may not include the final PTR.
+type DNSClient struct {
+	Server  string        // host:port, or the https:// URL for DoH
+	Timeout time.Duration // Per query; 0 means DefaultDNSTimeout
+
+	// HTTPClient, if set, sends queries as DNS-over-HTTPS to the URL in
+	// Server (see NewDoHClient).
+	HTTPClient *http.Client
+
+	// ClientSubnet, if set, is sent as an EDNS Client Subnet option (RFC 7871).
+	ClientSubnet *net.IPNet
+
+	// DNSSEC sets the EDNS DO bit (RFC 3225), asking for RRSIG records
+	// with the answer, and the AD bit, asking a validating resolver to
+	// report whether it checked them (RFC 6840 5.7).
+	DNSSEC bool
+
+	// LocalAddr, if set, is the source address queries are sent from.
+	LocalAddr net.IP
+
+	// DialTimeout, if set, bounds connecting to the server (TCP and DoH;
+	// UDP needs no handshake). Timeout still bounds the whole exchange.
+	DialTimeout time.Duration
+}
+
+// ednsUDPSize is the UDP payload size advertised when EDNS is in use.
+const ednsUDPSize = 1232
+
+// optionClientSubnet is the EDNS option code for Client Subnet (RFC 7871).
+const optionClientSubnet = 8
+
+// typeRRSIG is the RRSIG record type, which dnsmessage has no constant for.
+const typeRRSIG dnsmessage.Type = 46
+
+// PTRResponse is the parsed answer to a PTR lookup.
+type PTRResponse struct {
+	Names  []string // PTR targets, as returned (with trailing dots)
+	CNAMEs []string // CNAME targets followed from the reverse name, in order
+
+	// Authoritative is the AA bit of the response holding the PTR records.
+	// DNS has no flag for cached answers: a recursive resolver clears AA
+	// on anything it answers from its cache or fetched from upstream.
+	Authoritative bool
+
+	// With DNSClient.DNSSEC: Signed reports RRSIG records covering the
+	// PTRs, and AuthenticData is the AD bit, set by a validating resolver
+	// once those signatures check out.
+	Signed        bool
+	AuthenticData bool
+}
+
+// answerFlags describes the response that held the records resolve found.
+type answerFlags struct {
+	authoritative bool // AA bit
+	authenticData bool // AD bit
+	signed        bool // RRSIGs cover the records
+}
+
+// NewDNSClient returns a client for the given server. The server can be an
+// IP, hostname, or host:port; if no port is given, :53 is used.
+func NewDNSClient(server string) (*DNSClient, error) {
+	addr, err := normalizeServer(server)
+	if err != nil {
+		return nil, err
+	}
+	return &DNSClient{Server: addr}, nil
+}
+
+// systemNameserver returns the first nameserver from /etc/resolv.conf,
+// falling back to the local host if none is configured.
+func systemNameserver() string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "127.0.0.1:53"
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			if addr, err := normalizeServer(fields[1]); err == nil {
+				return addr
+			}
+		}
+	}
+	return "127.0.0.1:53"
+}
+
+// ServerAddr returns the server the client queries.
+func (c *DNSClient) ServerAddr() string {
+	return c.Server
+}
+
+// LookupAddr implements Resolver.
+func (c *DNSClient) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	resp, err := c.LookupPTR(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Names, nil
+}
+
+// LookupPTR queries the PTR records for an IP address, re-querying CNAME
+// targets that the server returned without their PTR records. Errors are
+// *net.DNSError, with IsNotFound set for NXDOMAIN and empty answers.
+func (c *DNSClient) LookupPTR(ctx context.Context, addr string) (*PTRResponse, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, &net.DNSError{Err: "unrecognized address", Name: addr}
+	}
+
+	records, chain, flags, err := c.resolve(ctx, reverseName(ip)+".", dnsmessage.TypePTR, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &PTRResponse{
+		CNAMEs:        chain,
+		Authoritative: flags.authoritative,
+		Signed:        flags.signed,
+		AuthenticData: flags.authenticData,
+	}
+	for _, r := range records {
+		resp.Names = append(resp.Names, r.Body.(*dnsmessage.PTRResource).PTR.String())
+	}
+	return resp, nil
+}
+
+// LookupIPAddr looks up the A and AAAA records for host, following CNAMEs.
+// It satisfies ForwardResolver.
+func (c *DNSClient) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	name := host
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+
+	var addrs []net.IPAddr
+	var firstErr error
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		records, _, _, err := c.resolve(ctx, name, qtype, host)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for _, r := range records {
+			switch body := r.Body.(type) {
+			case *dnsmessage.AResource:
+				addrs = append(addrs, net.IPAddr{IP: net.IP(body.A[:])})
+			case *dnsmessage.AAAAResource:
+				addrs = append(addrs, net.IPAddr{IP: net.IP(body.AAAA[:])})
+			}
+		}
+	}
+
+	if len(addrs) == 0 {
+		return nil, firstErr
+	}
+	return addrs, nil
+}
+
+// resolve queries name for qtype, re-querying CNAME targets that the server
+// returned without the requested records, and returns the records owned by
+// the final name, the CNAME targets followed to reach it, and the flags of
+// the response holding the records. Errors are *net.DNSError naming errName.
+func (c *DNSClient) resolve(ctx context.Context, name string, qtype dnsmessage.Type, errName string) ([]dnsmessage.Resource, []string, answerFlags, error) {
+	var chain []string
+	for len(chain) <= maxCNAMEHops {
+		msg, err := c.exchange(ctx, name, qtype)
+		if err != nil {
+			var netErr net.Error
+			timeout := errors.As(err, &netErr) && netErr.Timeout()
+			return nil, nil, answerFlags{}, &net.DNSError{Err: err.Error(), Name: errName, Server: c.Server, IsTimeout: timeout}
+		}
+
+		switch msg.RCode {
+		case dnsmessage.RCodeSuccess:
+		case dnsmessage.RCodeNameError:
+			return nil, nil, answerFlags{}, &net.DNSError{Err: "no such host", Name: errName, Server: c.Server, IsNotFound: true}
+		default:
+			return nil, nil, answerFlags{}, &net.DNSError{Err: "server misbehaving: " + msg.RCode.String(), Name: errName, Server: c.Server}
+		}
+
+		target, followed := followCNAMEs(msg.Answers, name)
+		chain = append(chain, followed...)
+		if records := recordsFor(msg.Answers, target, qtype); len(records) > 0 {
+			flags := answerFlags{
+				authoritative: msg.Authoritative,
+				authenticData: msg.AuthenticData,
+				signed:        len(recordsFor(msg.Answers, target, typeRRSIG)) > 0,
+			}
+			return records, chain, flags, nil
+		}
+		if len(followed) == 0 {
+			// NOERROR without records: nothing of this type for the name
+			return nil, nil, answerFlags{}, &net.DNSError{Err: "no such host", Name: errName, Server: c.Server, IsNotFound: true}
+		}
+		name = target
+	}
+
+	return nil, nil, answerFlags{}, &net.DNSError{Err: "too many CNAMEs", Name: errName, Server: c.Server}
+}
+
+// followCNAMEs walks the CNAME records in answers starting at name and
+// returns the final target along with each target visited.
+func followCNAMEs(answers []dnsmessage.Resource, name string) (string, []string) {
+	var chain []string
+	for len(chain) <= maxCNAMEHops {
+		next := ""
+		for _, a := range answers {
+			if cname, ok := a.Body.(*dnsmessage.CNAMEResource); ok && strings.EqualFold(a.Header.Name.String(), name) {
+				next = cname.CNAME.String()
+				break
+			}
+		}
+		if next == "" {
+			break
+		}
+		chain = append(chain, next)
+		name = next
+	}
+	return name, chain
+}
+
+// recordsFor returns the records of type qtype in answers owned by name.
+func recordsFor(answers []dnsmessage.Resource, name string, qtype dnsmessage.Type) []dnsmessage.Resource {
+	var records []dnsmessage.Resource
+	for _, a := range answers {
+		if a.Header.Type == qtype && strings.EqualFold(a.Header.Name.String(), name) {
+			records = append(records, a)
+		}
+	}
+	return records
+}
+
+// exchange sends a single query over UDP, retrying over TCP if the response
+// is truncated, or over HTTPS for a DoH client.
+func (c *DNSClient) exchange(ctx context.Context, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	id := uint16(rand.Uint32())
+	if c.HTTPClient != nil {
+		id = 0 // RFC 8484 4.1: lets HTTP caches match identical queries
+	}
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true, AuthenticData: c.DNSSEC},
+		Questions: []dnsmessage.Question{{
+			Name:  qname,
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	if opt, ok := c.optRecord(); ok {
+		query.Additionals = append(query.Additionals, opt)
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultDNSTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if c.HTTPClient != nil {
+		return c.dohRoundTrip(ctx, packed, id)
+	}
+	msg, err := c.roundTrip(ctx, "udp", packed, id)
+	if err == nil && msg.Truncated {
+		msg, err = c.roundTrip(ctx, "tcp", packed, id)
+	}
+	return msg, err
+}
+
+// optRecord returns the EDNS OPT record for outgoing queries, if any
+// EDNS options or the DO bit are configured.
+func (c *DNSClient) optRecord() (dnsmessage.Resource, bool) {
+	var options []dnsmessage.Option
+	if c.ClientSubnet != nil {
+		options = append(options, clientSubnetOption(c.ClientSubnet))
+	}
+	if len(options) == 0 && !c.DNSSEC {
+		return dnsmessage.Resource{}, false
+	}
+
+	var hdr dnsmessage.ResourceHeader
+	if err := hdr.SetEDNS0(ednsUDPSize, dnsmessage.RCodeSuccess, c.DNSSEC); err != nil {
+		return dnsmessage.Resource{}, false
+	}
+	return dnsmessage.Resource{Header: hdr, Body: &dnsmessage.OPTResource{Options: options}}, true
+}
+
+// clientSubnetOption encodes subnet as an ECS option: family, source prefix
+// length, scope prefix length (0 in queries), then the address truncated to
+// the bytes the prefix covers.
+func clientSubnetOption(subnet *net.IPNet) dnsmessage.Option {
+	ones, _ := subnet.Mask.Size()
+	family := uint16(2)
+	addr := subnet.IP.To16()
+	if ip4 := subnet.IP.To4(); ip4 != nil {
+		family = 1
+		addr = ip4
+	}
+	addr = addr.Mask(subnet.Mask)
+
+	data := make([]byte, 4, 4+(ones+7)/8)
+	binary.BigEndian.PutUint16(data, family)
+	data[2] = byte(ones)
+	data = append(data, addr[:(ones+7)/8]...)
+	return dnsmessage.Option{Code: optionClientSubnet, Data: data}
+}
+
+// roundTrip writes a packed query to the server and reads the matching reply.
+func (c *DNSClient) roundTrip(ctx context.Context, network string, query []byte, id uint16) (*dnsmessage.Message, error) {
+	d := localDialer(network, c.LocalAddr, c.DialTimeout)
+	conn, err := d.DialContext(ctx, network, c.Server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if network == "tcp" {
+		framed := make([]byte, 2+len(query))
+		binary.BigEndian.PutUint16(framed, uint16(len(query)))
+		copy(framed[2:], query)
+		if _, err := conn.Write(framed); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+		return unpackReply(buf, id)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		msg, err := unpackReply(buf[:n], id)
+		if err != nil {
+			continue // stray or malformed datagram; keep waiting
+		}
+		return msg, nil
+	}
+}
+
+// unpackReply parses a response and checks that it answers query id.
+func unpackReply(buf []byte, id uint16) (*dnsmessage.Message, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(buf); err != nil {
+		return nil, err
+	}
+	if !msg.Response || msg.ID != id {
+		return nil, fmt.Errorf("unexpected DNS message (id %d)", msg.ID)
+	}
+	return &msg, nil
+}
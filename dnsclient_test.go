@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeDNS is a UDP DNS server answering from a fixed record set.
+type fakeDNS struct {
+	conn    net.PacketConn
+	mu      sync.Mutex
+	records map[string][]dnsmessage.Resource // lowercase query name -> answers
+	queries []dnsmessage.Message             // every query received, in order
+
+	authoritative bool // set the AA bit on replies
+	authenticData bool // set the AD bit on replies
+}
+
+// startFakeDNS starts a fake server on localhost that is closed when the test ends.
+func startFakeDNS(t testing.TB) *fakeDNS {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeDNS{conn: conn, records: make(map[string][]dnsmessage.Resource)}
+	t.Cleanup(func() { conn.Close() })
+	go f.serve()
+	return f
+}
+
+// Queries returns a copy of the queries received so far.
+func (f *fakeDNS) Queries() []dnsmessage.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]dnsmessage.Message(nil), f.queries...)
+}
+
+func (f *fakeDNS) Addr() string {
+	return f.conn.LocalAddr().String()
+}
+
+// rr builds a resource record owned by name.
+func rr(name string, body dnsmessage.ResourceBody) dnsmessage.Resource {
+	var rtype dnsmessage.Type
+	switch body.(type) {
+	case *dnsmessage.PTRResource:
+		rtype = dnsmessage.TypePTR
+	case *dnsmessage.CNAMEResource:
+		rtype = dnsmessage.TypeCNAME
+	case *dnsmessage.AResource:
+		rtype = dnsmessage.TypeA
+	case *dnsmessage.AAAAResource:
+		rtype = dnsmessage.TypeAAAA
+	case *dnsmessage.UnknownResource:
+		rtype = body.(*dnsmessage.UnknownResource).Type
+	}
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  rtype,
+			Class: dnsmessage.ClassINET,
+			TTL:   300,
+		},
+		Body: body,
+	}
+}
+
+// AddAnswer adds a record to the answer for queries of qname.
+func (f *fakeDNS) AddAnswer(qname string, r dnsmessage.Resource) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := strings.ToLower(qname)
+	f.records[key] = append(f.records[key], r)
+}
+
+// AddPTR adds a PTR record.
+func (f *fakeDNS) AddPTR(name, target string) {
+	f.AddAnswer(name, rr(name, &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(target)}))
+}
+
+// AddRRSIG adds an RRSIG record, with a dummy signature, to the answer for
+// name, as a signed zone returns it alongside the records.
+func (f *fakeDNS) AddRRSIG(name string) {
+	f.AddAnswer(name, rr(name, &dnsmessage.UnknownResource{Type: typeRRSIG, Data: []byte{0, 12, 13, 2}}))
+}
+
+// AddCNAME adds a CNAME record.
+func (f *fakeDNS) AddCNAME(name, target string) {
+	f.AddAnswer(name, rr(name, &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName(target)}))
+}
+
+// AddA adds an A or AAAA record, depending on the address family.
+func (f *fakeDNS) AddA(name, ip string) {
+	addr := net.ParseIP(ip)
+	if ip4 := addr.To4(); ip4 != nil {
+		f.AddAnswer(name, rr(name, &dnsmessage.AResource{A: [4]byte(ip4)}))
+		return
+	}
+	f.AddAnswer(name, rr(name, &dnsmessage.AAAAResource{AAAA: [16]byte(addr.To16())}))
+}
+
+func (f *fakeDNS) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := f.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var query dnsmessage.Message
+		if err := query.Unpack(buf[:n]); err != nil || len(query.Questions) == 0 {
+			continue
+		}
+		reply := f.answer(query)
+		packed, err := reply.Pack()
+		if err != nil {
+			continue
+		}
+		_, _ = f.conn.WriteTo(packed, addr)
+	}
+}
+
+func (f *fakeDNS) answer(query dnsmessage.Message) dnsmessage.Message {
+	q := query.Questions[0]
+	reply := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 query.ID,
+			Response:           true,
+			RecursionDesired:   query.RecursionDesired,
+			RecursionAvailable: true,
+		},
+		Questions: query.Questions,
+	}
+
+	f.mu.Lock()
+	reply.Authoritative = f.authoritative
+	reply.AuthenticData = f.authenticData
+	f.queries = append(f.queries, query)
+	answers, ok := f.records[strings.ToLower(q.Name.String())]
+	f.mu.Unlock()
+	if !ok {
+		reply.RCode = dnsmessage.RCodeNameError
+		return reply
+	}
+	for _, a := range answers {
+		// Answer with records of the queried type, plus any CNAMEs and
+		// signatures
+		if a.Header.Type == q.Type || a.Header.Type == dnsmessage.TypeCNAME || a.Header.Type == typeRRSIG {
+			reply.Answers = append(reply.Answers, a)
+		}
+	}
+	return reply
+}
+
+func TestDNSClientLookupAddr(t *testing.T) {
+	srv := startFakeDNS(t)
+	srv.AddPTR("1.2.0.192.in-addr.arpa.", "host.example.com.")
+
+	client, err := NewDNSClient(srv.Addr())
+	if err != nil {
+		t.Fatalf("NewDNSClient error: %v", err)
+	}
+
+	names, err := client.LookupAddr(context.Background(), "192.0.2.1")
+	if err != nil {
+		t.Fatalf("LookupAddr error: %v", err)
+	}
+	if len(names) != 1 || names[0] != "host.example.com." {
+		t.Errorf("names = %v, want [host.example.com.]", names)
+	}
+}
+
+func TestDNSClientLocalAddr(t *testing.T) {
+	srv := startFakeDNS(t)
+	srv.AddPTR("1.2.0.192.in-addr.arpa.", "host.example.com.")
+
+	client, err := NewDNSClient(srv.Addr())
+	if err != nil {
+		t.Fatalf("NewDNSClient error: %v", err)
+	}
+	client.LocalAddr = net.ParseIP("127.0.0.1")
+
+	if _, err := client.LookupAddr(context.Background(), "192.0.2.1"); err != nil {
+		t.Fatalf("LookupAddr from 127.0.0.1 error: %v", err)
+	}
+
+	// An address the host doesn't have cannot be bound
+	client.LocalAddr = net.ParseIP("192.0.2.99")
+	if _, err := client.LookupAddr(context.Background(), "192.0.2.1"); err == nil {
+		t.Error("expected error binding to a foreign address")
+	}
+}
+
+func TestDNSClientNXDomain(t *testing.T) {
+	srv := startFakeDNS(t)
+	client, _ := NewDNSClient(srv.Addr())
+
+	_, err := client.LookupAddr(context.Background(), "192.0.2.1")
+	dnsErr, ok := err.(*net.DNSError)
+	if !ok || !dnsErr.IsNotFound {
+		t.Errorf("err = %v, want not-found DNSError", err)
+	}
+
+	// lookupIP must report it as NXDOMAIN, not an error
+	result := lookupIP(context.Background(), net.ParseIP("192.0.2.1"), client)
+	if result.Error != nil || result.PTR != "" {
+		t.Errorf("lookupIP = %+v, want NXDOMAIN", result)
+	}
+}
+
+func TestDNSClientRFC2317(t *testing.T) {
+	// 192.0.2.128/26 is delegated via CNAMEs into 128-26.2.0.192.in-addr.arpa
+	srv := startFakeDNS(t)
+
+	// Server returns the CNAME only; the client must query the target itself
+	srv.AddCNAME("130.2.0.192.in-addr.arpa.", "130.128-26.2.0.192.in-addr.arpa.")
+	srv.AddPTR("130.128-26.2.0.192.in-addr.arpa.", "delegated.example.com.")
+
+	// Server returns the CNAME and the PTR together
+	srv.AddCNAME("131.2.0.192.in-addr.arpa.", "131.128-26.2.0.192.in-addr.arpa.")
+	srv.AddAnswer("131.2.0.192.in-addr.arpa.",
+		rr("131.128-26.2.0.192.in-addr.arpa.", &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("inline.example.com.")}))
+
+	client, _ := NewDNSClient(srv.Addr())
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"192.0.2.130", "delegated.example.com"},
+		{"192.0.2.131", "inline.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			result := lookupIP(context.Background(), net.ParseIP(tt.ip), client)
+			if result.Error != nil {
+				t.Fatalf("lookupIP error: %v", result.Error)
+			}
+			if result.PTR != tt.want {
+				t.Errorf("PTR = %q, want %q", result.PTR, tt.want)
+			}
+			// The chain is recorded whether or not the server inlined the PTR
+			if len(result.CNAMEs) != 1 || !strings.Contains(result.CNAMEs[0], ".128-26.2.0.192.in-addr.arpa") {
+				t.Errorf("CNAMEs = %v, want one hop into 128-26.2.0.192.in-addr.arpa", result.CNAMEs)
+			}
+		})
+	}
+}
+
+func TestDNSClientCNAMELoop(t *testing.T) {
+	srv := startFakeDNS(t)
+	srv.AddCNAME("1.2.0.192.in-addr.arpa.", "loop.example.com.")
+	srv.AddCNAME("loop.example.com.", "1.2.0.192.in-addr.arpa.")
+
+	client, _ := NewDNSClient(srv.Addr())
+	if _, err := client.LookupAddr(context.Background(), "192.0.2.1"); err == nil {
+		t.Error("expected error for CNAME loop")
+	}
+}
+
+func TestDNSClientAuthoritative(t *testing.T) {
+	srv := startFakeDNS(t)
+	srv.AddPTR("1.2.0.192.in-addr.arpa.", "host.example.com.")
+	client, _ := NewDNSClient(srv.Addr())
+
+	for _, aa := range []bool{false, true} {
+		srv.mu.Lock()
+		srv.authoritative = aa
+		srv.mu.Unlock()
+
+		result := lookupIP(context.Background(), net.ParseIP("192.0.2.1"), client)
+		if result.Error != nil {
+			t.Fatalf("lookupIP error: %v", result.Error)
+		}
+		if result.Authoritative != aa {
+			t.Errorf("Authoritative = %v, want %v", result.Authoritative, aa)
+		}
+	}
+}
+
+func TestDNSClientDNSSEC(t *testing.T) {
+	srv := startFakeDNS(t)
+	srv.AddPTR("1.2.0.192.in-addr.arpa.", "signed.example.com.")
+	srv.AddRRSIG("1.2.0.192.in-addr.arpa.")
+	srv.AddPTR("2.2.0.192.in-addr.arpa.", "unsigned.example.com.")
+	client, _ := NewDNSClient(srv.Addr())
+	client.DNSSEC = true
+
+	tests := []struct {
+		ip         string
+		replyAD    bool
+		wantSigned bool
+		wantAD     bool
+	}{
+		{"192.0.2.1", true, true, true},
+		{"192.0.2.1", false, true, false}, // signed, but the resolver doesn't validate
+		{"192.0.2.2", false, false, false},
+	}
+	for _, tt := range tests {
+		srv.mu.Lock()
+		srv.authenticData = tt.replyAD
+		srv.mu.Unlock()
+
+		result := lookupIP(context.Background(), net.ParseIP(tt.ip), client)
+		if result.Error != nil {
+			t.Fatalf("lookupIP(%s) error: %v", tt.ip, result.Error)
+		}
+		if result.Signed != tt.wantSigned || result.AuthenticData != tt.wantAD {
+			t.Errorf("%s (AD reply %v): Signed = %v, AuthenticData = %v, want %v, %v",
+				tt.ip, tt.replyAD, result.Signed, result.AuthenticData, tt.wantSigned, tt.wantAD)
+		}
+		if result.PTR == "" {
+			t.Errorf("%s: RRSIG should not hide the PTR", tt.ip)
+		}
+	}
+
+	// Every query asked for DNSSEC records (DO) and validation (AD)
+	for _, q := range srv.Queries() {
+		if !q.AuthenticData || len(q.Additionals) != 1 || !q.Additionals[0].Header.DNSSECAllowed() {
+			t.Errorf("query %s: AD = %v, additionals = %v, want AD and the DO bit", q.Questions[0].Name, q.AuthenticData, q.Additionals)
+		}
+	}
+}
+
+func TestDNSClientWithoutDNSSEC(t *testing.T) {
+	srv := startFakeDNS(t)
+	srv.AddPTR("1.2.0.192.in-addr.arpa.", "host.example.com.")
+	client, _ := NewDNSClient(srv.Addr())
+	if _, err := client.LookupAddr(context.Background(), "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	// No EDNS unless something needs it
+	if q := srv.Queries(); len(q) != 1 || q[0].AuthenticData || len(q[0].Additionals) != 0 {
+		t.Errorf("query = %+v, want no AD bit and no OPT record", q)
+	}
+}
+
+func TestDNSClientLookupIPAddr(t *testing.T) {
+	srv := startFakeDNS(t)
+	srv.AddCNAME("www.example.com.", "host.example.com.")
+	srv.AddA("host.example.com.", "192.0.2.1")
+	srv.AddA("host.example.com.", "2001:db8::1")
+
+	client, _ := NewDNSClient(srv.Addr())
+	addrs, err := client.LookupIPAddr(context.Background(), "www.example.com")
+	if err != nil {
+		t.Fatalf("LookupIPAddr error: %v", err)
+	}
+	if len(addrs) != 2 || !addrs[0].IP.Equal(net.ParseIP("192.0.2.1")) || !addrs[1].IP.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("addrs = %v, want [192.0.2.1 2001:db8::1]", addrs)
+	}
+
+	if !forwardConfirms(context.Background(), net.ParseIP("192.0.2.1"), "www.example.com", client) {
+		t.Error("forwardConfirms should match via CNAME")
+	}
+}
+
+func TestClientSubnetOption(t *testing.T) {
+	tests := []struct {
+		cidr string
+		want []byte
+	}{
+		{"192.0.2.0/24", []byte{0, 1, 24, 0, 192, 0, 2}},
+		{"198.51.100.77/20", []byte{0, 1, 20, 0, 198, 51, 96}},
+		{"2001:db8::/48", []byte{0, 2, 48, 0, 0x20, 0x01, 0x0d, 0xb8, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cidr, func(t *testing.T) {
+			_, subnet, _ := net.ParseCIDR(tt.cidr)
+			opt := clientSubnetOption(subnet)
+			if opt.Code != optionClientSubnet {
+				t.Errorf("code = %d, want %d", opt.Code, optionClientSubnet)
+			}
+			if string(opt.Data) != string(tt.want) {
+				t.Errorf("data = %v, want %v", opt.Data, tt.want)
+			}
+		})
+	}
+}
+
+func TestDNSClientSendsClientSubnet(t *testing.T) {
+	srv := startFakeDNS(t)
+	srv.AddPTR("1.2.0.192.in-addr.arpa.", "host.example.com.")
+
+	client, _ := NewDNSClient(srv.Addr())
+	_, client.ClientSubnet, _ = net.ParseCIDR("198.51.100.0/24")
+	if _, err := client.LookupAddr(context.Background(), "192.0.2.1"); err != nil {
+		t.Fatalf("LookupAddr error: %v", err)
+	}
+
+	queries := srv.Queries()
+	if len(queries) != 1 || len(queries[0].Additionals) != 1 {
+		t.Fatalf("expected one query with an OPT record, got %+v", queries)
+	}
+	opt, ok := queries[0].Additionals[0].Body.(*dnsmessage.OPTResource)
+	if !ok || len(opt.Options) != 1 || opt.Options[0].Code != optionClientSubnet {
+		t.Errorf("OPT record = %+v, want one ECS option", queries[0].Additionals[0].Body)
+	}
+
+	// Without a subnet, no OPT record is sent
+	plain, _ := NewDNSClient(srv.Addr())
+	_, _ = plain.LookupAddr(context.Background(), "192.0.2.1")
+	if q := srv.Queries(); len(q[1].Additionals) != 0 {
+		t.Errorf("unexpected additionals without ECS: %+v", q[1].Additionals)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dohIdleTimeout is how long an idle DoH connection is kept for reuse.
+const dohIdleTimeout = 90 * time.Second
+
+// NewDoHClient returns a DNSClient that sends queries to a DNS-over-HTTPS
+// endpoint (RFC 8484) instead of over UDP. All queries share one HTTP client
+// so connections are reused: over HTTP/2 the whole worker pool multiplexes
+// onto one connection, and if the server only speaks HTTP/1.1, up to
+// concurrency connections are kept idle rather than re-dialled per query.
+// dialTimeout bounds each new connection (0 = no bound beyond the query's).
+func NewDoHClient(endpoint string, concurrency int, dialTimeout time.Duration) (*DNSClient, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid DoH URL %q: want https://host/path", endpoint)
+	}
+
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         (&net.Dialer{Timeout: dialTimeout}).DialContext,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        max(concurrency, 1),
+		MaxIdleConnsPerHost: max(concurrency, 1),
+		IdleConnTimeout:     dohIdleTimeout,
+		TLSHandshakeTimeout: DefaultDNSTimeout,
+	}
+	return &DNSClient{
+		Server:      endpoint,
+		HTTPClient:  &http.Client{Transport: transport},
+		DialTimeout: dialTimeout,
+	}, nil
+}
+
+// dohRoundTrip POSTs a packed query to the DoH endpoint in c.Server and
+// parses the reply.
+func (c *DNSClient) dohRoundTrip(ctx context.Context, query []byte, id uint16) (*dnsmessage.Message, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Server, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// Drain so the connection can be reused
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("DoH server returned %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return nil, err
+	}
+	return unpackReply(body, id)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// startFakeDoH serves f's records over DNS-over-HTTPS with HTTP/2 enabled.
+// conns counts the connections the server accepts. The server is closed
+// when the test ends.
+func startFakeDoH(t testing.TB, f *fakeDNS) (srv *httptest.Server, conns *atomic.Int64) {
+	t.Helper()
+	conns = new(atomic.Int64)
+	srv = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var query dnsmessage.Message
+		if err := query.Unpack(body); err != nil || len(query.Questions) == 0 {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		reply := f.answer(query)
+		packed, err := reply.Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(packed)
+	}))
+	srv.EnableHTTP2 = true
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv, conns
+}
+
+// newTestDoHClient returns a DoH client for srv that trusts its certificate.
+func newTestDoHClient(t testing.TB, srv *httptest.Server, concurrency int) *DNSClient {
+	t.Helper()
+	client, err := NewDoHClient(srv.URL+"/dns-query", concurrency, DefaultDialTimeout)
+	if err != nil {
+		t.Fatalf("NewDoHClient error: %v", err)
+	}
+	tlsConfig := srv.Client().Transport.(*http.Transport).TLSClientConfig
+	client.HTTPClient.Transport.(*http.Transport).TLSClientConfig = &tls.Config{RootCAs: tlsConfig.RootCAs}
+	return client
+}
+
+func TestNewDoHClientInvalidURL(t *testing.T) {
+	for _, endpoint := range []string{"", "8.8.8.8", "http://dns.example/dns-query", "https:///dns-query"} {
+		if _, err := NewDoHClient(endpoint, 10, 0); err == nil {
+			t.Errorf("NewDoHClient(%q) succeeded, want error", endpoint)
+		}
+	}
+}
+
+func TestDoHLookupAddr(t *testing.T) {
+	f := startFakeDNS(t)
+	f.AddPTR("1.2.0.192.in-addr.arpa.", "host.example.com.")
+	srv, _ := startFakeDoH(t, f)
+	client := newTestDoHClient(t, srv, 10)
+
+	names, err := client.LookupAddr(context.Background(), "192.0.2.1")
+	if err != nil {
+		t.Fatalf("LookupAddr error: %v", err)
+	}
+	if len(names) != 1 || names[0] != "host.example.com." {
+		t.Errorf("names = %v, want [host.example.com.]", names)
+	}
+
+	_, err = client.LookupAddr(context.Background(), "192.0.2.2")
+	if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+		t.Errorf("LookupAddr(192.0.2.2) error = %v, want not found", err)
+	}
+
+	// RFC 8484 asks for ID 0 so identical queries are cacheable
+	for _, q := range f.Queries() {
+		if q.ID != 0 {
+			t.Errorf("query ID = %d, want 0", q.ID)
+		}
+	}
+}
+
+func TestDoHServerError(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	client := newTestDoHClient(t, srv, 1)
+
+	_, err := client.LookupAddr(context.Background(), "192.0.2.1")
+	if err == nil {
+		t.Fatal("LookupAddr succeeded against a failing server")
+	}
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		t.Errorf("HTTP error reported as not found: %v", err)
+	}
+}
+
+func TestDoHConnectionReuse(t *testing.T) {
+	f := startFakeDNS(t)
+	for i := 0; i < 256; i++ {
+		f.AddPTR(fmt.Sprintf("%d.2.0.192.in-addr.arpa.", i), fmt.Sprintf("host%d.example.com.", i))
+	}
+	srv, conns := startFakeDoH(t, f)
+	client := newTestDoHClient(t, srv, 50)
+
+	// Warm up one connection so the parallel queries below all find it
+	if _, err := client.LookupAddr(context.Background(), "192.0.2.0"); err != nil {
+		t.Fatalf("LookupAddr error: %v", err)
+	}
+
+	ips := make([]net.IP, 256)
+	for i := range ips {
+		ips[i] = net.IPv4(192, 0, 2, byte(i))
+	}
+	for r := range LookupWorkers(context.Background(), ips, 50, client) {
+		if r.Error != nil {
+			t.Errorf("lookup %s error: %v", r.IP, r.Error)
+		}
+	}
+
+	if n := conns.Load(); n != 1 {
+		t.Errorf("server accepted %d connections for 257 queries, want 1 shared HTTP/2 connection", n)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// DomainCount is the number of resolved IPs whose PTR falls under Domain.
+type DomainCount struct {
+	Domain string
+	Count  int
+}
+
+// RegisteredDomain returns the registrable domain of a PTR name (or a
+// consolidation pattern like "*.compute-1.amazonaws.com"): the label above
+// its ICANN public suffix, so "host.example.co.uk" gives "example.co.uk".
+// The list's private section is ignored, so provider zones such as
+// compute-1.amazonaws.com roll up to the provider's own domain. Names that
+// are themselves a public suffix are returned lowercased as is.
+func RegisteredDomain(ptr string) string {
+	name := strings.ToLower(strings.TrimSuffix(ptr, "."))
+	suffix := icannSuffix(name)
+	if len(name) <= len(suffix) {
+		return name
+	}
+	rest := name[:len(name)-len(suffix)-1]
+	return rest[strings.LastIndexByte(rest, '.')+1:] + "." + suffix
+}
+
+// icannSuffix returns the public suffix of name from the ICANN section of
+// the list, skipping any private suffix that matches first.
+func icannSuffix(name string) string {
+	suffix, icann := publicsuffix.PublicSuffix(name)
+	for !icann {
+		dot := strings.IndexByte(suffix, '.')
+		if dot < 0 {
+			return suffix // Unlisted TLD
+		}
+		suffix, icann = publicsuffix.PublicSuffix(suffix[dot+1:])
+	}
+	return suffix
+}
+
+// CountDomains tallies resolved results by the registered domain of their
+// PTR, most IPs first and ties by name. NXDOMAIN and errored results are
+// not counted.
+func CountDomains(results []LookupResult) []DomainCount {
+	tally := make(map[string]int)
+	for _, r := range results {
+		if r.Error != nil || r.PTR == "" {
+			continue
+		}
+		tally[RegisteredDomain(r.PTR)]++
+	}
+
+	counts := make([]DomainCount, 0, len(tally))
+	for domain, n := range tally {
+		counts = append(counts, DomainCount{Domain: domain, Count: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Domain < counts[j].Domain
+	})
+	return counts
+}
+
+// WriteDomains writes the registered-domain histogram of the filtered
+// results, one "count  domain" line per domain.
+func WriteDomains(w io.Writer, results []LookupResult, opts OutputOptions) error {
+	for _, c := range CountDomains(FilterResults(results, opts)) {
+		if _, err := fmt.Fprintf(w, "%8d  %s\n", c.Count, c.Domain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestRegisteredDomain(t *testing.T) {
+	tests := []struct {
+		ptr  string
+		want string
+	}{
+		{"ec2-52-1-2-3.compute-1.amazonaws.com", "amazonaws.com"},
+		{"c-73-1-2-3.hsd1.ca.comcast.net.", "comcast.net"},
+		{"host.example.co.uk", "example.co.uk"},
+		{"*.static.nyinternet.net", "nyinternet.net"},
+		{"HOST.EXAMPLE.COM", "example.com"},
+		{"example.com", "example.com"},
+		{"localhost", "localhost"},
+		{"host.internal", "host.internal"},
+		{"myapp.herokuapp.com", "herokuapp.com"},
+		{"co.uk", "co.uk"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ptr, func(t *testing.T) {
+			if got := RegisteredDomain(tt.ptr); got != tt.want {
+				t.Errorf("RegisteredDomain(%q) = %q, want %q", tt.ptr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteDomains(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("192.0.2.1"), PTR: "ec2-192-0-2-1.compute-1.amazonaws.com"},
+		{IP: net.ParseIP("192.0.2.2"), PTR: "ec2-192-0-2-2.us-west-2.compute.amazonaws.com"},
+		{IP: net.ParseIP("192.0.2.3"), PTR: "c-192-0-2-3.hsd1.ca.comcast.net"},
+		{IP: net.ParseIP("192.0.2.4"), PTR: "a.example.com"},
+		{IP: net.ParseIP("192.0.2.5")},
+		{IP: net.ParseIP("192.0.2.6"), Error: errors.New("timeout")},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteDomains(&buf, results, OutputOptions{}); err != nil {
+		t.Fatalf("WriteDomains error: %v", err)
+	}
+	want := "       2  amazonaws.com\n" +
+		"       1  comcast.net\n" +
+		"       1  example.com\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"net"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestE2E_BasicLookup(t *testing.T) {
@@ -179,6 +183,36 @@ func TestE2E_ShortFlags(t *testing.T) {
 			args: []string{"-m", "10", "192.168.1.0/24"},
 			want: "", // just check it succeeds with 10 lines
 		},
+		{
+			name: "resolved-only with hide-nxdomain",
+			args: []string{"-r", "--hide-nxdomain", "8.8.8.8/32"},
+			want: "mutually exclusive",
+			fail: true,
+		},
+		{
+			name: "aggregate-threshold without aggressive-aggregate",
+			args: []string{"--aggregate-threshold", "0.5", "8.8.8.8/32"},
+			want: "requires --aggressive-aggregate",
+			fail: true,
+		},
+		{
+			name: "verify-same-server without verify",
+			args: []string{"--verify-same-server", "8.8.8.8/32"},
+			want: "requires --verify",
+			fail: true,
+		},
+		{
+			name: "spill without expand",
+			args: []string{"--spill", "8.8.8.8/30"},
+			want: "requires --expand",
+			fail: true,
+		},
+		{
+			name: "spill with sort",
+			args: []string{"--spill", "-e", "--sort", "8.8.8.8/30"},
+			want: "--spill",
+			fail: true,
+		},
 		{
 			name: "combined short flags",
 			args: []string{"-rn", "8.8.8.8/32"},
@@ -536,6 +570,243 @@ func TestE2E_CustomServerWithPort(t *testing.T) {
 	}
 }
 
+func TestE2E_PreflightUnreachableServer(t *testing.T) {
+	// Nothing listens on port 1, so the preflight query is refused
+	cmd := exec.Command("go", "run", ".", "--server", "127.0.0.1:1", "192.0.2.0/24")
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatal("expected preflight failure for unreachable server")
+	}
+	if !strings.Contains(string(output), "preflight query failed") {
+		t.Errorf("expected preflight error, got: %s", output)
+	}
+}
+
+func TestE2E_AlsoOutput(t *testing.T) {
+	// Lookups fail against a closed port, which still exercises both sinks
+	dir := t.TempDir()
+	main, other := filepath.Join(dir, "ips.json"), filepath.Join(dir, "summary.json")
+	cmd := exec.Command("go", "run", ".", "--server", "127.0.0.1:1", "--no-preflight",
+		"-e", "-o", "json", "--output-file", main, "--also-output", other, "192.0.2.0/31")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+
+	var perIP []JSONResult
+	var consolidated []ConsolidatedJSONResult
+	for path, v := range map[string]any{main: &perIP, other: &consolidated} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			t.Fatalf("%s: %v\n%s", path, err, data)
+		}
+	}
+	if len(perIP) != 2 || perIP[0].IP != "192.0.2.0" {
+		t.Errorf("per-IP output = %+v, want 2 IPs", perIP)
+	}
+	if len(consolidated) != 2 || consolidated[0].Count == nil {
+		t.Errorf("consolidated output = %+v, want 2 entries with counts", consolidated)
+	}
+}
+
+func TestE2E_BrokenPipe(t *testing.T) {
+	// The reader is gone before anything is written, as after "| head"
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	defer w.Close()
+
+	var stderr strings.Builder
+	cmd := exec.Command("go", "run", ".", "--dry-run", "10.0.0.0/8")
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("command failed on a closed pipe: %v\nstderr: %s", err, stderr.String())
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("expected no error output, got: %s", stderr.String())
+	}
+}
+
+func TestE2E_MockFile(t *testing.T) {
+	// Hermetic: answers come from the fixture, not the network
+	fixture := filepath.Join(t.TempDir(), "ptrs.txt")
+	if err := os.WriteFile(fixture, []byte("192.0.2.2 host.example.com\n192.0.2.3 host.example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("go", "run", ".", "--mock-file", fixture, "192.0.2.0/30")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	got := strings.Fields(string(output))
+	want := []string{"192.0.2.0/31", "NXDOMAIN", "192.0.2.2/31", "host.example.com"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("output = %s, want %v", output, want)
+	}
+}
+
+func TestE2E_TargetForms(t *testing.T) {
+	fixture := filepath.Join(t.TempDir(), "ptrs.txt")
+	if err := os.WriteFile(fixture, []byte("192.168.1.1 a.example.com\n192.168.1.2 b.example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Positional targets take the --input-file forms: integers, bare IPs, IP:port
+	cmd := exec.Command("go", "run", ".", "--mock-file", fixture, "--expand", "--sort", "3232235777", "0xC0A80102:443")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	got := strings.Fields(string(output))
+	want := []string{"192.168.1.1", "a.example.com", "192.168.1.2", "b.example.com"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("output = %s, want %v", output, want)
+	}
+
+	output, err = exec.Command("go", "run", ".", "--dry-run", "10/8").CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "ambiguous") {
+		t.Errorf("10/8: err = %v, output:\n%s", err, output)
+	}
+}
+
+func TestE2E_MinResolvedPct(t *testing.T) {
+	dir := t.TempDir()
+	fixture := filepath.Join(dir, "ptrs.txt")
+	if err := os.WriteFile(fixture, []byte("192.0.2.1 host.example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// go run reports any failure as status 1, so build to see the real status
+	bin := filepath.Join(dir, "sr")
+	if output, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("build failed: %v\n%s", err, output)
+	}
+
+	// 1 of 4 resolved: 25%
+	tests := []struct {
+		pct  string
+		want int
+	}{
+		{"25", 0},
+		{"50", 2},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command(bin, "--mock-file", fixture, "--count", "--min-resolved-pct", tt.pct, "192.0.2.0/30")
+		output, _ := cmd.CombinedOutput()
+		if code := cmd.ProcessState.ExitCode(); code != tt.want {
+			t.Errorf("--min-resolved-pct %s: exit status %d, want %d\noutput: %s", tt.pct, code, tt.want, output)
+		}
+		// The counts are written either way
+		if !strings.Contains(string(output), "resolved  1") {
+			t.Errorf("--min-resolved-pct %s: missing counts in output: %s", tt.pct, output)
+		}
+	}
+}
+
+func TestE2E_MetricsFile(t *testing.T) {
+	dir := t.TempDir()
+	fixture := filepath.Join(dir, "ptrs.txt")
+	if err := os.WriteFile(fixture, []byte("192.0.2.1 host.example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	metrics := filepath.Join(dir, "sr.prom")
+	cmd := exec.Command("go", "run", ".", "--mock-file", fixture, "--metrics-file", metrics, "192.0.2.0/30")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	data, err := os.ReadFile(metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"sr_lookups_total 4\n", "sr_resolved_total 1\n", "sr_nxdomain_total 3\n", "sr_errors_total 0\n", "sr_duration_seconds "} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("metrics missing %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestE2E_VerboseHeader(t *testing.T) {
+	fixture := filepath.Join(t.TempDir(), "ptrs.txt")
+	if err := os.WriteFile(fixture, []byte("192.0.2.1 host.example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr strings.Builder
+	cmd := exec.Command("go", "run", ".", "--mock-file", fixture, "--verbose", "-m", "3", "192.0.2.0/30", "10.0.0.0/31")
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("command failed: %v\nstderr: %s", err, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+	if want := "scanning 192.0.2.0/30 10.0.0.0/31 (3 addresses, max-ips 3, concurrency 3)"; lines[0] != want {
+		t.Errorf("header = %q, want %q", lines[0], want)
+	}
+	if last := lines[len(lines)-1]; !strings.HasPrefix(last, "done: 3 addresses in ") {
+		t.Errorf("footer = %q, want the elapsed time", last)
+	}
+	if strings.Contains(stdout.String(), "scanning") {
+		t.Errorf("header written to stdout:\n%s", stdout.String())
+	}
+}
+
+func TestE2E_ServerEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+		args []string
+		want string
+	}{
+		{"default from env", "10.0.0.53, 10.0.0.54:5353", nil, "resolver       server 10.0.0.53,10.0.0.54:5353\n"},
+		{"--server wins", "10.0.0.53", []string{"-S", "192.0.2.53"}, "resolver       server 192.0.2.53\n"},
+		{"--mock-file ignores it", "10.0.0.53", []string{"--mock-file", "/dev/null"}, "resolver       mock /dev/null\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"run", ".", "--show-config", "--dry-run"}, tt.args...)
+			cmd := exec.Command("go", append(args, "192.0.2.0/30")...)
+			cmd.Env = append(os.Environ(), "SR_DNS_SERVER="+tt.env)
+			var stderr strings.Builder
+			cmd.Stderr = &stderr
+			if err := cmd.Run(); err != nil {
+				t.Fatalf("command failed: %v\nstderr: %s", err, stderr.String())
+			}
+			if !strings.HasPrefix(stderr.String(), tt.want) {
+				t.Errorf("stderr = %q, want it to start with %q", stderr.String(), tt.want)
+			}
+		})
+	}
+
+	cmd := exec.Command("go", "run", ".", "--dry-run", "192.0.2.0/30")
+	cmd.Env = append(os.Environ(), "SR_DNS_SERVER=,")
+	output, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "$SR_DNS_SERVER: invalid DNS server address") {
+		t.Errorf("invalid SR_DNS_SERVER: err = %v, output:\n%s", err, output)
+	}
+}
+
+func TestE2E_ShowConfig(t *testing.T) {
+	var stdout, stderr strings.Builder
+	cmd := exec.Command("go", "run", ".", "--show-config", "--dry-run", "-c", "auto", "-r", "10.0.0.0/16")
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("command failed: %v\nstderr: %s", err, stderr.String())
+	}
+	// Derived values are shown resolved, not as typed
+	for _, want := range []string{"concurrency    256\n", "filters        resolved-only\n", "--concurrency=auto"} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("stderr missing %q:\n%s", want, stderr.String())
+		}
+	}
+	// --dry-run still stops before any lookup
+	if !strings.HasPrefix(stdout.String(), "total     65536\n") {
+		t.Errorf("stdout = %q, want the dry-run plan", stdout.String())
+	}
+}
+
 func TestE2E_InvalidServer(t *testing.T) {
 	cmd := exec.Command("go", "run", ".", "--server", "   ", "8.8.8.8/32")
 	output, err := cmd.CombinedOutput()
@@ -546,3 +817,125 @@ func TestE2E_InvalidServer(t *testing.T) {
 		t.Errorf("expected clear error message, got: %s", output)
 	}
 }
+
+func TestE2E_Spill(t *testing.T) {
+	dir := t.TempDir()
+	fixture := filepath.Join(dir, "ptrs.txt")
+	if err := os.WriteFile(fixture, []byte("192.0.2.1 one.example.com\n192.0.2.3 three.example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("go", "run", ".", "--mock-file", fixture, "--spill", "-o", "ndjson", "-r", "192.0.2.0/30")
+	cmd.Env = append(os.Environ(), "TMPDIR="+dir)
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("command failed: %v\noutput: %s", err, output)
+	}
+	want := `{"ip":"192.0.2.1","ptr":"one.example.com"}` + "\n" + `{"ip":"192.0.2.3","ptr":"three.example.com"}` + "\n"
+	if string(output) != want {
+		t.Errorf("output = %q, want %q", output, want)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "sr-spill-*")); len(matches) != 0 {
+		t.Errorf("spill file left behind: %v", matches)
+	}
+}
+
+func TestE2E_SpillInterrupted(t *testing.T) {
+	// A server that never answers keeps the scan running until interrupted
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "sr")
+	if output, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("build failed: %v\n%s", err, output)
+	}
+	tmp := filepath.Join(dir, "tmp")
+	if err := os.Mkdir(tmp, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(bin, "--spill", "-e", "--server", conn.LocalAddr().String(), "--no-preflight",
+		"--query-timeout", "1m", "--retries", "0", "192.0.2.0/28")
+	cmd.Env = append(os.Environ(), "TMPDIR="+tmp)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if matches, _ := filepath.Glob(filepath.Join(tmp, "sr-spill-*")); len(matches) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no spill file created; stderr: %s", stderr.String())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("interrupted scan exited 0; stderr: %s", stderr.String())
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("scan did not stop on interrupt")
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("spill file left behind after interrupt: %v", entries)
+	}
+}
+
+func TestE2E_OutputFileUntouchedWithoutScan(t *testing.T) {
+	dir := t.TempDir()
+	outFile := filepath.Join(dir, "out.txt")
+	alsoFile := filepath.Join(dir, "also.txt")
+	const previous = "previous results\n"
+	reset := func() {
+		for _, f := range []string{outFile, alsoFile} {
+			if err := os.WriteFile(f, []byte(previous), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	check := func(what string) {
+		for _, f := range []string{outFile, alsoFile} {
+			if data, err := os.ReadFile(f); err != nil || string(data) != previous {
+				t.Errorf("%s: %s = %q, %v; want it untouched", what, filepath.Base(f), data, err)
+			}
+		}
+	}
+
+	// --dry-run reports the plan on stdout
+	reset()
+	output, err := exec.Command("go", "run", ".", "--dry-run", "--output-file", outFile, "--also-output", alsoFile, "192.0.2.0/30").Output()
+	if err != nil {
+		t.Fatalf("--dry-run failed: %v", err)
+	}
+	if !strings.Contains(string(output), "4") {
+		t.Errorf("--dry-run stdout = %q, want the plan", output)
+	}
+	check("--dry-run")
+
+	// A failed preflight stops before the scan: nothing listens on this port
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+	reset()
+	output, err = exec.Command("go", "run", ".", "--server", addr, "--output-file", outFile, "--also-output", alsoFile, "192.0.2.0/30").CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "preflight") {
+		t.Fatalf("expected a preflight failure, got err = %v, output:\n%s", err, output)
+	}
+	check("failed preflight")
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// OutputWriter writes collected results in one --output format.
+type OutputWriter struct {
+	// Expanded writes one entry per IP (--expand).
+	Expanded func(w io.Writer, results []LookupResult, opts OutputOptions) error
+
+	// Consolidated writes the networks results were consolidated into. If
+	// nil, the format is always per IP and Expanded is used instead.
+	Consolidated func(w io.Writer, results []ConsolidatedResult, opts OutputOptions) error
+
+	// Summary, if set, replaces both views: it gets every result,
+	// unfiltered, and reports on them as a whole.
+	Summary func(w io.Writer, results []LookupResult, opts OutputOptions) error
+}
+
+// outputWriters maps each --output format name to its writer.
+var outputWriters = map[string]OutputWriter{
+	"text":    {Expanded: formatText, Consolidated: formatTextConsolidated},
+	"json":    {Expanded: formatJSON, Consolidated: formatJSONConsolidated},
+	"ndjson":  {Expanded: formatNDJSON},
+	"table":   {Expanded: formatTable, Consolidated: formatTableConsolidated},
+	"domains": {Summary: WriteDomains},
+	"binary":  {Expanded: formatBinary},
+	"names":   {Expanded: formatNames, Consolidated: formatNamesConsolidated},
+}
+
+// RegisterOutputFormat adds an --output format. It panics if name is empty
+// or already registered, or if w has neither Expanded nor Summary, as these
+// are programming errors.
+func RegisterOutputFormat(name string, w OutputWriter) {
+	if name == "" {
+		panic("RegisterOutputFormat: empty format name")
+	}
+	if _, dup := outputWriters[name]; dup {
+		panic(fmt.Sprintf("RegisterOutputFormat: format %q registered twice", name))
+	}
+	if w.Expanded == nil && w.Summary == nil {
+		panic(fmt.Sprintf("RegisterOutputFormat: format %q has no writer", name))
+	}
+	outputWriters[name] = w
+}
+
+// OutputFormats returns the registered format names, sorted.
+func OutputFormats() []string {
+	names := make([]string, 0, len(outputWriters))
+	for name := range outputWriters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupOutputWriter returns the writer for format. Unknown formats,
+// including the empty one, get text; run rejects unknown ones up front.
+func lookupOutputWriter(format string) OutputWriter {
+	if w, ok := outputWriters[format]; ok {
+		return w
+	}
+	return outputWriters["text"]
+}
+
+// validateOutputFormat returns an error listing the registered formats if
+// format is not one of them.
+func validateOutputFormat(format string) error {
+	if _, ok := outputWriters[format]; ok {
+		return nil
+	}
+	names := OutputFormats()
+	list := strings.Join(names[:len(names)-1], ", ") + ", or " + names[len(names)-1]
+	return fmt.Errorf("invalid output format %q: must be %s", format, list)
+}
+
+// formatNDJSON writes collected results as one JSON object per line, in IP
+// order (highest first with opts.Descending, or by PTR with opts.SortBy),
+// as StreamNDJSON does while lookups run.
+func formatNDJSON(w io.Writer, results []LookupResult, opts OutputOptions) error {
+	results = append([]LookupResult(nil), results...)
+	SortResultsBy(results, resultOrder(opts))
+	encoder := json.NewEncoder(w)
+	for _, r := range results {
+		if err := encoder.Encode(toJSONResult(r, opts)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatNames writes each distinct PTR name once, sorted, for wordlists
+// and other tools. NXDOMAIN and errored results contribute nothing.
+func formatNames(w io.Writer, results []LookupResult, opts OutputOptions) error {
+	names := make([]string, 0, len(results))
+	for _, r := range results {
+		if r.Error == nil && r.PTR != "" {
+			names = append(names, r.PTR)
+		}
+	}
+	return writeNames(w, names)
+}
+
+// formatNamesConsolidated is formatNames over consolidated entries, so
+// IP-templated names appear once as their "*." pattern.
+func formatNamesConsolidated(w io.Writer, results []ConsolidatedResult, opts OutputOptions) error {
+	names := make([]string, 0, len(results))
+	for _, r := range results {
+		if r.Error == nil && r.PTR != "" {
+			names = append(names, r.PTR)
+		}
+	}
+	return writeNames(w, names)
+}
+
+// writeNames writes names sorted, one per line, without duplicates. A
+// trailing dot doesn't make a name distinct.
+func writeNames(w io.Writer, names []string) error {
+	for i, name := range names {
+		names[i] = strings.TrimSuffix(name, ".")
+	}
+	sort.Strings(names)
+	bw := bufio.NewWriter(w)
+	for i, name := range names {
+		if i > 0 && name == names[i-1] {
+			continue
+		}
+		bw.WriteString(name)
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestOutputFormatsRoundTrip(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("192.0.2.1").To4(), PTR: "a.example.com"},
+		{IP: net.ParseIP("192.0.2.2").To4(), PTR: "b.example.com"},
+		{IP: net.ParseIP("192.0.2.3").To4()},
+	}
+	for i := 4; i < 8; i++ {
+		results = append(results, LookupResult{IP: net.IPv4(192, 0, 2, byte(i)).To4(), PTR: "c.example.com"})
+	}
+
+	// Each check reads back the (IP or network, PTR) pairs from the output
+	// of its format, or the domain counts for a summary.
+	parseJSON := func(t *testing.T, out []byte) map[string]string {
+		var entries []struct {
+			IP      string `json:"ip"`
+			Network string `json:"network"`
+			PTR     string `json:"ptr"`
+		}
+		if err := json.Unmarshal(out, &entries); err != nil {
+			t.Fatalf("invalid JSON: %v\n%s", err, out)
+		}
+		got := make(map[string]string)
+		for _, e := range entries {
+			got[e.IP+e.Network] = e.PTR
+		}
+		return got
+	}
+	parseNDJSON := func(t *testing.T, out []byte) map[string]string {
+		got := make(map[string]string)
+		scanner := bufio.NewScanner(bytes.NewReader(out))
+		for scanner.Scan() {
+			var e struct {
+				IP  string `json:"ip"`
+				PTR string `json:"ptr"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				t.Fatalf("invalid NDJSON line %q: %v", scanner.Text(), err)
+			}
+			got[e.IP] = e.PTR
+		}
+		return got
+	}
+	parseColumns := func(t *testing.T, out []byte) map[string]string {
+		got := make(map[string]string)
+		for _, line := range strings.Split(string(out), "\n") {
+			fields := strings.FieldsFunc(line, func(r rune) bool {
+				return r == ' ' || r == '|' || r == '│' || r == '\t'
+			})
+			if len(fields) >= 2 && net.ParseIP(strings.Split(fields[0], "/")[0]) != nil {
+				got[fields[0]] = fields[1]
+			}
+		}
+		return got
+	}
+
+	expanded := map[string]string{
+		"192.0.2.1": "a.example.com", "192.0.2.2": "b.example.com", "192.0.2.3": "",
+		"192.0.2.4": "c.example.com", "192.0.2.5": "c.example.com",
+		"192.0.2.6": "c.example.com", "192.0.2.7": "c.example.com",
+	}
+	consolidated := map[string]string{
+		"192.0.2.1": "a.example.com", "192.0.2.2": "b.example.com", "192.0.2.3": "",
+		"192.0.2.4/30": "c.example.com",
+	}
+	textual := func(want map[string]string) map[string]string {
+		m := make(map[string]string)
+		for k, v := range want {
+			if v == "" {
+				v = "NXDOMAIN"
+			}
+			m[k] = v
+		}
+		return m
+	}
+
+	checks := map[string]func(t *testing.T, expand bool, out []byte){
+		"text": func(t *testing.T, expand bool, out []byte) {
+			want := textual(consolidated)
+			if expand {
+				want = textual(expanded)
+			}
+			assertPairs(t, parseColumns(t, out), want)
+		},
+		"table": func(t *testing.T, expand bool, out []byte) {
+			want := textual(consolidated)
+			if expand {
+				want = textual(expanded)
+			}
+			assertPairs(t, parseColumns(t, out), want)
+		},
+		"json": func(t *testing.T, expand bool, out []byte) {
+			want := consolidated
+			if expand {
+				want = expanded
+			}
+			assertPairs(t, parseJSON(t, out), want)
+		},
+		"ndjson": func(t *testing.T, expand bool, out []byte) {
+			assertPairs(t, parseNDJSON(t, out), expanded)
+		},
+		"binary": func(t *testing.T, expand bool, out []byte) {
+			got := make(map[string]string)
+			br := NewBinaryReader(bytes.NewReader(out))
+			for {
+				r, err := br.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("invalid binary output: %v", err)
+				}
+				got[r.IP.String()] = r.PTR
+			}
+			assertPairs(t, got, expanded)
+		},
+		"names": func(t *testing.T, expand bool, out []byte) {
+			want := "a.example.com\nb.example.com\nc.example.com\n"
+			if string(out) != want {
+				t.Errorf("names output = %q, want %q", out, want)
+			}
+		},
+		"domains": func(t *testing.T, expand bool, out []byte) {
+			if got := strings.Fields(string(out)); !slices.Equal(got, []string{"6", "example.com"}) {
+				t.Errorf("domains output = %q, want 6 example.com", out)
+			}
+		},
+	}
+
+	for _, name := range OutputFormats() {
+		check, ok := checks[name]
+		if !ok {
+			t.Errorf("format %q has no round-trip check", name)
+			continue
+		}
+		for _, expand := range []bool{false, true} {
+			view := "consolidated"
+			if expand {
+				view = "expanded"
+			}
+			var buf bytes.Buffer
+			opts := OutputOptions{Format: name, Expand: expand, TableASCII: true}
+			if err := WriteOutput(&buf, results, opts); err != nil {
+				t.Fatalf("%s %s: WriteOutput error: %v", name, view, err)
+			}
+			t.Run(name+"/"+view, func(t *testing.T) { check(t, expand, buf.Bytes()) })
+		}
+	}
+}
+
+func assertPairs(t *testing.T, got, want map[string]string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("got %d entries %v, want %d %v", len(got), got, len(want), want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: got %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestRegisterOutputFormat(t *testing.T) {
+	RegisterOutputFormat("csv-test", OutputWriter{
+		Expanded: func(w io.Writer, results []LookupResult, opts OutputOptions) error {
+			for _, r := range results {
+				if _, err := io.WriteString(w, r.IP.String()+","+r.PTR+"\n"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+	defer delete(outputWriters, "csv-test")
+
+	if err := validateOutputFormat("csv-test"); err != nil {
+		t.Fatalf("registered format rejected: %v", err)
+	}
+	// Without a consolidated writer the format is always per IP
+	var buf bytes.Buffer
+	results := []LookupResult{{IP: net.ParseIP("192.0.2.1").To4(), PTR: "a.example.com"}}
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "csv-test"}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "192.0.2.1,a.example.com\n" {
+		t.Errorf("output = %q", buf.String())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a format twice should panic")
+		}
+	}()
+	RegisterOutputFormat("csv-test", OutputWriter{Expanded: formatText})
+}
+
+func TestValidateOutputFormat(t *testing.T) {
+	err := validateOutputFormat("xml")
+	if err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+	want := `invalid output format "xml": must be binary, domains, json, names, ndjson, table, or text`
+	if err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+}
+
+func TestFormatNames(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("10.0.0.1").To4(), PTR: "10-0-0-1.dyn.example.net"},
+		{IP: net.ParseIP("10.0.0.2").To4(), PTR: "10-0-0-2.dyn.example.net"},
+		{IP: net.ParseIP("10.0.0.3").To4(), PTR: "web.example.com."},
+		{IP: net.ParseIP("10.0.0.5").To4(), PTR: "web.example.com"},
+		{IP: net.ParseIP("10.0.0.4").To4(), PTR: "api.example.com"},
+		{IP: net.ParseIP("10.0.0.6").To4()},
+		{IP: net.ParseIP("10.0.0.7").To4(), Error: errors.New("timeout")},
+	}
+
+	tests := []struct {
+		expand bool
+		want   string
+	}{
+		{true, "10-0-0-1.dyn.example.net\n10-0-0-2.dyn.example.net\napi.example.com\nweb.example.com\n"},
+		{false, "*.dyn.example.net\napi.example.com\nweb.example.com\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteOutput(&buf, results, OutputOptions{Format: "names", Expand: tt.expand}); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("expand=%v: output = %q, want %q", tt.expand, buf.String(), tt.want)
+		}
+	}
+}
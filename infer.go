@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net"
+	"sync"
+)
+
+// patternInferrer tracks PTR patterns per IPv4 /24 while lookups run. Once
+// enough consecutive addresses in a block have PTRs following one pattern
+// (see extractPTRPattern), the rest of the block is inferred instead of
+// queried. This trades accuracy for speed: a host in the block with a
+// different PTR, or none at all, is reported with the pattern anyway.
+type patternInferrer struct {
+	after int // Consecutive matching addresses needed before inferring
+
+	mu     sync.Mutex
+	blocks map[[3]byte]*inferBlock
+}
+
+// inferBlock is the state of one /24.
+type inferBlock struct {
+	patterns [256]string // Pattern per last octet; "" if unqueried or unpatterned
+	inferred string      // Set once the block's pattern is inferred
+}
+
+// newPatternInferrer returns an inferrer that infers a block after the given
+// number of consecutive matches.
+func newPatternInferrer(after int) *patternInferrer {
+	return &patternInferrer{after: after, blocks: make(map[[3]byte]*inferBlock)}
+}
+
+// inferred returns the pattern to report for ip if its block has been
+// inferred. A nil inferrer never infers.
+func (p *patternInferrer) inferred(ip net.IP) (string, bool) {
+	ip4 := ip.To4()
+	if p == nil || ip4 == nil {
+		return "", false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b := p.blocks[[3]byte(ip4[:3])]
+	if b == nil || b.inferred == "" {
+		return "", false
+	}
+	return b.inferred, true
+}
+
+// record notes the outcome of a real lookup and infers the block's pattern
+// once a run of p.after consecutive addresses shares it. A nil inferrer
+// ignores it.
+func (p *patternInferrer) record(r LookupResult) {
+	ip4 := r.IP.To4()
+	if p == nil || ip4 == nil || r.Error != nil {
+		return
+	}
+	pattern := extractPTRPattern(ip4, r.PTR)
+	if pattern == "" {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := [3]byte(ip4[:3])
+	b := p.blocks[key]
+	if b == nil {
+		b = &inferBlock{}
+		p.blocks[key] = b
+	}
+	if b.inferred != "" {
+		return
+	}
+	b.patterns[ip4[3]] = pattern
+
+	// Measure the run of matching neighbours around this address
+	run := 1
+	for i := int(ip4[3]) - 1; i >= 0 && b.patterns[i] == pattern; i-- {
+		run++
+	}
+	for i := int(ip4[3]) + 1; i < 256 && b.patterns[i] == pattern; i++ {
+		run++
+	}
+	if run >= p.after {
+		b.inferred = pattern
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestPatternInferrer(t *testing.T) {
+	inf := newPatternInferrer(3)
+	ptr := func(d int) LookupResult {
+		ip := net.IPv4(192, 0, 2, byte(d)).To4()
+		return LookupResult{IP: ip, PTR: fmt.Sprintf("%d.2.0.192.static.isp.net", d)}
+	}
+
+	inf.record(ptr(10))
+	inf.record(ptr(12)) // not adjacent to 10
+	if _, ok := inf.inferred(net.ParseIP("192.0.2.200")); ok {
+		t.Fatal("inferred before a run of 3")
+	}
+
+	inf.record(ptr(11)) // completes 10-11-12
+	pattern, ok := inf.inferred(net.ParseIP("192.0.2.200"))
+	if !ok || pattern != "*.static.isp.net" {
+		t.Errorf("inferred = %q, %v; want *.static.isp.net", pattern, ok)
+	}
+	if _, ok := inf.inferred(net.ParseIP("192.0.3.1")); ok {
+		t.Error("inference leaked into another /24")
+	}
+
+	var nilInf *patternInferrer
+	nilInf.record(ptr(1))
+	if _, ok := nilInf.inferred(net.ParseIP("192.0.2.1")); ok {
+		t.Error("nil inferrer should never infer")
+	}
+}
+
+func TestLookupWorkersInferred(t *testing.T) {
+	resolver := NewMockResolver()
+	var ips []net.IP
+	for d := 0; d < 64; d++ {
+		ip := fmt.Sprintf("192.0.2.%d", d)
+		resolver.AddResult(ip, fmt.Sprintf("%d.2.0.192.static.isp.net.", d))
+		ips = append(ips, net.ParseIP(ip).To4())
+	}
+
+	// One worker processes IPs in order, so exactly 4 are queried
+	var queried, inferred int
+	for r := range LookupWorkersInferred(context.Background(), ips, 1, 1, resolver, 4) {
+		if r.Inferred {
+			inferred++
+			if r.PTR != "*.static.isp.net" {
+				t.Errorf("%s: inferred PTR = %q", r.IP, r.PTR)
+			}
+		} else {
+			queried++
+		}
+	}
+	if queried != 4 || inferred != 60 {
+		t.Errorf("queried %d, inferred %d; want 4 and 60", queried, inferred)
+	}
+
+	// Inferred and queried IPs consolidate into the same pattern block
+	var results []LookupResult
+	for r := range LookupWorkersInferred(context.Background(), ips, 1, 1, resolver, 4) {
+		results = append(results, r)
+	}
+	consolidated := ConsolidateResults(results)
+	AnnotateInferred(consolidated, results)
+	if len(consolidated) != 1 || consolidated[0].Network.String() != "192.0.2.0/26" || consolidated[0].Inferred != 60 {
+		t.Errorf("consolidated = %+v, want one 192.0.2.0/26 with 60 inferred", consolidated)
+	}
+}
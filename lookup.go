@@ -2,17 +2,43 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand/v2"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
 )
 
 // LookupResult holds the result of a PTR lookup.
 type LookupResult struct {
-	IP    net.IP
-	PTR   string // Empty if no PTR record found
-	Error error  // Non-nil if lookup failed (not NXDOMAIN)
+	IP      net.IP
+	PTR     string // Empty if no PTR record found
+	Error   error  // Non-nil if lookup failed (not NXDOMAIN)
+	Warning error  // Soft error the resolver returned alongside the PTR (partial answer)
+	Index   int    // Position of IP in the input list (set by LookupWorkers)
+
+	CNAMEs   []string // CNAME chain followed to the PTR (DNSClient only)
+	Verified bool     // PTR forward-resolves back to IP (set by VerifyResults)
+	Families []string // Address families the PTR name resolves in (set by DualStackResults)
+	Provider string   // Hosting provider guessed from the PTR suffix (--tag-provider)
+	Source   string   // Input CIDR the IP came from (set by SetSources)
+	Inferred bool     // Not queried; PTR is a pattern inferred from neighbours (--infer-patterns)
+
+	Authoritative bool // PTR answer had the AA bit set (DNSClient only)
+	Signed        bool // PTR answer carried RRSIGs (DNSClient.DNSSEC only)
+	AuthenticData bool // PTR answer had the AD bit set: the resolver validated it (DNSClient.DNSSEC only)
+
+	// Second lookup against another resolver (set by CompareResults)
+	ComparePTR   string // PTR from the comparison resolver; empty for NXDOMAIN
+	CompareError error  // Non-nil if the comparison lookup failed
+	Mismatch     bool   // Both lookups succeeded but disagree
 }
 
 // Resolver abstracts DNS lookups for testing.
@@ -20,9 +46,131 @@ type Resolver interface {
 	LookupAddr(ctx context.Context, addr string) ([]string, error)
 }
 
+// PTRResolver is implemented by resolvers that expose the full PTR answer,
+// including any CNAME chain. lookupIP prefers it over LookupAddr.
+type PTRResolver interface {
+	LookupPTR(ctx context.Context, addr string) (*PTRResponse, error)
+}
+
+// serverAddresser is implemented by resolvers that query a known server.
+type serverAddresser interface {
+	ServerAddr() string
+}
+
+// LookupError is a failed PTR lookup. Its message always names the IP and,
+// when known, the server, e.g. "lookup 192.0.2.1 via 8.8.8.8:53: timeout",
+// so the error text is self-contained in logs and JSON.
+type LookupError struct {
+	IP     net.IP
+	Server string // host:port, or "" if unknown
+	Err    error
+}
+
+func (e *LookupError) Error() string {
+	reason := e.Err.Error()
+	var dnsErr *net.DNSError
+	if errors.As(e.Err, &dnsErr) {
+		reason = dnsErr.Err
+		if dnsErr.IsTimeout {
+			reason = "timeout"
+		}
+	}
+	if e.Server == "" {
+		return fmt.Sprintf("lookup %s: %s", e.IP, reason)
+	}
+	return fmt.Sprintf("lookup %s via %s: %s", e.IP, e.Server, reason)
+}
+
+func (e *LookupError) Unwrap() error {
+	return e.Err
+}
+
+// Categories of failed lookup returned by ErrorCategory, so failures can be
+// grouped without parsing the error text.
+const (
+	ErrorTimeout  = "timeout"  // No answer in time
+	ErrorServFail = "servfail" // Server failed or sent a bad response (SERVFAIL and other rcodes)
+	ErrorRefused  = "refused"  // Server refused the query (REFUSED)
+	ErrorNetwork  = "network"  // Server could not be reached
+	ErrorCanceled = "canceled" // Lookup canceled, e.g. by an interrupt
+	ErrorOther    = "other"    // Anything else
+)
+
+// ErrorCategory returns the category of a failed lookup's error, or "" if
+// err is nil. The system resolver reports every bad rcode as "server
+// misbehaving", so REFUSED is only told apart from SERVFAIL when sr
+// queries the server itself.
+func ErrorCategory(err error) string {
+	if err == nil {
+		return ""
+	}
+	var spilled *spilledError
+	if errors.As(err, &spilled) {
+		return spilled.category
+	}
+	if errors.Is(err, context.Canceled) {
+		return ErrorCanceled
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorTimeout
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		if opErr.Timeout() {
+			return ErrorTimeout
+		}
+		return ErrorNetwork
+	}
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) {
+		return ErrorOther
+	}
+	switch msg := dnsErr.Err; {
+	case dnsErr.IsTimeout:
+		return ErrorTimeout
+	case strings.Contains(msg, dnsmessage.RCodeRefused.String()):
+		return ErrorRefused
+	case strings.Contains(msg, "server misbehaving"):
+		return ErrorServFail
+	case strings.Contains(msg, "connection refused"),
+		strings.Contains(msg, "network is unreachable"),
+		strings.Contains(msg, "no route to host"):
+		// DNSClient and the system resolver keep only the text of a
+		// failed dial or read
+		return ErrorNetwork
+	}
+	return ErrorOther
+}
+
+// newLookupError wraps err from resolver's lookup of ip. The server is
+// taken from the resolver if it knows it, else from the DNS error.
+func newLookupError(ip net.IP, resolver Resolver, err error) *LookupError {
+	le := &LookupError{IP: ip, Err: err}
+	if sa, ok := resolver.(serverAddresser); ok {
+		le.Server = sa.ServerAddr()
+	}
+	var dnsErr *net.DNSError
+	if le.Server == "" && errors.As(err, &dnsErr) {
+		le.Server = dnsErr.Server
+	}
+	return le
+}
+
+// ForwardResolver abstracts forward (A/AAAA) lookups, used to confirm that
+// a PTR name resolves back to the queried address.
+type ForwardResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
 // NetResolver wraps net.Resolver to implement our Resolver interface.
 type NetResolver struct {
 	*net.Resolver
+	Server string // host:port queried, if not the system resolver
+}
+
+// ServerAddr returns the server queried, or "" for the system resolver.
+func (r *NetResolver) ServerAddr() string {
+	return r.Server
 }
 
 func (r *NetResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
@@ -31,7 +179,7 @@ func (r *NetResolver) LookupAddr(ctx context.Context, addr string) ([]string, er
 
 // DefaultResolver returns a resolver using the system DNS.
 func DefaultResolver() Resolver {
-	return &NetResolver{&net.Resolver{}}
+	return &NetResolver{Resolver: &net.Resolver{}}
 }
 
 // CustomResolver returns a resolver that queries the given DNS server.
@@ -59,43 +207,425 @@ func normalizeServer(server string) (string, error) {
 }
 
 func CustomResolver(server string) (Resolver, error) {
+	return BoundResolver(server, nil, DefaultDialTimeout)
+}
+
+// BoundResolver is CustomResolver with queries sent from the local address
+// local, e.g. to egress a particular interface, and connections to the
+// server bounded by dialTimeout. A nil local lets the OS choose; a zero
+// dialTimeout leaves dialing to the lookup's own deadline.
+func BoundResolver(server string, local net.IP, dialTimeout time.Duration) (Resolver, error) {
 	server, err := normalizeServer(server)
 	if err != nil {
 		return nil, err
 	}
-	return &NetResolver{&net.Resolver{
-		PreferGo: true,
-		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			d := net.Dialer{}
-			return d.DialContext(ctx, "udp", server)
+	return &NetResolver{
+		Resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				d := localDialer("udp", local, dialTimeout)
+				return d.DialContext(ctx, "udp", server)
+			},
 		},
-	}}, nil
+		Server: server,
+	}, nil
+}
+
+// ServerPool spreads lookups round-robin across several resolvers, usually
+// one per --server. If a per-resolver limit is set, at most that many
+// lookups are in flight to any one resolver; a lookup whose turn falls on a
+// busy resolver waits for it, so no single server is overloaded.
+type ServerPool struct {
+	resolvers []Resolver
+	slots     []chan struct{} // Per-resolver semaphores; nil if unlimited
+	next      atomic.Uint64
+}
+
+// NewServerPool returns a pool over resolvers. perServer caps the in-flight
+// lookups to each resolver; 0 means no cap.
+func NewServerPool(resolvers []Resolver, perServer int) *ServerPool {
+	p := &ServerPool{resolvers: resolvers}
+	if perServer > 0 {
+		p.slots = make([]chan struct{}, len(resolvers))
+		for i := range p.slots {
+			p.slots[i] = make(chan struct{}, perServer)
+		}
+	}
+	return p
+}
+
+// Resolvers returns the pooled resolvers, in the order given.
+func (p *ServerPool) Resolvers() []Resolver {
+	return p.resolvers
+}
+
+// acquire picks the next resolver in turn and waits for a free slot on it.
+// The returned release must be called when the lookup is done.
+func (p *ServerPool) acquire(ctx context.Context) (Resolver, func(), error) {
+	i := int((p.next.Add(1) - 1) % uint64(len(p.resolvers)))
+	if p.slots == nil {
+		return p.resolvers[i], func() {}, nil
+	}
+	select {
+	case p.slots[i] <- struct{}{}:
+		return p.resolvers[i], func() { <-p.slots[i] }, nil
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+// withServer records the server r queried in a DNS error, so a failed
+// lookup still names the server even though the pool itself has none.
+func withServer(r Resolver, err error) error {
+	var dnsErr *net.DNSError
+	sa, ok := r.(serverAddresser)
+	if !ok || !errors.As(err, &dnsErr) {
+		return err
+	}
+	named := *dnsErr
+	named.Server = sa.ServerAddr()
+	return &named
+}
+
+func (p *ServerPool) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	r, release, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	names, err := r.LookupAddr(ctx, addr)
+	return names, withServer(r, err)
+}
+
+// LookupPTR uses the full answer of resolvers that provide one, so CNAME
+// chains survive pooling.
+func (p *ServerPool) LookupPTR(ctx context.Context, addr string) (*PTRResponse, error) {
+	r, release, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if pr, ok := r.(PTRResolver); ok {
+		resp, err := pr.LookupPTR(ctx, addr)
+		return resp, withServer(r, err)
+	}
+	names, err := r.LookupAddr(ctx, addr)
+	return &PTRResponse{Names: names}, withServer(r, err)
+}
+
+func (p *ServerPool) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r, release, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	fwd, ok := r.(ForwardResolver)
+	if !ok {
+		return nil, fmt.Errorf("resolver does not support forward lookups")
+	}
+	addrs, err := fwd.LookupIPAddr(ctx, host)
+	return addrs, withServer(r, err)
+}
+
+// localDialer returns a dialer that binds to local, if set, for the given
+// network ("udp" or "tcp"), and gives up connecting after timeout, if set.
+func localDialer(network string, local net.IP, timeout time.Duration) *net.Dialer {
+	d := &net.Dialer{Timeout: timeout}
+	if local != nil && network == "tcp" {
+		d.LocalAddr = &net.TCPAddr{IP: local}
+	} else if local != nil {
+		d.LocalAddr = &net.UDPAddr{IP: local}
+	}
+	return d
+}
+
+// InterfaceAddr returns an address of the named network interface in the
+// same family as server (a host:port), for binding queries to that
+// interface. IPv6 link-local addresses are skipped, as they need a zone.
+func InterfaceAddr(name, server string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("interface %q: %w", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("interface %q: %w", name, err)
+	}
+
+	// Hostname servers are assumed to be reached over IPv4
+	wantV4 := true
+	if host, _, err := net.SplitHostPort(server); err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			wantV4 = ip.To4() != nil
+		}
+	}
+
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || (ipnet.IP.To4() != nil) != wantV4 || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		return ipnet.IP, nil
+	}
+	family := "IPv4"
+	if !wantV4 {
+		family = "IPv6"
+	}
+	return nil, fmt.Errorf("interface %q has no usable %s address to reach %s", name, family, server)
+}
+
+// Default timeouts for --dial-timeout and --query-timeout. Connecting to a
+// resolver is quick or fails, while an answer can legitimately take a few
+// seconds and a retry when an authoritative server is slow.
+const (
+	DefaultDialTimeout  = 2 * time.Second
+	DefaultQueryTimeout = 10 * time.Second
+)
+
+// DefaultRetryBackoff is the wait before the first --retries re-query;
+// each further attempt doubles it, up to MaxRetryBackoff.
+const DefaultRetryBackoff = 250 * time.Millisecond
+
+// MaxRetryBackoff caps the wait before any one --retries re-query, so a high
+// --retries count cannot stall a worker for hours.
+const MaxRetryBackoff = 30 * time.Second
+
+// PreflightTimeout bounds the single query Preflight makes.
+const PreflightTimeout = 5 * time.Second
+
+// Preflight makes one PTR query for ip so that an unreachable or refusing
+// resolver fails the run up front, rather than once per IP. NXDOMAIN counts
+// as success: the resolver answered.
+func Preflight(ctx context.Context, ip net.IP, resolver Resolver) error {
+	ctx, cancel := context.WithTimeout(ctx, PreflightTimeout)
+	defer cancel()
+	return lookupIP(ctx, ip, resolver).Error
+}
+
+// CheckIP is queried by --check-resolver: Google Public DNS, whose PTR
+// (dns.google) is stable and publicly resolvable.
+var CheckIP = net.ParseIP("8.8.8.8").To4()
+
+// CheckResolver makes one PTR query for ip, as a real scan would, and
+// reports the outcome and how long it took.
+func CheckResolver(ctx context.Context, ip net.IP, resolver Resolver) ResolverCheck {
+	ctx, cancel := context.WithTimeout(ctx, PreflightTimeout)
+	defer cancel()
+
+	start := time.Now()
+	r := lookupIP(ctx, ip, resolver)
+	check := ResolverCheck{
+		Query:     ip.String(),
+		PTR:       r.PTR,
+		LatencyMS: time.Since(start).Seconds() * 1000,
+		OK:        r.Error == nil,
+	}
+	if r.Error != nil {
+		check.Error = r.Error.Error()
+	}
+	return check
+}
+
+// Worker counts for --concurrency: the default, and the most a relative
+// setting ("auto" or a percentage) will pick.
+const (
+	DefaultConcurrency = 50
+	MaxConcurrency     = 1000
+)
+
+// ConcurrencySpec is a parsed --concurrency value: a fixed worker count, a
+// percentage of the addresses queried, or auto.
+type ConcurrencySpec struct {
+	N       int     // Fixed worker count, if Percent is 0 and Auto is unset
+	Percent float64 // Workers as a percentage of the addresses queried
+	Auto    bool    // Pick a count suited to the number of addresses
+}
+
+// ParseConcurrency parses "50", "50%", or "auto".
+func ParseConcurrency(s string) (ConcurrencySpec, error) {
+	s = strings.TrimSpace(s)
+	if s == "auto" {
+		return ConcurrencySpec{Auto: true}, nil
+	}
+	if pct, ok := strings.CutSuffix(s, "%"); ok {
+		p, err := strconv.ParseFloat(pct, 64)
+		if err != nil || p <= 0 || p > 100 {
+			return ConcurrencySpec{}, fmt.Errorf("invalid concurrency %q: percentage must be above 0 and at most 100", s)
+		}
+		return ConcurrencySpec{Percent: p}, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return ConcurrencySpec{}, fmt.Errorf("invalid concurrency %q: want a number, a percentage like 50%%, or auto", s)
+	}
+	if n < 1 {
+		return ConcurrencySpec{}, fmt.Errorf("concurrency must be at least 1")
+	}
+	return ConcurrencySpec{N: n}, nil
+}
+
+// Workers returns the worker count for querying n addresses. Relative
+// settings never exceed n or MaxConcurrency, and are at least 1. Auto uses
+// up to DefaultConcurrency workers, and one per 256 addresses beyond that
+// for large scans.
+func (c ConcurrencySpec) Workers(n int) int {
+	var workers int
+	switch {
+	case c.Auto:
+		workers = max(DefaultConcurrency, n/256)
+	case c.Percent > 0:
+		workers = int(math.Ceil(float64(n) * c.Percent / 100))
+	default:
+		return c.N
+	}
+	return max(1, min(workers, n, MaxConcurrency))
+}
+
+// Max returns the most workers the setting can use, for sizing resources
+// before the number of addresses is known.
+func (c ConcurrencySpec) Max() int {
+	if c.Auto || c.Percent > 0 {
+		return MaxConcurrency
+	}
+	return c.N
+}
+
+// DefaultQueueSize returns the channel buffer size used when none is given:
+// a small multiple of the worker count, so memory stays bounded regardless of
+// how many IPs are queued and the feeder blocks until workers catch up.
+func DefaultQueueSize(concurrency int) int {
+	return 2 * concurrency
 }
 
 // LookupWorkers performs concurrent PTR lookups using a worker pool.
-// Results are sent to the returned channel as they complete.
+// Results are sent to the returned channel as they complete. At most one
+// worker is started per IP, however high concurrency is.
 func LookupWorkers(ctx context.Context, ips []net.IP, concurrency int, resolver Resolver) <-chan LookupResult {
-	results := make(chan LookupResult, len(ips))
-	jobs := make(chan net.IP, len(ips))
+	return LookupWorkersQueued(ctx, ips, concurrency, DefaultQueueSize(concurrency), resolver)
+}
+
+// LookupWorkersQueued is LookupWorkers with an explicit buffer size for the
+// jobs and results channels. Sizes < 1 use DefaultQueueSize. The caller must
+// drain the returned channel; with small buffers, workers block until it does.
+func LookupWorkersQueued(ctx context.Context, ips []net.IP, concurrency, queueSize int, resolver Resolver) <-chan LookupResult {
+	return LookupWorkersWith(ctx, ips, concurrency, resolver, WorkerOptions{QueueSize: queueSize})
+}
+
+// LookupWorkersInferred is LookupWorkersQueued that stops querying an IPv4
+// /24 once inferAfter consecutive addresses in it have PTRs following one
+// pattern. The remaining addresses are reported with the pattern as their
+// PTR and Inferred set. inferAfter < 1 disables inference.
+func LookupWorkersInferred(ctx context.Context, ips []net.IP, concurrency, queueSize int, resolver Resolver, inferAfter int) <-chan LookupResult {
+	return LookupWorkersWith(ctx, ips, concurrency, resolver, WorkerOptions{QueueSize: queueSize, InferAfter: inferAfter})
+}
+
+// WorkerOptions tunes LookupWorkersWith.
+type WorkerOptions struct {
+	QueueSize  int       // Channel buffer size; < 1 uses DefaultQueueSize
+	InferAfter int       // See LookupWorkersInferred; < 1 disables inference
+	InFlight   *InFlight // If set, tracks the lookup each worker is running
+
+	// QueryTimeout bounds each PTR lookup, including the resolver's own
+	// retries and CNAME chasing; 0 leaves it to the resolver.
+	QueryTimeout time.Duration
+
+	// Retries re-queries an IP whose lookup failed (NXDOMAIN is an answer,
+	// not a failure) up to this many times, backing off from RetryBackoff
+	// (< 1 uses DefaultRetryBackoff). QueryTimeout applies to each attempt.
+	Retries      int
+	RetryBackoff time.Duration
+
+	// LoopbackNames reports 127.0.0.1 and ::1 as localhost and
+	// ip6-localhost, as /etc/hosts conventionally names them, when they
+	// have no PTR record (a public resolver knows nothing of them).
+	LoopbackNames bool
+
+	// TimeoutAsNXDomain records a lookup that timed out, after any
+	// Retries, as NXDOMAIN rather than an error. An address whose server
+	// was merely slow is then indistinguishable from one with no PTR.
+	TimeoutAsNXDomain bool
+
+	// DropSelfPTR records a PTR that just echoes the IP or its arpa name as
+	// NXDOMAIN (see isSelfPTR). The cache keeps what DNS said.
+	DropSelfPTR bool
+
+	// Cache, if set, answers IPs with a fresh cached PTR without querying
+	// them, and records the answers of those that are queried.
+	Cache *Cache
+
+	// Shuffle feeds IPs to the workers in a random order drawn from Seed,
+	// spreading load across authoritative servers. Index still refers to
+	// the input order.
+	Shuffle bool
+	Seed    uint64
+}
+
+// LookupWorkersWith runs the worker pool with the given options.
+func LookupWorkersWith(ctx context.Context, ips []net.IP, concurrency int, resolver Resolver, opts WorkerOptions) <-chan LookupResult {
+	// Workers beyond one per IP would only wait for jobs that never come
+	concurrency = min(concurrency, len(ips))
+	queueSize := opts.QueueSize
+	if queueSize < 1 {
+		queueSize = DefaultQueueSize(concurrency)
+	}
+	results := make(chan LookupResult, queueSize)
+	jobs := make(chan int, queueSize)
+
+	var inf *patternInferrer
+	if opts.InferAfter > 0 {
+		inf = newPatternInferrer(opts.InferAfter)
+	}
+	tracker := opts.InFlight
 
 	var wg sync.WaitGroup
 
 	// Start workers
 	for i := 0; i < concurrency; i++ {
 		wg.Add(1)
-		go func() {
+		go func(worker int) {
 			defer wg.Done()
-			for ip := range jobs {
-				result := lookupIP(ctx, ip, resolver)
+			for idx := range jobs {
+				var result LookupResult
+				if pattern, ok := inf.inferred(ips[idx]); ok {
+					result = LookupResult{IP: ips[idx], PTR: pattern, Inferred: true}
+				} else if ptr, ok := opts.Cache.Get(ips[idx]); ok {
+					result = LookupResult{IP: ips[idx], PTR: ptr}
+					if opts.DropSelfPTR && isSelfPTR(result.IP, result.PTR) {
+						result.PTR = ""
+					}
+					inf.record(result)
+				} else {
+					tracker.start(worker, ips[idx])
+					result = lookupIPRetry(ctx, ips[idx], resolver, opts)
+					opts.Cache.Put(result) // What DNS said, before --loopback-names
+					if opts.TimeoutAsNXDomain && ErrorCategory(result.Error) == ErrorTimeout {
+						result.Error = nil
+					}
+					if opts.DropSelfPTR && isSelfPTR(result.IP, result.PTR) {
+						result.PTR = ""
+					}
+					if opts.LoopbackNames {
+						nameLoopback(&result)
+					}
+					tracker.done(worker)
+					inf.record(result)
+				}
+				result.Index = idx
 				results <- result
 			}
-		}()
+		}(i)
 	}
 
 	// Send jobs
 	go func() {
-		for _, ip := range ips {
-			jobs <- ip
+		if opts.Shuffle {
+			for _, i := range shuffledOrder(len(ips), opts.Seed) {
+				jobs <- i
+			}
+		} else {
+			for i := range ips {
+				jobs <- i
+			}
 		}
 		close(jobs)
 	}()
@@ -109,19 +639,312 @@ func LookupWorkers(ctx context.Context, ips []net.IP, concurrency int, resolver
 	return results
 }
 
+// shuffledOrder returns a permutation of 0..n-1 determined by seed.
+func shuffledOrder(n int, seed uint64) []int {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	rng := rand.New(rand.NewPCG(seed, seed))
+	rng.Shuffle(n, func(i, j int) { order[i], order[j] = order[j], order[i] })
+	return order
+}
+
+// VerifyResults forward-confirms each resolved PTR (FCrDNS): a result is
+// marked Verified if its PTR name resolves back to its IP. If searchDomain is
+// set, it qualifies relative names for the forward lookup (see
+// verifyCandidates). Lookups run concurrently with the given worker count;
+// results are updated in place.
+func VerifyResults(ctx context.Context, results []LookupResult, concurrency int, resolver ForwardResolver, searchDomain string) {
+	forEachResolved(results, concurrency, func(r *LookupResult) {
+		r.Verified = false
+		for _, name := range verifyCandidates(r.PTR, searchDomain) {
+			if forwardConfirms(ctx, r.IP, name, resolver) {
+				r.Verified = true
+				return
+			}
+		}
+	})
+}
+
+// verifyCandidates returns the names to try when forward-confirming ptr.
+// Single-label names are only tried qualified with searchDomain; other names
+// are tried as-is, then qualified, mirroring a resolver search list.
+func verifyCandidates(ptr, searchDomain string) []string {
+	searchDomain = strings.Trim(searchDomain, ".")
+	if searchDomain == "" {
+		return []string{ptr}
+	}
+	qualified := ptr + "." + searchDomain
+	if !strings.Contains(ptr, ".") {
+		return []string{qualified}
+	}
+	return []string{ptr, qualified}
+}
+
+// DualStackResults looks up the A and AAAA records of each resolved PTR name
+// and records which address families it has ("ipv4", "ipv6"). Results are
+// updated in place.
+func DualStackResults(ctx context.Context, results []LookupResult, concurrency int, resolver ForwardResolver) {
+	forEachResolved(results, concurrency, func(r *LookupResult) {
+		r.Families = addressFamilies(ctx, r.PTR, resolver)
+	})
+}
+
+// ResolveHosts looks up the A and AAAA addresses of each host, for reverse
+// lookups of a service's front-end IPs. It returns the distinct addresses in
+// order, with the host each came from as its source. If family is "ipv4" or
+// "ipv6", addresses of the other family are skipped. A host that does not
+// resolve is an error.
+func ResolveHosts(ctx context.Context, hosts []string, resolver ForwardResolver, family string) ([]net.IP, []string, error) {
+	var ips []net.IP
+	var sources []string
+	seen := make(map[string]bool)
+	for _, host := range hosts {
+		addrs, err := resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, nil, fmt.Errorf("resolving host %q: %w", host, err)
+		}
+		for _, a := range addrs {
+			isIPv4 := a.IP.To4() != nil
+			if (family == "ipv4" && !isIPv4) || (family == "ipv6" && isIPv4) {
+				continue
+			}
+			ip := a.IP
+			if isIPv4 {
+				ip = ip.To4()
+			}
+			if seen[ip.String()] {
+				continue
+			}
+			seen[ip.String()] = true
+			ips = append(ips, ip)
+			sources = append(sources, host)
+		}
+	}
+	return ips, sources, nil
+}
+
+// SetSources sets Source on each result from the per-IP input CIDRs returned
+// by ParseCIDRsWithSources, using the result's Index.
+func SetSources(results []LookupResult, sources []string) {
+	for i := range results {
+		if idx := results[i].Index; idx >= 0 && idx < len(sources) {
+			results[i].Source = sources[idx]
+		}
+	}
+}
+
+// CompareResults repeats every lookup against resolver and records its answer
+// alongside the original one. A result is marked Mismatch when both lookups
+// succeeded (PTR or NXDOMAIN) but returned different names; a failed lookup
+// on either side is not a mismatch. Results are updated in place.
+func CompareResults(ctx context.Context, results []LookupResult, concurrency int, resolver Resolver) {
+	forEachResult(results, concurrency, func(r *LookupResult) bool { return !r.Inferred }, func(r *LookupResult) {
+		other := lookupIP(ctx, r.IP, resolver)
+		r.ComparePTR = other.PTR
+		r.CompareError = other.Error
+		r.Mismatch = r.Error == nil && other.Error == nil && !strings.EqualFold(r.PTR, other.PTR)
+	})
+}
+
+// forEachResolved calls fn concurrently for every queried result with a PTR,
+// using the given number of workers.
+func forEachResolved(results []LookupResult, concurrency int, fn func(r *LookupResult)) {
+	forEachResult(results, concurrency, func(r *LookupResult) bool {
+		return r.PTR != "" && r.Error == nil && !r.Inferred
+	}, fn)
+}
+
+// forEachResult calls fn concurrently for every result accepted by match,
+// using the given number of workers.
+func forEachResult(results []LookupResult, concurrency int, match func(r *LookupResult) bool, fn func(r *LookupResult)) {
+	jobs := make(chan int, DefaultQueueSize(concurrency))
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				fn(&results[idx])
+			}
+		}()
+	}
+
+	for i := range results {
+		if match(&results[i]) {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// addressFamilies returns the families name has addresses in, IPv4 first.
+// Returns nil if the name does not resolve.
+func addressFamilies(ctx context.Context, name string, resolver ForwardResolver) []string {
+	addrs, err := resolver.LookupIPAddr(ctx, name)
+	if err != nil {
+		return nil
+	}
+	var has4, has6 bool
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			has4 = true
+		} else {
+			has6 = true
+		}
+	}
+	var families []string
+	if has4 {
+		families = append(families, "ipv4")
+	}
+	if has6 {
+		families = append(families, "ipv6")
+	}
+	return families
+}
+
+// forwardConfirms reports whether name resolves to an address equal to ip.
+func forwardConfirms(ctx context.Context, ip net.IP, name string, resolver ForwardResolver) bool {
+	addrs, err := resolver.LookupIPAddr(ctx, name)
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if a.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// reverseName returns the in-addr.arpa or ip6.arpa name for an IP,
+// without a trailing dot.
+func reverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+
+	ip16 := ip.To16()
+	var b strings.Builder
+	for i := len(ip16) - 1; i >= 0; i-- {
+		b.WriteByte("0123456789abcdef"[ip16[i]&0x0f])
+		b.WriteByte('.')
+		b.WriteByte("0123456789abcdef"[ip16[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa")
+	return b.String()
+}
+
+// isSelfPTR reports whether a PTR merely echoes the queried address, either
+// as the literal IP or as its reverse name. Such records carry no information.
+func isSelfPTR(ip net.IP, ptr string) bool {
+	if ptr == "" {
+		return false
+	}
+	ptr = strings.TrimSuffix(ptr, ".")
+	if parsed := net.ParseIP(ptr); parsed != nil {
+		return parsed.Equal(ip)
+	}
+	return strings.EqualFold(ptr, reverseName(ip))
+}
+
+// lookupIPRetry is lookupIPTimeout retried on failure as opts.Retries asks.
+func lookupIPRetry(ctx context.Context, ip net.IP, resolver Resolver, opts WorkerOptions) LookupResult {
+	backoff := opts.RetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		result := lookupIPTimeout(ctx, ip, resolver, opts.QueryTimeout)
+		if result.Error == nil || attempt >= opts.Retries {
+			return result
+		}
+		select {
+		case <-ctx.Done():
+			return result
+		case <-time.After(retryDelay(backoff, attempt)):
+		}
+	}
+}
+
+// loopbackPTRs are the names --loopback-names gives the canonical loopback
+// addresses.
+var loopbackPTRs = map[string]string{
+	"127.0.0.1": "localhost",
+	"::1":       "ip6-localhost",
+}
+
+// nameLoopback sets the PTR of a loopback address without one to its
+// conventional name. Failed lookups are left as they are.
+func nameLoopback(r *LookupResult) {
+	if r.PTR != "" || r.Error != nil {
+		return
+	}
+	if name, ok := loopbackPTRs[r.IP.String()]; ok {
+		r.PTR = name
+	}
+}
+
+// retryDelay returns the wait before retry attempt+1: backoff doubled per
+// attempt, plus random jitter of up to as much again. Workers that failed
+// together in a resolver blip then spread their retries out instead of
+// hitting it again in lockstep. The doubling stops at half MaxRetryBackoff,
+// so the delay stays below it and is still jittered.
+func retryDelay(backoff time.Duration, attempt int) time.Duration {
+	d := backoff << min(attempt, 16)
+	if d <= 0 || d > MaxRetryBackoff/2 { // <= 0 if the shift overflowed
+		d = MaxRetryBackoff / 2
+	}
+	return d + rand.N(d)
+}
+
+// lookupIPTimeout is lookupIP bounded by timeout, if set.
+func lookupIPTimeout(ctx context.Context, ip net.IP, resolver Resolver, timeout time.Duration) LookupResult {
+	if timeout <= 0 {
+		return lookupIP(ctx, ip, resolver)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return lookupIP(ctx, ip, resolver)
+}
+
 // lookupIP performs a single PTR lookup.
 func lookupIP(ctx context.Context, ip net.IP, resolver Resolver) LookupResult {
-	names, err := resolver.LookupAddr(ctx, ip.String())
-
 	result := LookupResult{IP: ip}
 
-	if err != nil {
+	var names []string
+	var err error
+	if pr, ok := resolver.(PTRResolver); ok {
+		var resp *PTRResponse
+		resp, err = pr.LookupPTR(ctx, ip.String())
+		if resp != nil {
+			names = resp.Names
+			result.Authoritative = resp.Authoritative
+			result.Signed = resp.Signed
+			result.AuthenticData = resp.AuthenticData
+			for _, c := range resp.CNAMEs {
+				result.CNAMEs = append(result.CNAMEs, strings.TrimSuffix(c, "."))
+			}
+		}
+	} else {
+		names, err = resolver.LookupAddr(ctx, ip.String())
+	}
+
+	if err != nil && len(names) > 0 {
+		// A partial answer, such as names alongside an invalid record:
+		// keep what was returned and note the error
+		result.Warning = newLookupError(ip, resolver, err)
+	} else if err != nil {
 		// Check if it's a "not found" error (NXDOMAIN)
 		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
 			// NXDOMAIN is not an error, just no PTR record
 			return result
 		}
-		result.Error = err
+		result.Error = newLookupError(ip, resolver, err)
 		return result
 	}
 
@@ -3,20 +3,48 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
+	"maps"
 	"net"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // MockResolver implements Resolver for testing.
 type MockResolver struct {
 	results map[string][]string
 	errors  map[string]error
+	forward map[string][]net.IPAddr
 }
 
 func NewMockResolver() *MockResolver {
 	return &MockResolver{
 		results: make(map[string][]string),
 		errors:  make(map[string]error),
+		forward: make(map[string][]net.IPAddr),
+	}
+}
+
+// AddForward registers the addresses a hostname forward-resolves to.
+func (m *MockResolver) AddForward(host string, ips ...string) {
+	for _, ip := range ips {
+		m.forward[host] = append(m.forward[host], net.IPAddr{IP: net.ParseIP(ip)})
+	}
+}
+
+func (m *MockResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if addrs, ok := m.forward[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{
+		Err:        "no such host",
+		Name:       host,
+		IsNotFound: true,
 	}
 }
 
@@ -38,7 +66,7 @@ func (m *MockResolver) AddNXDomain(ip string) {
 
 func (m *MockResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
 	if err, ok := m.errors[addr]; ok {
-		return nil, err
+		return m.results[addr], err // names too, if added: a partial answer
 	}
 	if ptrs, ok := m.results[addr]; ok {
 		return ptrs, nil
@@ -78,6 +106,13 @@ func TestLookupWorkers(t *testing.T) {
 		t.Errorf("got %d results, want 4", len(results))
 	}
 
+	// Each result carries its input position
+	for i, ip := range ips {
+		if r := results[ip.String()]; r.Index != i {
+			t.Errorf("%s Index = %d, want %d", ip, r.Index, i)
+		}
+	}
+
 	// Check resolved IP
 	if r := results["192.168.1.1"]; r.PTR != "host1.example.com" {
 		t.Errorf("192.168.1.1 PTR = %q, want %q", r.PTR, "host1.example.com")
@@ -144,6 +179,224 @@ func TestLookupWorkersConcurrency(t *testing.T) {
 	}
 }
 
+func TestParseConcurrency(t *testing.T) {
+	tests := []struct {
+		spec    string
+		ips     int
+		want    int
+		wantErr bool
+	}{
+		{spec: "50", ips: 4, want: 50},
+		{spec: " 8 ", ips: 1000, want: 8},
+		{spec: "50%", ips: 10, want: 5},
+		{spec: "50%", ips: 3, want: 2},
+		{spec: "1%", ips: 10, want: 1},
+		{spec: "100%", ips: 100000, want: MaxConcurrency},
+		{spec: "auto", ips: 10, want: 10},
+		{spec: "auto", ips: 1000, want: DefaultConcurrency},
+		{spec: "auto", ips: 65536, want: 256},
+		{spec: "0", wantErr: true},
+		{spec: "-3", wantErr: true},
+		{spec: "0%", wantErr: true},
+		{spec: "150%", wantErr: true},
+		{spec: "lots", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			spec, err := ParseConcurrency(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseConcurrency(%q) succeeded, want error", tt.spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseConcurrency(%q) error: %v", tt.spec, err)
+			}
+			if got := spec.Workers(tt.ips); got != tt.want {
+				t.Errorf("Workers(%d) = %d, want %d", tt.ips, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupWorkersQueued(t *testing.T) {
+	resolver := NewMockResolver()
+	ips, _ := ExpandCIDR("10.0.0.0/22", 0)
+
+	// A queue far smaller than the input must still deliver everything
+	for _, size := range []int{1, 3, 0} {
+		count := 0
+		for range LookupWorkersQueued(context.Background(), ips, 4, size, resolver) {
+			count++
+		}
+		if count != len(ips) {
+			t.Errorf("queue size %d: got %d results, want %d", size, count, len(ips))
+		}
+	}
+}
+
+func TestVerifyResults(t *testing.T) {
+	resolver := NewMockResolver()
+	resolver.AddForward("good.example.com", "192.0.2.1")
+	resolver.AddForward("stale.example.com", "198.51.100.7")
+
+	results := []LookupResult{
+		{IP: net.ParseIP("192.0.2.1"), PTR: "good.example.com"},
+		{IP: net.ParseIP("192.0.2.2"), PTR: "stale.example.com"},
+		{IP: net.ParseIP("192.0.2.3"), PTR: "gone.example.com"},
+		{IP: net.ParseIP("192.0.2.4")},
+	}
+
+	VerifyResults(context.Background(), results, 2, resolver, "")
+
+	want := []bool{true, false, false, false}
+	for i, w := range want {
+		if results[i].Verified != w {
+			t.Errorf("%s Verified = %v, want %v", results[i].IP, results[i].Verified, w)
+		}
+	}
+}
+
+func TestCompareResults(t *testing.T) {
+	system := NewMockResolver()
+	system.AddResult("192.0.2.1", "same.example.com.")
+	system.AddResult("192.0.2.2", "internal.corp.example.")
+	system.AddResult("192.0.2.3", "appears.example.com.")
+	system.AddError("192.0.2.4", errors.New("timeout"))
+
+	results := []LookupResult{
+		{IP: net.ParseIP("192.0.2.1"), PTR: "SAME.example.com"},
+		{IP: net.ParseIP("192.0.2.2"), PTR: "public.example.com"},
+		{IP: net.ParseIP("192.0.2.3")},
+		{IP: net.ParseIP("192.0.2.4"), PTR: "host.example.com"},
+		{IP: net.ParseIP("192.0.2.5")},
+	}
+
+	CompareResults(context.Background(), results, 2, system)
+
+	tests := []struct {
+		ptr      string
+		err      bool
+		mismatch bool
+	}{
+		{"same.example.com", false, false},
+		{"internal.corp.example", false, true},
+		{"appears.example.com", false, true},
+		{"", true, false},
+		{"", false, false},
+	}
+	for i, tt := range tests {
+		r := results[i]
+		if r.ComparePTR != tt.ptr || (r.CompareError != nil) != tt.err || r.Mismatch != tt.mismatch {
+			t.Errorf("%s: ComparePTR=%q CompareError=%v Mismatch=%v, want %q err=%v mismatch=%v",
+				r.IP, r.ComparePTR, r.CompareError, r.Mismatch, tt.ptr, tt.err, tt.mismatch)
+		}
+	}
+}
+
+func TestResolveHosts(t *testing.T) {
+	resolver := NewMockResolver()
+	resolver.AddForward("www.example.com", "192.0.2.1", "2001:db8::1")
+	resolver.AddForward("api.example.com", "192.0.2.2", "192.0.2.1")
+
+	hosts := []string{"www.example.com", "api.example.com"}
+	tests := []struct {
+		family      string
+		wantIPs     string
+		wantSources string
+	}{
+		{"", "192.0.2.1 2001:db8::1 192.0.2.2", "www.example.com www.example.com api.example.com"},
+		{"ipv4", "192.0.2.1 192.0.2.2", "www.example.com api.example.com"},
+		{"ipv6", "2001:db8::1", "www.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run("family="+tt.family, func(t *testing.T) {
+			ips, sources, err := ResolveHosts(context.Background(), hosts, resolver, tt.family)
+			if err != nil {
+				t.Fatalf("ResolveHosts error: %v", err)
+			}
+			var got []string
+			for _, ip := range ips {
+				got = append(got, ip.String())
+			}
+			if strings.Join(got, " ") != tt.wantIPs {
+				t.Errorf("ips = %v, want %s", got, tt.wantIPs)
+			}
+			if strings.Join(sources, " ") != tt.wantSources {
+				t.Errorf("sources = %v, want %s", sources, tt.wantSources)
+			}
+		})
+	}
+
+	if _, _, err := ResolveHosts(context.Background(), []string{"missing.example.com"}, resolver, ""); err == nil || !strings.Contains(err.Error(), "missing.example.com") {
+		t.Errorf("err = %v, want error naming the host", err)
+	}
+}
+
+func TestVerifyResultsSearchDomain(t *testing.T) {
+	resolver := NewMockResolver()
+	resolver.AddForward("host1.corp.example", "10.0.0.1")
+	resolver.AddForward("db.east.corp.example", "10.0.0.2")
+
+	results := []LookupResult{
+		{IP: net.ParseIP("10.0.0.1"), PTR: "host1"},
+		{IP: net.ParseIP("10.0.0.2"), PTR: "db.east"},
+	}
+
+	VerifyResults(context.Background(), results, 2, resolver, "")
+	if results[0].Verified || results[1].Verified {
+		t.Fatal("relative names should not verify without a search domain")
+	}
+
+	VerifyResults(context.Background(), results, 2, resolver, "corp.example.")
+	for _, r := range results {
+		if !r.Verified {
+			t.Errorf("%s (%s) should verify with search domain", r.IP, r.PTR)
+		}
+	}
+}
+
+func TestVerifyCandidates(t *testing.T) {
+	tests := []struct {
+		ptr, domain string
+		want        string
+	}{
+		{"host.example.com", "", "host.example.com"},
+		{"host1", "corp.example", "host1.corp.example"},
+		{"db.east", "corp.example", "db.east,db.east.corp.example"},
+		{"host1", ".corp.example.", "host1.corp.example"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(verifyCandidates(tt.ptr, tt.domain), ","); got != tt.want {
+			t.Errorf("verifyCandidates(%q, %q) = %q, want %q", tt.ptr, tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestDualStackResults(t *testing.T) {
+	resolver := NewMockResolver()
+	resolver.AddForward("both.example.com", "192.0.2.1", "2001:db8::1")
+	resolver.AddForward("v6.example.com", "2001:db8::2")
+
+	results := []LookupResult{
+		{IP: net.ParseIP("192.0.2.1"), PTR: "both.example.com"},
+		{IP: net.ParseIP("192.0.2.2"), PTR: "v6.example.com"},
+		{IP: net.ParseIP("192.0.2.3"), PTR: "gone.example.com"},
+	}
+
+	DualStackResults(context.Background(), results, 2, resolver)
+
+	want := []string{"ipv4,ipv6", "ipv6", ""}
+	for i, w := range want {
+		if got := strings.Join(results[i].Families, ","); got != w {
+			t.Errorf("%s Families = %q, want %q", results[i].IP, got, w)
+		}
+	}
+}
+
 func TestCustomResolver(t *testing.T) {
 	r, err := CustomResolver("8.8.8.8")
 	if err != nil {
@@ -204,3 +457,662 @@ func TestCustomResolverInvalidServer(t *testing.T) {
 		})
 	}
 }
+
+func TestReverseName(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"192.0.2.10", "10.2.0.192.in-addr.arpa"},
+		{"0.0.0.0", "0.0.0.0.in-addr.arpa"},
+		{"255.255.255.255", "255.255.255.255.in-addr.arpa"},
+		{"::ffff:192.0.2.10", "10.2.0.192.in-addr.arpa"}, // IPv4-mapped is queried as IPv4
+		{"2001:db8::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"},
+		{"2001:db8:abcd:12::f00d", "d.0.0.f.0.0.0.0.0.0.0.0.0.0.0.0.2.1.0.0.d.c.b.a.8.b.d.0.1.0.0.2.ip6.arpa"},
+		{"::", "0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa"},
+		{"::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa"},
+		{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.ip6.arpa"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := reverseName(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("reverseName(%s) = %q, want %q", tt.ip, got, tt.want)
+			}
+		})
+	}
+
+	// The 4-byte form of an IPv4 address gives the same name
+	if got := reverseName(net.ParseIP("192.0.2.10").To4()); got != "10.2.0.192.in-addr.arpa" {
+		t.Errorf("reverseName(4-byte 192.0.2.10) = %q", got)
+	}
+
+	// Every IPv6 name has 32 single-nibble labels
+	name := reverseName(net.ParseIP("2001:db8:1234:5678:9abc:def0:1234:5678"))
+	if labels := strings.Split(strings.TrimSuffix(name, ".ip6.arpa"), "."); len(labels) != 32 {
+		t.Errorf("got %d nibble labels in %q, want 32", len(labels), name)
+	}
+}
+
+func TestIsSelfPTR(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		ptr  string
+		want bool
+	}{
+		{"bare ipv4", "192.0.2.10", "192.0.2.10", true},
+		{"ipv4 arpa name", "192.0.2.10", "10.2.0.192.in-addr.arpa", true},
+		{"ipv4 arpa name trailing dot", "192.0.2.10", "10.2.0.192.IN-ADDR.ARPA.", true},
+		{"bare ipv6", "2001:db8::1", "2001:db8::1", true},
+		{"ipv6 arpa name", "2001:db8::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa", true},
+		{"different ip", "192.0.2.10", "192.0.2.11", false},
+		{"real hostname", "192.0.2.10", "host.example.com", false},
+		{"empty", "192.0.2.10", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSelfPTR(net.ParseIP(tt.ip), tt.ptr); got != tt.want {
+				t.Errorf("isSelfPTR(%s, %q) = %v, want %v", tt.ip, tt.ptr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupErrorFormat(t *testing.T) {
+	ip := net.ParseIP("192.0.2.1")
+	tests := []struct {
+		name     string
+		resolver Resolver
+		err      error
+		want     string
+	}{
+		{
+			name:     "plain error, unknown server",
+			resolver: NewMockResolver(),
+			err:      errors.New("connection refused"),
+			want:     "lookup 192.0.2.1: connection refused",
+		},
+		{
+			name:     "DNS timeout names its server",
+			resolver: NewMockResolver(),
+			err:      &net.DNSError{Err: "i/o timeout", Name: "1.2.0.192.in-addr.arpa.", Server: "10.0.0.53:53", IsTimeout: true},
+			want:     "lookup 192.0.2.1 via 10.0.0.53:53: timeout",
+		},
+		{
+			name:     "resolver server wins over the error's",
+			resolver: &NetResolver{Server: "8.8.8.8:53"},
+			err:      &net.DNSError{Err: "server misbehaving", Server: "127.0.0.53:53"},
+			want:     "lookup 192.0.2.1 via 8.8.8.8:53: server misbehaving",
+		},
+		{
+			name:     "DNSClient server",
+			resolver: &DNSClient{Server: "1.1.1.1:53"},
+			err:      &net.DNSError{Err: "server misbehaving: RCodeServerFailure"},
+			want:     "lookup 192.0.2.1 via 1.1.1.1:53: server misbehaving: RCodeServerFailure",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newLookupError(ip, tt.resolver, tt.err)
+			if err.Error() != tt.want {
+				t.Errorf("Error() = %q, want %q", err.Error(), tt.want)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Error("LookupError should unwrap to the original error")
+			}
+		})
+	}
+
+	// lookupIP wraps failures but still reports NXDOMAIN as no error
+	resolver := NewMockResolver()
+	resolver.AddError("192.0.2.1", errors.New("connection refused"))
+	result := lookupIP(context.Background(), ip, resolver)
+	if result.Error == nil || result.Error.Error() != "lookup 192.0.2.1: connection refused" {
+		t.Errorf("lookupIP error = %v", result.Error)
+	}
+	if r := lookupIP(context.Background(), net.ParseIP("192.0.2.2"), resolver); r.Error != nil {
+		t.Errorf("NXDOMAIN should not be an error, got %v", r.Error)
+	}
+}
+
+func TestErrorCategory(t *testing.T) {
+	ip := net.ParseIP("192.0.2.1")
+	wrap := func(err error) error { return newLookupError(ip, NewMockResolver(), err) }
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, ""},
+		{"DNS timeout", wrap(&net.DNSError{Err: "i/o timeout", IsTimeout: true}), ErrorTimeout},
+		{"query deadline", wrap(context.DeadlineExceeded), ErrorTimeout},
+		{"canceled", wrap(fmt.Errorf("lookup: %w", context.Canceled)), ErrorCanceled},
+		{"DNSClient SERVFAIL", wrap(&net.DNSError{Err: "server misbehaving: RCodeServerFailure"}), ErrorServFail},
+		{"system resolver SERVFAIL", wrap(&net.DNSError{Err: "server misbehaving", IsTemporary: true}), ErrorServFail},
+		{"DNSClient REFUSED", wrap(&net.DNSError{Err: "server misbehaving: RCodeRefused"}), ErrorRefused},
+		{"dial error", wrap(&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")}), ErrorNetwork},
+		{"dial error as DNS text", wrap(&net.DNSError{Err: "dial tcp 10.0.0.53:53: connect: connection refused"}), ErrorNetwork},
+		{"other", wrap(errors.New("DoH server returned 502 Bad Gateway")), ErrorOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorCategory(tt.err); got != tt.want {
+				t.Errorf("ErrorCategory(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShuffledOrder(t *testing.T) {
+	a := shuffledOrder(100, 42)
+	b := shuffledOrder(100, 42)
+	c := shuffledOrder(100, 43)
+
+	seen := make(map[int]bool)
+	for _, i := range a {
+		seen[i] = true
+	}
+	if len(seen) != 100 {
+		t.Fatalf("order is not a permutation: %v", a)
+	}
+	if fmt.Sprint(a) != fmt.Sprint(b) {
+		t.Error("same seed should give the same order")
+	}
+	if fmt.Sprint(a) == fmt.Sprint(c) {
+		t.Error("different seeds should give different orders")
+	}
+}
+
+func TestLookupWorkersShuffle(t *testing.T) {
+	resolver := NewMockResolver()
+	ips := make([]net.IP, 50)
+	for i := range ips {
+		ips[i] = net.IPv4(10, 0, 0, byte(i)).To4()
+		resolver.AddResult(ips[i].String(), fmt.Sprintf("host%d.example.com.", i))
+	}
+
+	// With one worker, results arrive in feed order
+	var order []int
+	for r := range LookupWorkersWith(context.Background(), ips, 1, resolver, WorkerOptions{Shuffle: true, Seed: 7}) {
+		if !r.IP.Equal(ips[r.Index]) {
+			t.Fatalf("Index %d does not match IP %s", r.Index, r.IP)
+		}
+		order = append(order, r.Index)
+	}
+	if fmt.Sprint(order) != fmt.Sprint(shuffledOrder(len(ips), 7)) {
+		t.Errorf("feed order = %v, want shuffledOrder(50, 7)", order)
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	resolver := NewMockResolver()
+	resolver.AddResult("192.0.2.1", "host.example.com")
+	resolver.AddError("192.0.2.3", errors.New("connection refused"))
+	ctx := context.Background()
+
+	if err := Preflight(ctx, net.ParseIP("192.0.2.1"), resolver); err != nil {
+		t.Errorf("answered query: unexpected error %v", err)
+	}
+	if err := Preflight(ctx, net.ParseIP("192.0.2.2"), resolver); err != nil {
+		t.Errorf("NXDOMAIN: unexpected error %v", err)
+	}
+	err := Preflight(ctx, net.ParseIP("192.0.2.3"), resolver)
+	if err == nil || !strings.Contains(err.Error(), "192.0.2.3") {
+		t.Errorf("failed query: err = %v, want lookup error naming the IP", err)
+	}
+}
+
+func TestLookupIPPartialAnswer(t *testing.T) {
+	resolver := NewMockResolver()
+	resolver.AddResult("192.0.2.1", "good.example.com.")
+	resolver.AddError("192.0.2.1", &net.DNSError{Err: "DNS response contained records which contain invalid names", Name: "192.0.2.1"})
+
+	r := lookupIP(context.Background(), net.ParseIP("192.0.2.1"), resolver)
+	if r.Error != nil {
+		t.Fatalf("Error = %v, want nil for a partial answer", r.Error)
+	}
+	if r.PTR != "good.example.com" {
+		t.Errorf("PTR = %q, want good.example.com", r.PTR)
+	}
+	if r.Warning == nil || !strings.Contains(r.Warning.Error(), "invalid names") {
+		t.Errorf("Warning = %v, want the resolver's error", r.Warning)
+	}
+
+	line := textLine(r, OutputOptions{})
+	if !strings.Contains(line, "good.example.com (partial answer: ") {
+		t.Errorf("textLine = %q, want PTR with partial answer note", line)
+	}
+}
+
+func TestBoundResolver(t *testing.T) {
+	srv := startFakeDNS(t)
+	srv.AddPTR("1.2.0.192.in-addr.arpa.", "host.example.com.")
+
+	resolver, err := BoundResolver(srv.Addr(), net.ParseIP("127.0.0.1"), DefaultDialTimeout)
+	if err != nil {
+		t.Fatalf("BoundResolver error: %v", err)
+	}
+	r := lookupIP(context.Background(), net.ParseIP("192.0.2.1"), resolver)
+	if r.Error != nil || r.PTR != "host.example.com" {
+		t.Errorf("lookup = %q, %v; want host.example.com", r.PTR, r.Error)
+	}
+}
+
+// countingResolver records how many lookups it has served and the most it
+// had in flight at once. Each lookup holds its slot briefly so overlap is
+// observable.
+type countingResolver struct {
+	server   string
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+	served   int
+}
+
+func (c *countingResolver) ServerAddr() string { return c.server }
+
+func (c *countingResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	c.mu.Lock()
+	c.inFlight++
+	c.served++
+	c.peak = max(c.peak, c.inFlight)
+	c.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+	return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+}
+
+func TestServerPoolRoundRobin(t *testing.T) {
+	a, b := &countingResolver{server: "a:53"}, &countingResolver{server: "b:53"}
+	pool := NewServerPool([]Resolver{a, b}, 0)
+
+	ips, _ := ExpandCIDR("10.0.0.0/26", 0)
+	for range LookupWorkers(context.Background(), ips, 8, pool) {
+	}
+	if a.served != 32 || b.served != 32 {
+		t.Errorf("served %d and %d lookups, want 32 each", a.served, b.served)
+	}
+}
+
+func TestServerPoolPerServerCap(t *testing.T) {
+	a, b := &countingResolver{server: "a:53"}, &countingResolver{server: "b:53"}
+	pool := NewServerPool([]Resolver{a, b}, 3)
+
+	ips, _ := ExpandCIDR("10.0.0.0/25", 0)
+	for range LookupWorkers(context.Background(), ips, 20, pool) {
+	}
+	if a.peak > 3 || b.peak > 3 {
+		t.Errorf("peak in-flight = %d and %d, want at most 3 per server", a.peak, b.peak)
+	}
+	if a.served+b.served != len(ips) {
+		t.Errorf("served %d lookups, want %d", a.served+b.served, len(ips))
+	}
+}
+
+func TestServerPoolErrorNamesServer(t *testing.T) {
+	mock := NewMockResolver()
+	mock.AddError("192.0.2.1", &net.DNSError{Err: "server misbehaving", Server: "127.0.0.53:53"})
+	pool := NewServerPool([]Resolver{&namedResolver{mock, "10.0.0.53:53"}}, 0)
+
+	r := lookupIP(context.Background(), net.ParseIP("192.0.2.1"), pool)
+	if r.Error == nil || !strings.Contains(r.Error.Error(), "via 10.0.0.53:53") {
+		t.Errorf("error = %v, want it to name 10.0.0.53:53", r.Error)
+	}
+}
+
+// namedResolver gives a MockResolver a server address.
+type namedResolver struct {
+	*MockResolver
+	server string
+}
+
+func (n *namedResolver) ServerAddr() string { return n.server }
+
+// blockingResolver never answers; lookups end when their context does.
+type blockingResolver struct{}
+
+func (blockingResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestLookupWorkersQueryTimeout(t *testing.T) {
+	ips, _ := ExpandCIDR("192.0.2.0/30", 0)
+	start := time.Now()
+	for r := range LookupWorkersWith(context.Background(), ips, 2, blockingResolver{}, WorkerOptions{QueryTimeout: 20 * time.Millisecond}) {
+		if !errors.Is(r.Error, context.DeadlineExceeded) {
+			t.Errorf("%s error = %v, want deadline exceeded", r.IP, r.Error)
+		}
+	}
+	// Four lookups on two workers end after two timeouts
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("lookups took %v, want them bounded by the query timeout", elapsed)
+	}
+}
+
+func TestLookupWorkersLoopbackNames(t *testing.T) {
+	mock := NewMockResolver()
+	for _, ip := range []string{"127.0.0.1", "::1", "127.0.0.2"} {
+		mock.AddNXDomain(ip)
+	}
+	mock.AddResult("127.0.0.53", "resolver.local.")
+	mock.AddNXDomain("192.0.2.1")
+	ips := []net.IP{
+		net.ParseIP("127.0.0.1"), net.ParseIP("::1"), net.ParseIP("127.0.0.2"),
+		net.ParseIP("127.0.0.53"), net.ParseIP("192.0.2.1"),
+	}
+
+	for _, enabled := range []bool{false, true} {
+		got := make(map[string]string)
+		for r := range LookupWorkersWith(context.Background(), ips, 2, mock, WorkerOptions{LoopbackNames: enabled}) {
+			got[r.IP.String()] = r.PTR
+		}
+		want := map[string]string{"127.0.0.1": "", "::1": "", "127.0.0.2": "", "127.0.0.53": "resolver.local", "192.0.2.1": ""}
+		if enabled {
+			// Only the canonical addresses, and never over a real PTR
+			want["127.0.0.1"], want["::1"] = "localhost", "ip6-localhost"
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("LoopbackNames=%v: PTRs = %v, want %v", enabled, got, want)
+		}
+	}
+}
+
+func TestLookupWorkersTimeoutAsNXDomain(t *testing.T) {
+	mock := NewMockResolver()
+	mock.AddError("192.0.2.1", &net.DNSError{Err: "i/o timeout", IsTimeout: true})
+	mock.AddError("192.0.2.2", &net.DNSError{Err: "server misbehaving"})
+	mock.AddResult("192.0.2.3", "host.example.com.")
+	ips := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"), net.ParseIP("192.0.2.3")}
+
+	for _, enabled := range []bool{false, true} {
+		results := make(map[string]LookupResult)
+		for r := range LookupWorkersWith(context.Background(), ips, 2, mock, WorkerOptions{TimeoutAsNXDomain: enabled}) {
+			results[r.IP.String()] = r
+		}
+		if timedOut := results["192.0.2.1"]; (timedOut.Error == nil) != enabled || timedOut.PTR != "" {
+			t.Errorf("TimeoutAsNXDomain=%v: timed-out lookup = %+v", enabled, timedOut)
+		}
+		// Other failures stay errors, and answers are untouched
+		if results["192.0.2.2"].Error == nil {
+			t.Errorf("TimeoutAsNXDomain=%v: SERVFAIL lost its error", enabled)
+		}
+		if results["192.0.2.3"].PTR != "host.example.com" {
+			t.Errorf("TimeoutAsNXDomain=%v: PTR = %q", enabled, results["192.0.2.3"].PTR)
+		}
+	}
+}
+
+func TestLookupWorkersDropSelfPTR(t *testing.T) {
+	mock := NewMockResolver()
+	mock.AddResult("192.0.2.1", "192.0.2.1.")
+	mock.AddResult("192.0.2.2", "2.2.0.192.in-addr.arpa.")
+	mock.AddResult("192.0.2.3", "host.example.com.")
+	ips := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"), net.ParseIP("192.0.2.3")}
+
+	cache := NewCache(0)
+	results := make(map[string]LookupResult)
+	for r := range LookupWorkersWith(context.Background(), ips, 2, mock, WorkerOptions{DropSelfPTR: true, Cache: cache}) {
+		results[r.IP.String()] = r
+	}
+	// Self PTRs are NXDOMAIN for everything downstream: filters, counts,
+	// and --min-resolved-pct
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+		if r := results[ip]; r.PTR != "" || r.Error != nil {
+			t.Errorf("%s = %+v, want NXDOMAIN", ip, r)
+		}
+	}
+	if got := results["192.0.2.3"].PTR; got != "host.example.com" {
+		t.Errorf("192.0.2.3 PTR = %q, want host.example.com", got)
+	}
+	counts := CountResults(slices.Collect(maps.Values(results)))
+	if counts.Resolved != 1 || counts.NXDomain != 2 {
+		t.Errorf("counts = %+v, want 1 resolved and 2 NXDOMAIN", counts)
+	}
+
+	// The cache keeps what DNS said, and a cached self PTR is dropped too
+	if ptr, ok := cache.Get(net.ParseIP("192.0.2.1")); !ok || ptr != "192.0.2.1" {
+		t.Errorf("cache 192.0.2.1 = %q, %v, want the self PTR", ptr, ok)
+	}
+	for r := range LookupWorkersWith(context.Background(), ips[:1], 1, &countingResolver{}, WorkerOptions{DropSelfPTR: true, Cache: cache}) {
+		if r.PTR != "" {
+			t.Errorf("cached self PTR = %q, want NXDOMAIN", r.PTR)
+		}
+	}
+}
+
+// TestDefaultResolverLoopback checks that the system resolver path answers
+// loopback from /etc/hosts rather than asking DNS.
+func TestDefaultResolverLoopback(t *testing.T) {
+	data, err := os.ReadFile("/etc/hosts")
+	if err != nil {
+		t.Skip("no /etc/hosts")
+	}
+	var names []string
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "127.0.0.1" {
+			names = append(names, fields[1:]...)
+		}
+	}
+	if len(names) == 0 {
+		t.Skip("/etc/hosts does not name 127.0.0.1")
+	}
+
+	r := lookupIP(context.Background(), net.ParseIP("127.0.0.1"), DefaultResolver())
+	if r.Error != nil {
+		t.Fatalf("lookup error: %v", r.Error)
+	}
+	if !slices.Contains(names, r.PTR) {
+		t.Errorf("PTR = %q, want one of the /etc/hosts names %v", r.PTR, names)
+	}
+}
+
+// gatedResolver signals started on each lookup and answers NXDOMAIN once
+// release is closed.
+type gatedResolver struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (g gatedResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	g.started <- struct{}{}
+	<-g.release
+	return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+}
+
+func TestLookupWorkersCappedAtIPs(t *testing.T) {
+	ips, _ := ExpandCIDR("192.0.2.0/31", 0)
+	ips = append(ips, net.ParseIP("192.0.2.9").To4())
+	g := gatedResolver{started: make(chan struct{}, len(ips)), release: make(chan struct{})}
+
+	before := runtime.NumGoroutine()
+	results := LookupWorkers(context.Background(), ips, 10000, g)
+	for range ips {
+		<-g.started
+	}
+	// Three workers, plus the feeder and the closer at most
+	if n := runtime.NumGoroutine() - before; n > len(ips)+2 {
+		t.Errorf("%d goroutines running for %d IPs at concurrency 10000, want at most %d", n, len(ips), len(ips)+2)
+	}
+	close(g.release)
+	count := 0
+	for range results {
+		count++
+	}
+	if count != len(ips) {
+		t.Errorf("got %d results, want %d", count, len(ips))
+	}
+}
+
+// flakyResolver fails the first failures lookups of each IP with SERVFAIL,
+// then answers.
+type flakyResolver struct {
+	*MockResolver
+	failures int
+
+	mu    sync.Mutex
+	tries map[string]int
+}
+
+func (f *flakyResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	f.mu.Lock()
+	f.tries[addr]++
+	n := f.tries[addr]
+	f.mu.Unlock()
+	if n <= f.failures {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: addr}
+	}
+	return f.MockResolver.LookupAddr(ctx, addr)
+}
+
+func TestLookupWorkersRetries(t *testing.T) {
+	ips, _ := ExpandCIDR("192.0.2.0/30", 0)
+	newFlaky := func() *flakyResolver {
+		mock := NewMockResolver()
+		mock.AddResult("192.0.2.1", "host.example.com.")
+		return &flakyResolver{MockResolver: mock, failures: 2, tries: make(map[string]int)}
+	}
+
+	tests := []struct {
+		retries   int
+		wantError bool
+		wantTries int
+	}{
+		{0, true, 1},
+		{1, true, 2},
+		{2, false, 3},
+		{5, false, 3}, // stops once answered, NXDOMAIN included
+	}
+	for _, tt := range tests {
+		flaky := newFlaky()
+		opts := WorkerOptions{Retries: tt.retries, RetryBackoff: time.Millisecond}
+		for r := range LookupWorkersWith(context.Background(), ips, 4, flaky, opts) {
+			if (r.Error != nil) != tt.wantError {
+				t.Errorf("retries %d: %s error = %v, want error %v", tt.retries, r.IP, r.Error, tt.wantError)
+			}
+			if !tt.wantError && r.IP.String() == "192.0.2.1" && r.PTR != "host.example.com" {
+				t.Errorf("retries %d: PTR = %q, want host.example.com", tt.retries, r.PTR)
+			}
+		}
+		for ip, n := range flaky.tries {
+			if n != tt.wantTries {
+				t.Errorf("retries %d: %s queried %d times, want %d", tt.retries, ip, n, tt.wantTries)
+			}
+		}
+	}
+}
+
+func TestRetryDelayJitter(t *testing.T) {
+	const backoff = 100 * time.Millisecond
+	for attempt := 0; attempt < 3; attempt++ {
+		base := backoff << attempt
+		seen := make(map[time.Duration]bool)
+		for i := 0; i < 50; i++ {
+			d := retryDelay(backoff, attempt)
+			if d < base || d >= 2*base {
+				t.Fatalf("attempt %d: delay %v outside [%v, %v)", attempt, d, base, 2*base)
+			}
+			seen[d] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("attempt %d: every delay was the same; want jitter", attempt)
+		}
+	}
+}
+
+func TestRetryDelayCap(t *testing.T) {
+	tests := []struct {
+		backoff time.Duration
+		attempt int
+	}{
+		{DefaultRetryBackoff, 7}, // 32s before the cap
+		{DefaultRetryBackoff, 16},
+		{DefaultRetryBackoff, 100},
+		{time.Hour, 0},
+		{time.Duration(1) << 62, 16}, // The shift overflows
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			d := retryDelay(tt.backoff, tt.attempt)
+			if d < MaxRetryBackoff/2 || d >= MaxRetryBackoff {
+				t.Fatalf("retryDelay(%v, %d) = %v, want in [%v, %v)", tt.backoff, tt.attempt, d, MaxRetryBackoff/2, MaxRetryBackoff)
+			}
+		}
+	}
+}
+
+func TestLocalDialerTimeout(t *testing.T) {
+	if d := localDialer("tcp", nil, 3*time.Second); d.Timeout != 3*time.Second {
+		t.Errorf("Timeout = %v, want 3s", d.Timeout)
+	}
+	if d := localDialer("udp", nil, 0); d.Timeout != 0 {
+		t.Errorf("Timeout = %v, want none", d.Timeout)
+	}
+}
+
+func TestInterfaceAddr(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skipf("cannot list interfaces: %v", err)
+	}
+	loopback := ""
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			loopback = iface.Name
+			break
+		}
+	}
+	if loopback == "" {
+		t.Skip("no loopback interface")
+	}
+
+	ip, err := InterfaceAddr(loopback, "127.0.0.53:53")
+	if err != nil {
+		t.Fatalf("InterfaceAddr(%s) error: %v", loopback, err)
+	}
+	if !ip.IsLoopback() || ip.To4() == nil {
+		t.Errorf("InterfaceAddr(%s) = %v, want an IPv4 loopback address", loopback, ip)
+	}
+
+	if _, err := InterfaceAddr("no-such-iface0", "127.0.0.53:53"); err == nil {
+		t.Error("expected error for unknown interface")
+	}
+}
+
+func TestCheckResolver(t *testing.T) {
+	resolver := NewMockResolver()
+	resolver.AddResult("8.8.8.8", "dns.google.")
+	resolver.AddError("192.0.2.1", errors.New("connection refused"))
+
+	check := CheckResolver(context.Background(), CheckIP, resolver)
+	if !check.OK || check.PTR != "dns.google" || check.Query != "8.8.8.8" {
+		t.Errorf("check = %+v, want OK with dns.google", check)
+	}
+	if check.LatencyMS < 0 {
+		t.Errorf("latency = %v, want >= 0", check.LatencyMS)
+	}
+
+	check = CheckResolver(context.Background(), net.ParseIP("192.0.2.1"), resolver)
+	if check.OK || !strings.Contains(check.Error, "connection refused") {
+		t.Errorf("check = %+v, want failure with the lookup error", check)
+	}
+}
+
+func TestLookupIPDuplicateNames(t *testing.T) {
+	// Some resolvers repeat a name in one answer; only one PTR is kept
+	resolver := NewMockResolver()
+	resolver.AddResult("192.0.2.1", "host.example.com.", "HOST.example.com", "host.example.com.")
+
+	r := lookupIP(context.Background(), net.ParseIP("192.0.2.1"), resolver)
+	if r.Error != nil || r.PTR != "host.example.com" {
+		t.Errorf("lookup = %q, %v; want host.example.com", r.PTR, r.Error)
+	}
+}
@@ -2,8 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math/big"
+	"math/rand/v2"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -13,19 +25,103 @@ import (
 var (
 	version = "dev"
 
-	concurrency  int
-	outputFormat string
-	resolvedOnly bool
-	nxdomainOnly bool
-	sortOutput   bool
-	expandOutput bool
-	maxIPs       uint64
-	dnsServer    string
+	concurrency   int
+	queueSize     int
+	outputFormat  string
+	orderedOutput bool
+	streamText    bool
+	inputFile     string
+	prefer        string
+	resolvedOnly  bool
+	nxdomainOnly  bool
+	hideNXDomain  bool
+	sortOutput    bool
+	sortDesc      bool
+	sortBy        string
+	expandOutput  bool
+	maxIPs        uint64
+	dnsServers    []string
+	perServer     int
+	dohURL        string
+	followCNAME   bool
+	showCNAMEs    bool
+	clientSubnet  string
+	countOnly     bool
+	formatTmpl    string
+	verifyPTRs    bool
+	verifySame    bool
+	searchDomain  string
+	dualStack     bool
+	tagProvider   bool
+	compareServer bool
+	dryRun        bool
+	fromHost      bool
+	mergeFamilies bool
+	maxPTRLength  int
+	inferAfter    int
+	ipv6Expand    bool
+	verbose       bool
+	jsonSchema    string
+	shuffle       bool
+	seed          uint64
+	manifestPath  string
+	metricsFile   string
+	excludes      []string
+	batchSize     int
+	noPreflight   bool
+	recheck       string
+	explain       bool
+	outputFile    string
+	alsoOutput    string
+	bindInterface string
+	checkResolver bool
+	asns          []string
+	asnSource     string
+	jsonCompact   bool
+	perCIDRMax    bool
+	showArpa      bool
+	minPrefix     int
+	mergeEmpty    bool
+	showAuthority bool
+	dnssec        bool
+	matchRatio    bool
+	showKind      bool
+	onlyPatterns  bool
+	debugConsol   bool
+	dialTimeout   time.Duration
+	queryTimeout  time.Duration
+	progressDelay time.Duration
+	mockFile      string
+	cacheFile     string
+	refreshAfter  time.Duration
+	minResolved   float64
+	retries       int
+	showConfig    bool
+	usePager      bool
+	groupPatterns bool
+	usableOnly    bool
+	rangeArgs     bool
+	loopbackNames bool
+	timeoutAsNX   bool
+	spill         bool
+
+	firstHost           bool
+	ipv4Only            bool
+	ipv6Only            bool
+	dropOtherFamily     bool
+	dropSelfPTR         bool
+	aggressiveAggregate bool
+	aggregateThreshold  float64
+
+	// --concurrency is parsed into concurrencySpec, and resolved to a
+	// worker count in concurrency once the number of IPs is known
+	concurrencyFlag string
+	concurrencySpec ConcurrencySpec
 )
 
 func main() {
 	rootCmd := &cobra.Command{
-		Use:   "sr <cidr> [cidr...]",
+		Use:   "sr <cidr|host> [cidr|host...]",
 		Short: "Perform bulk reverse DNS lookups on CIDR ranges",
 		Long: `sr (ShowReverse) performs bulk PTR lookups on IP addresses
 specified in CIDR notation. It uses concurrent lookups for speed.
@@ -39,113 +135,1252 @@ won't have PTR records - ISPs typically can't maintain individual
 records for the vast IPv6 address space.
 
 Large CIDR ranges are automatically truncated to --max-ips addresses,
-allowing you to sample huge ranges like IPv6 /64 without errors.
+allowing you to sample huge ranges like IPv6 /64 without errors. When run in a
+terminal, a note on stderr reports how many addresses were actually queried.
+
+--infer-patterns N speeds up homogeneous ISP ranges: once N consecutive
+IPs in an IPv4 /24 have PTRs following one IP-derived pattern (such as
+"*.static.isp.net"), the rest of that /24 is not queried and is reported
+with the pattern, marked "inferred". Hosts in the block with a different
+PTR, or none, are misreported, so only use it where speed beats accuracy.
+
+Exit status is 0 on success and 1 on an error (bad flags, unreadable input,
+failed preflight). With --min-resolved-pct, a completed scan where too few
+queried IPs resolved exits 2, after writing its output and manifest, so
+monitoring can tell a likely DNS outage from a broken invocation.
 
 Examples:
   sr 8.8.8.0/30                     # Consolidated output (default)
   sr -e 8.8.8.0/30                  # Per-IP output (expanded)
+  sr -e --sort-desc 8.8.8.0/30      # Highest IP first
+  sr -e --sort-by ptr 8.8.8.0/28    # Group each hostname's IPs together
   sr -c 100 192.168.1.0/24
   sr -o json --resolved-only 10.0.0.0/24
   sr 2001:4860:4860::8888/128       # Google DNS IPv6
   sr 2001:db8::/126                 # Small IPv6 range (4 addresses)
+  sr -e --show-arpa 2001:db8::/126  # Show the ip6.arpa nibble names queried
   sr --max-ips 1000000 10.0.0.0/8   # Override default limit
   sr --max-ips 100 2001:db8::/64    # Sample first 100 of huge range
+  sr -i blocks.txt -m 256 --per-cidr-max  # Sample up to 256 IPs of every block
+  sr --dry-run -m 1 10.0.0.0/8      # Show total vs. queried addresses
+  sr --show-config --dry-run -c auto 10.0.0.0/16  # What would actually run
+  sr --progress-delay 0 10.0.0.0/16  # Show progress from the start
+  sr --count --min-resolved-pct 80 10.0.0.0/24 >/dev/null  # Alert on a DNS outage
+  sr --check-resolver -S 1.1.1.1    # Is this DNS setup working? (no targets needed)
+  sr -o table 192.0.2.0/28         # Bordered table for reading in a terminal
+  sr --pager -e 10.0.0.0/20         # Page long output through $PAGER
+  sr -c 25% 192.0.2.0/24            # A worker per four IPs (or -c auto)
   sr --server 8.8.8.8 10.0.0.0/24  # Use specific DNS server
-  sr -S 1.1.1.1 192.168.1.0/24     # Short form`,
-		Args: cobra.MinimumNArgs(1),
+  sr --query-timeout 30s --dial-timeout 1s 10.0.0.0/24  # Slow answers, fast connects
+  sr --retries 2 -S 10.0.0.53 10.0.0.0/16  # Ride out a flaky resolver
+  sr -S 10.0.0.53,10.0.1.53 --concurrency-per-server 10 10.0.0.0/16  # Spread load, politely
+  sr --doh https://cloudflare-dns.com/dns-query 10.0.0.0/24  # Query over DNS-over-HTTPS
+  sr --cache-file ptrs.json --refresh-older-than 24h 10.0.0.0/16  # Requery only stale IPs
+  sr --mock-file ptrs.txt 192.0.2.0/24  # Offline, from a fixture of "IP PTR" lines
+  sr -S 1.1.1.1 192.168.1.0/24     # Short form
+  sr -S 10.1.0.53 --interface eth1 10.0.0.0/24  # Query out of a specific interface
+  sr -S 127.0.0.1:5353 --no-preflight 10.0.0.0/30  # Skip the up-front reachability check
+  sr -e -o json --output-file ips.json --also-output summary.json 10.0.0.0/24  # Both views, one scan
+  sr -e --format-template '{{.IP}},{{.PTR}},{{.Status}}' 10.0.0.0/30
+  sr -o binary 10.0.0.0/8 > ptrs.bin  # Compact per-IP records for huge scans
+  sr -e -o names 10.0.0.0/24        # Distinct hostnames, one per line (patterns without -e)
+  sr -o ndjson --ordered 10.0.0.0/24  # Stream results in input order
+  sr -o json --json-compact 10.0.0.0/24 > ptrs.json  # Unindented JSON
+  sr -e --stream 10.0.0.0/16        # Print results as they complete
+  sr -o ndjson --batch-size 500 10.0.0.0/16 | nc host 9000  # Flush in chunks
+  sr -i targets.txt                 # Read CIDRs/IPs from a file ("-" for stdin)
+  sr -e 8.8.8.8.in-addr.arpa        # Reverse names (in-addr.arpa, ip6.arpa) work as targets
+  sr -e -o json --recheck errors=run1.json  # Retry what failed last time
+  sr --shuffle --seed 42 10.0.0.0/16  # Query in a reproducible random order
+  sr --manifest run.json -o json 10.0.0.0/24 > out.json  # Record how the scan ran
+  sr --metrics-file /var/lib/node_exporter/sr.prom 10.0.0.0/24  # For the textfile collector
+  sr --verify 192.0.2.0/24          # Forward-confirm PTRs (FCrDNS)
+  sr --follow-cname 192.0.2.128/26  # Classless (RFC 2317) delegation
+  sr -e --show-authority -S ns1.example.net 192.0.2.0/24  # Audit authoritative answers
+  sr -e --dnssec -S 1.1.1.1 192.0.2.0/24  # Is the reverse zone signed? (AD needs a validating resolver)
+  sr -S 8.8.8.8 --client-subnet 198.51.100.0/24 192.0.2.0/24  # EDNS Client Subnet
+  sr --exclude 10.1.0.0/16 10.0.0.0/8  # Everything except some blocks
+  sr --asn AS15169 -m 100000        # Sweep an ASN's announced prefixes
+  sr --first-host 8.8.8.0/24 1.1.1.0/24  # Quick ownership overview
+  sr --usable-only 192.0.2.0/24 2001:db8::/120  # Skip network/broadcast/anycast addresses
+  sr --range 10.0.0.1 10.0.0.50     # Every address from the first to the last
+  sr -e -S 1.1.1.1 --loopback-names 127.0.0.0/30  # Name loopback even via a public resolver
+  sr -r --timeout-as-nxdomain 10.0.0.0/16  # Only positives matter; timeouts count as no PTR
+  sr --from-host -e www.example.com # PTRs of a service's addresses
+  sr --tag-provider 52.0.0.0/28     # Guess hosting provider from PTRs
+  sr -4 --drop-other-family $RANGES  # Scan only the IPv4 inputs
+  sr -e -S 1.1.1.1 --compare-server 192.0.2.0/28  # Flag split-horizon differences
+  sr --aggressive-aggregate 10.0.0.0/24  # Absorb NXDOMAIN gaps into supernets
+  sr --explain 64.147.100.0/28      # Show the IPs and PTRs behind each *.pattern
+  sr --debug-consolidation 64.147.100.0/28 2>debug.log  # Why IPs did or didn't group
+  sr --show-kind 64.147.100.0/24    # Tell literal shared PTRs from *.patterns
+  sr --only-patterns 64.147.100.0/22  # Just the blocks named from a template
+  sr --match-ratio 64.147.100.0/24  # How much of each *.pattern really matched
+  sr --merge-families 192.0.2.0/28 2001:db8::/124  # One line per pattern, both families
+  sr -o json --group-patterns 192.0.2.0/24  # One JSON object per PTR with all its networks
+  sr --min-prefix 24 10.0.0.0/16    # Never consolidate beyond /24 (for ACLs)
+  sr -o domains 198.51.100.0/22     # IPs per registered domain (ownership breakdown)
+  sr --merge-empty 10.0.0.0/22      # One "NO DATA" range per gap, errors included`,
+		Args: cobra.ArbitraryArgs,
 		RunE: run,
 	}
 
 	rootCmd.Version = version
 
-	rootCmd.Flags().IntVarP(&concurrency, "concurrency", "c", 50, "Number of concurrent lookups")
-	rootCmd.Flags().StringVarP(&outputFormat, "output", "o", "text", "Output format: text, json")
+	rootCmd.Flags().StringVarP(&concurrencyFlag, "concurrency", "c", strconv.Itoa(DefaultConcurrency), "Number of concurrent lookups, a percentage of the IPs queried (e.g. 50%), or auto; never more than the IPs queried")
+	rootCmd.Flags().BoolVar(&followCNAME, "follow-cname", false, "Use the built-in DNS client, which re-queries CNAME targets (RFC 2317 delegations)")
+	rootCmd.Flags().BoolVar(&showCNAMEs, "show-cname-chain", false, "Show the CNAME chain behind each PTR (implies --follow-cname, requires --expand)")
+	rootCmd.Flags().BoolVar(&showAuthority, "show-authority", false, "Show whether each PTR came from an authoritative answer (built-in DNS client; requires --expand)")
+	rootCmd.Flags().BoolVar(&dnssec, "dnssec", false, "Set the DO bit and show whether each PTR answer was DNSSEC-signed and validated (AD) (built-in DNS client; requires --expand)")
+	rootCmd.Flags().StringVar(&clientSubnet, "client-subnet", "", "Send this CIDR as EDNS Client Subnet (built-in DNS client only; implies --follow-cname)")
+	rootCmd.Flags().BoolVar(&shuffle, "shuffle", false, "Query IPs in random order to spread load across authoritative servers")
+	rootCmd.Flags().Uint64Var(&seed, "seed", 0, "Seed for --shuffle, for a reproducible order (default: random)")
+	rootCmd.Flags().IntVar(&queueSize, "queue-size", 0, "Worker queue buffer size (default: 2x concurrency)")
+	rootCmd.Flags().StringVarP(&outputFormat, "output", "o", "text", "Output format: text, json, ndjson (streamed, one result per line), table (bordered), domains (IP counts per registered domain), binary (compact per-IP records for pipelines), names (distinct PTR names or patterns, sorted)")
+	rootCmd.Flags().BoolVar(&usePager, "pager", false, "Page output through $PAGER (default less) when stdout is a terminal")
+	rootCmd.Flags().StringVar(&outputFile, "output-file", "", "Write output to this file instead of stdout")
+	rootCmd.Flags().StringVar(&alsoOutput, "also-output", "", "Also write the other view to this file (consolidated with --expand, per-IP without; \"-\" for stdout)")
+	rootCmd.Flags().BoolVar(&jsonCompact, "json-compact", false, "Write JSON output on a single line instead of indented (with --output json)")
+	rootCmd.Flags().StringVar(&jsonSchema, "json-schema", "default", "JSON field layout: default, or flat (\"ip\" plus \"prefix_length\" instead of \"network\")")
+	rootCmd.Flags().BoolVar(&orderedOutput, "ordered", false, "Stream results in input order (with --output ndjson or --stream)")
+	rootCmd.Flags().BoolVar(&streamText, "stream", false, "Print expanded text results as they complete, tab-separated (requires --expand)")
+	rootCmd.Flags().IntVar(&batchSize, "batch-size", 0, "Buffer streamed output and flush it every N results (with --output ndjson or --stream; 0 = flush each result)")
+	rootCmd.Flags().IntVar(&maxPTRLength, "max-ptr-length", 0, "Truncate PTRs longer than this in text output (0 = no limit; JSON keeps full names)")
+	rootCmd.Flags().BoolVar(&mergeEmpty, "merge-empty", false, "Merge adjacent NXDOMAIN and error blocks into single \"NO DATA\" ranges in consolidated output")
+	rootCmd.Flags().IntVar(&minPrefix, "min-prefix", 0, "Split consolidated networks shorter than this prefix length, e.g. 24 (0 = no limit)")
+	rootCmd.Flags().BoolVar(&showArpa, "show-arpa", false, "Show the in-addr.arpa or ip6.arpa (nibble) name queried for each IP (requires --expand)")
+	rootCmd.Flags().BoolVar(&ipv6Expand, "ipv6-expand", false, "Write IPv6 addresses fully expanded (2001:0db8:0000:...) in text and JSON output")
+	rootCmd.Flags().StringVar(&formatTmpl, "format-template", "", "Go text/template for each output line, e.g. '{{.IP}},{{.PTR}}'")
 	rootCmd.Flags().BoolVarP(&resolvedOnly, "resolved-only", "r", false, "Only show IPs with PTR records")
 	rootCmd.Flags().BoolVarP(&nxdomainOnly, "nxdomain-only", "n", false, "Only show IPs without PTR records")
+	rootCmd.Flags().BoolVar(&hideNXDomain, "hide-nxdomain", false, "Hide NXDOMAIN entries but keep errors")
 	rootCmd.Flags().BoolVarP(&sortOutput, "sort", "s", false, "Sort output by IP address (only with --expand)")
+	rootCmd.Flags().StringVar(&sortBy, "sort-by", "ip", "Sort expanded output by ip, or by ptr to group each hostname's IPs (NXDOMAIN, then errors, last); implies --sort")
+	rootCmd.Flags().BoolVar(&sortDesc, "sort-desc", false, "Order output from the highest IP address down (expanded and consolidated)")
 	rootCmd.Flags().BoolVarP(&expandOutput, "expand", "e", false, "Show per-IP output instead of consolidated CIDRs")
+	rootCmd.Flags().StringVarP(&inputFile, "input-file", "i", "", "Read CIDRs or IPs from a file, one per line (\"-\" for stdin; # comments allowed)")
+	rootCmd.Flags().StringSliceVar(&asns, "asn", nil, "Scan the prefixes announced by these ASNs, e.g. AS15169 (repeatable; --max-ips applies across all of them)")
+	rootCmd.Flags().StringVar(&asnSource, "asn-source", DefaultASNSource, "RIPEstat-compatible announced-prefixes endpoint used by --asn")
+	rootCmd.Flags().StringVar(&recheck, "recheck", "", "Scan only the resolved or errored IPs of a prior JSON/NDJSON run, as resolved=FILE or errors=FILE")
 	rootCmd.Flags().Uint64VarP(&maxIPs, "max-ips", "m", 65536, "Maximum IPs to process (large ranges truncated to this)")
-	rootCmd.Flags().StringVarP(&dnsServer, "server", "S", "", "DNS server to use (default: system resolver)")
+	rootCmd.Flags().BoolVar(&perCIDRMax, "per-cidr-max", false, "Apply --max-ips to each CIDR separately instead of across all of them")
+	rootCmd.Flags().StringSliceVarP(&dnsServers, "server", "S", nil, "DNS server to use; repeat or comma-separate to spread queries round-robin (default: $SR_DNS_SERVER, else the system resolver)")
+	rootCmd.Flags().DurationVar(&dialTimeout, "dial-timeout", DefaultDialTimeout, "Give up connecting to the resolver after this long (TCP and DoH; 0 = no limit)")
+	rootCmd.Flags().DurationVar(&queryTimeout, "query-timeout", DefaultQueryTimeout, "Give up on one IP's lookup attempt after this long (0 = resolver default; each --retries attempt gets its own)")
+	rootCmd.Flags().BoolVar(&showConfig, "show-config", false, "Print the settings in effect (resolver, concurrency, timeouts, output, filters) to stderr before scanning")
+	rootCmd.Flags().IntVar(&retries, "retries", 0, "Re-query an IP whose lookup failed (not NXDOMAIN) up to this many times, with jittered exponential backoff (each wait under 30s)")
+	rootCmd.Flags().DurationVar(&progressDelay, "progress-delay", DefaultProgressDelay, "Wait this long before showing the progress line on a terminal (0 = from the start)")
+	rootCmd.Flags().IntVar(&perServer, "concurrency-per-server", 0, "Cap in-flight queries to each --server (0 = no cap; --concurrency still bounds the total)")
+	rootCmd.Flags().StringVar(&dohURL, "doh", "", "Send queries to this DNS-over-HTTPS URL, sharing HTTP/2 connections across --concurrency workers")
+	rootCmd.Flags().StringVar(&bindInterface, "interface", "", "Send queries from this network interface's address (e.g. eth1)")
+	rootCmd.Flags().Float64Var(&minResolved, "min-resolved-pct", 0, "Exit with status 2 if fewer than this percentage of queried IPs resolved (0 = off)")
+	rootCmd.Flags().StringVar(&mockFile, "mock-file", "", "Answer from this fixture of \"IP PTR\" lines instead of DNS, for offline demos and tests (unlisted IPs are NXDOMAIN)")
+	rootCmd.Flags().BoolVar(&noPreflight, "no-preflight", false, "Skip the single test query sent to --server or --doh before scanning")
+	rootCmd.Flags().BoolVar(&compareServer, "compare-server", false, "Also query the system resolver and flag PTRs that differ from --server (doubles queries, requires --expand)")
+	rootCmd.Flags().StringVar(&manifestPath, "manifest", "", "Write a JSON manifest of the run (version, arguments, flags, resolver, timing) to this file")
+	rootCmd.Flags().StringVar(&cacheFile, "cache-file", "", "Reuse the PTRs cached in this file instead of querying those IPs, and add the answers of the rest (created if missing)")
+	rootCmd.Flags().DurationVar(&refreshAfter, "refresh-older-than", 0, "With --cache-file, re-query IPs whose cached answer is older than this, e.g. 24h (0 = reuse any cached answer)")
+	rootCmd.Flags().StringVar(&metricsFile, "metrics-file", "", "After the run, write Prometheus counters (lookups, resolved, NXDOMAIN, errors, duration) to this file")
+	rootCmd.Flags().BoolVar(&verbose, "verbose", false, "On stderr, name the targets before scanning, periodically list the slowest in-flight lookups, flag blocks that look like wildcard zones, and give the elapsed time at the end")
+	rootCmd.Flags().BoolVar(&checkResolver, "check-resolver", false, "Send one PTR query for 8.8.8.8 through the configured resolver, report the answer and latency, and exit")
+	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report how many addresses the input covers and would be queried, without looking anything up (to stdout; --output-file is not touched)")
+	rootCmd.Flags().BoolVar(&countOnly, "count", false, "Only print totals of resolved, NXDOMAIN, and errored IPs")
+	rootCmd.Flags().BoolVarP(&ipv4Only, "ipv4-only", "4", false, "Reject IPv6 CIDRs")
+	rootCmd.Flags().BoolVarP(&ipv6Only, "ipv6-only", "6", false, "Reject IPv4 CIDRs")
+	rootCmd.Flags().BoolVar(&dropOtherFamily, "drop-other-family", false, "With --ipv4-only/--ipv6-only, skip CIDRs of the other family instead of failing")
+	rootCmd.Flags().BoolVar(&fromHost, "from-host", false, "Treat arguments as hostnames and look up the PTRs of their A/AAAA addresses")
+	rootCmd.Flags().StringSliceVar(&excludes, "exclude", nil, "Skip these CIDRs or IPs (repeatable or comma-separated)")
+	rootCmd.Flags().BoolVar(&firstHost, "first-host", false, "Only look up the first usable host of each CIDR")
+	rootCmd.Flags().BoolVar(&spill, "spill", false, "Keep results in a temporary file instead of memory, for very large scans; per-IP output (text with --expand, or ndjson) is written in input order at the end")
+	rootCmd.Flags().BoolVar(&timeoutAsNX, "timeout-as-nxdomain", false, "Report lookups that time out (after --retries) as NXDOMAIN instead of errors; slow servers then hide PTRs that exist")
+	rootCmd.Flags().BoolVar(&loopbackNames, "loopback-names", false, "Report 127.0.0.1 and ::1 as localhost and ip6-localhost when they have no PTR record")
+	rootCmd.Flags().BoolVar(&rangeArgs, "range", false, "Take the two arguments as the first and last address of a range to scan, inclusive")
+	rootCmd.Flags().BoolVar(&usableOnly, "usable-only", false, "Skip network and broadcast addresses, IPv6 subnet-router anycast addresses, and ::")
+	rootCmd.Flags().BoolVar(&verifyPTRs, "verify", false, "Forward-confirm each PTR, through the system resolver, and report verified counts")
+	rootCmd.Flags().BoolVar(&verifySame, "verify-same-server", false, "Send --verify forward lookups to --server or --doh instead of the system resolver")
+	rootCmd.Flags().StringVar(&searchDomain, "search-domain", "", "Domain appended to relative PTR names during --verify")
+	rootCmd.Flags().BoolVar(&dualStack, "dual-stack", false, "Report which address families (A/AAAA) each PTR name resolves in (requires --expand)")
+	rootCmd.Flags().BoolVar(&tagProvider, "tag-provider", false, "Tag results with the hosting provider guessed from the PTR suffix")
+	rootCmd.Flags().BoolVar(&dropSelfPTR, "drop-self-ptr", false, "Treat PTRs that just echo the IP or its arpa name as NXDOMAIN")
+	rootCmd.Flags().StringVar(&prefer, "prefer", "pattern", "Consolidation precedence: pattern (fold IP-templated PTRs into *.suffix) or exact (keep concrete PTRs)")
+	rootCmd.Flags().BoolVar(&showKind, "show-kind", false, "Mark each consolidated entry's PTR as exact (shared literally) or pattern (IP-templated names folded into *.suffix); JSON always has \"kind\"")
+	rootCmd.Flags().BoolVar(&onlyPatterns, "only-patterns", false, "Show only consolidated *.pattern entries, dropping exact PTRs, NXDOMAIN, and errors")
+	rootCmd.Flags().BoolVar(&matchRatio, "match-ratio", false, "Show how many addresses of each consolidated *.pattern entry had a PTR matching it")
+	rootCmd.Flags().BoolVar(&debugConsol, "debug-consolidation", false, "Log to stderr each group consolidation forms, and which single IPs matched a *.pattern and which did not")
+	rootCmd.Flags().BoolVar(&explain, "explain", false, "List the member IPs and original PTRs under each consolidated *.pattern entry")
+	rootCmd.Flags().BoolVar(&groupPatterns, "group-patterns", false, "In consolidated JSON, list each PTR or pattern once with all of its networks")
+	rootCmd.Flags().BoolVar(&mergeFamilies, "merge-families", false, "Merge consolidated entries sharing a PTR pattern across IPv4 and IPv6")
+	rootCmd.Flags().IntVar(&inferAfter, "infer-patterns", 0, "Stop querying a /24 after this many consecutive IPs share a PTR pattern and infer the rest (0 = off; less accurate)")
+	rootCmd.Flags().BoolVar(&aggressiveAggregate, "aggressive-aggregate", false, "Merge mostly-homogeneous blocks into supernets despite NXDOMAIN gaps")
+	rootCmd.Flags().Float64Var(&aggregateThreshold, "aggregate-threshold", 0.9, "Fraction of a supernet that must share a PTR for --aggressive-aggregate")
+
+	// Turn a closed stdout pipe into an EPIPE error for run to handle,
+	// instead of the runtime killing the process with SIGPIPE
+	signal.Ignore(syscall.SIGPIPE)
 
 	if err := rootCmd.Execute(); err != nil {
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
 		os.Exit(1)
 	}
 }
 
+// exitBelowMinResolved is the exit status of a scan that completed with
+// fewer resolved IPs than --min-resolved-pct.
+const exitBelowMinResolved = 2
+
+// exitCodeError is an error that exits with a status other than 1.
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string { return e.err.Error() }
+
+// scanTally is what run records of a completed scan.
+type scanTally struct {
+	scanned  bool   // False for --check-resolver, which looks nothing up
+	total    int    // Results, inferred ones included
+	queried  Counts // Results that were looked up (see CountQueried)
+	inferred int
+}
+
+// tallyResults tallies the results of a completed scan.
+func tallyResults(results []LookupResult) scanTally {
+	t := scanTally{scanned: true}
+	for _, r := range results {
+		t.add(r)
+	}
+	return t
+}
+
+// add tallies one result.
+func (t *scanTally) add(r LookupResult) {
+	t.total++
+	if r.Inferred {
+		t.inferred++
+	} else {
+		t.queried.add(r)
+	}
+}
+
+// checkMinResolved returns an exitCodeError if fewer than --min-resolved-pct
+// of the queried IPs resolved. Inferred results were not queried and are
+// left out.
+func checkMinResolved(t scanTally) error {
+	if minResolved <= 0 {
+		return nil
+	}
+	c := t.queried
+	queried := c.Total
+	var pct float64
+	if queried > 0 {
+		pct = 100 * float64(c.Resolved) / float64(queried)
+	}
+	if pct >= minResolved {
+		return nil
+	}
+	return &exitCodeError{
+		code: exitBelowMinResolved,
+		err:  fmt.Errorf("%.1f%% of %d queried IPs resolved, below --min-resolved-pct %g", pct, queried, minResolved),
+	}
+}
+
+// newResolver builds the resolver selected by the flags. Features that need
+// the raw DNS answer use the built-in DNSClient, which queries --server or
+// the first system nameserver. Several servers, or a per-server cap, are
+// served through a ServerPool. --mock-file replaces DNS altogether.
+func newResolver() (Resolver, error) {
+	if mockFile != "" {
+		return LoadFileResolver(mockFile)
+	}
+	if dohURL != "" {
+		client, err := NewDoHClient(dohURL, concurrencySpec.Max(), dialTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if clientSubnet != "" {
+			_, subnet, err := net.ParseCIDR(clientSubnet)
+			if err != nil {
+				return nil, fmt.Errorf("invalid client subnet %q: %w", clientSubnet, err)
+			}
+			client.ClientSubnet = subnet
+		}
+		client.DNSSEC = dnssec
+		return client, nil
+	}
+
+	servers := dnsServers
+	if len(servers) == 0 {
+		if bindInterface == "" && !useDNSClient() {
+			return DefaultResolver(), nil
+		}
+		// Binding to an interface and the built-in client both need a
+		// known server
+		servers = []string{systemNameserver()}
+	}
+
+	resolvers := make([]Resolver, len(servers))
+	for i, server := range servers {
+		r, err := newServerResolver(server)
+		if err != nil {
+			return nil, err
+		}
+		resolvers[i] = r
+	}
+	if len(resolvers) == 1 && perServer == 0 {
+		return resolvers[0], nil
+	}
+	return NewServerPool(resolvers, perServer), nil
+}
+
+// useDNSClient reports whether a flag needs the raw DNS answer, which only
+// the built-in DNSClient provides.
+func useDNSClient() bool {
+	return followCNAME || showCNAMEs || showAuthority || dnssec || clientSubnet != ""
+}
+
+// newServerResolver builds the resolver for one server: the built-in
+// DNSClient if a feature needs the raw answer, else a net.Resolver, either
+// bound to --interface if set.
+func newServerResolver(server string) (Resolver, error) {
+	var local net.IP
+	if bindInterface != "" {
+		// The interface's address must match the server's family
+		addr, err := normalizeServer(server)
+		if err != nil {
+			return nil, err
+		}
+		if local, err = InterfaceAddr(bindInterface, addr); err != nil {
+			return nil, err
+		}
+	}
+
+	if useDNSClient() {
+		client, err := NewDNSClient(server)
+		if err != nil {
+			return nil, err
+		}
+		client.LocalAddr = local
+		client.DialTimeout = dialTimeout
+		if clientSubnet != "" {
+			_, subnet, err := net.ParseCIDR(clientSubnet)
+			if err != nil {
+				return nil, fmt.Errorf("invalid client subnet %q: %w", clientSubnet, err)
+			}
+			client.ClientSubnet = subnet
+		}
+		client.DNSSEC = dnssec
+		return client, nil
+	}
+	return BoundResolver(server, local, dialTimeout)
+}
+
+// readInputFile reads targets from path, or from stdin if path is "-".
+func readInputFile(path string) ([]string, error) {
+	if path == "-" {
+		return ReadTargets(os.Stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	targets, err := ReadTargets(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return targets, nil
+}
+
+// nopCloser is a WriteCloser for stdout, which must stay open.
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// createOutput creates the file at path for output, or returns stdout if path
+// is empty or "-".
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == "" || path == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(path)
+}
+
+// readRecheckFile reads the prior results in path, or stdin if path is "-",
+// and returns the targets with the given status.
+func readRecheckFile(path, status string) ([]string, error) {
+	if path == "-" {
+		return ReadRecheckTargets(os.Stdin, status)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	targets, err := ReadRecheckTargets(f, status)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return targets, nil
+}
+
+// serverEnv names the environment variable giving the default --server.
+const serverEnv = "SR_DNS_SERVER"
+
+// applyServerEnv sets the servers from $SR_DNS_SERVER unless --server was
+// given or --doh or --mock-file chose another resolver. As with --server,
+// several servers may be separated by commas.
+func applyServerEnv(cmd *cobra.Command) error {
+	env := strings.TrimSpace(os.Getenv(serverEnv))
+	if env == "" || cmd.Flags().Changed("server") || dohURL != "" || mockFile != "" {
+		return nil
+	}
+	servers := strings.Split(env, ",")
+	for i, server := range servers {
+		server = strings.TrimSpace(server)
+		if _, err := normalizeServer(server); err != nil {
+			return fmt.Errorf("$%s: %w", serverEnv, err)
+		}
+		servers[i] = server
+	}
+	dnsServers = servers
+	return nil
+}
+
+// resolverDescription names the resolver newResolver selects, for the
+// manifest.
+func resolverDescription() string {
+	if mockFile != "" {
+		return "mock " + mockFile
+	}
+	if dohURL != "" {
+		return "doh " + dohURL
+	}
+	if useDNSClient() {
+		servers := dnsServers
+		if len(servers) == 0 {
+			servers = []string{systemNameserver()}
+		}
+		return "dns-client " + strings.Join(servers, ",")
+	}
+	if len(dnsServers) > 0 {
+		return "server " + strings.Join(dnsServers, ",")
+	}
+	if bindInterface != "" {
+		return "server " + systemNameserver()
+	}
+	return "system"
+}
+
+// writeEffectiveConfig lists the settings a scan runs with once defaults
+// and derived values (such as --concurrency auto) are resolved, followed by
+// the flags set on the command line.
+func writeEffectiveConfig(w io.Writer, cmd *cobra.Command, plan *ScanPlan) {
+	view := "consolidated"
+	if expandOutput {
+		view = "expanded"
+	}
+	var filters []string
+	for _, f := range []struct {
+		name string
+		on   bool
+	}{
+		{"resolved-only", resolvedOnly},
+		{"nxdomain-only", nxdomainOnly},
+		{"hide-nxdomain", hideNXDomain},
+		{"drop-self-ptr", dropSelfPTR},
+	} {
+		if f.on {
+			filters = append(filters, f.name)
+		}
+	}
+	family := "any"
+	if ipv4Only {
+		family = "ipv4"
+	} else if ipv6Only {
+		family = "ipv6"
+	}
+	flags := changedFlags(cmd.Flags())
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = "--" + name + "=" + flags[name]
+	}
+
+	settings := [][2]string{
+		{"resolver", resolverDescription()},
+		{"concurrency", strconv.Itoa(concurrency)},
+		{"max-ips", maxIPsSetting()},
+		{"addresses", fmt.Sprintf("%d of %s", len(plan.IPs), plan.Total)},
+		{"dial-timeout", dialTimeout.String()},
+		{"query-timeout", queryTimeout.String()},
+		{"retries", strconv.Itoa(retries)},
+		{"family", family},
+		{"output", outputFormat + ", " + view},
+		{"filters", strings.Join(filters, ", ")},
+		{"exclude", strings.Join(excludes, ", ")},
+		{"flags", strings.Join(names, " ")},
+	}
+	for _, s := range settings {
+		if s[1] == "" {
+			s[1] = "none"
+		}
+		fmt.Fprintf(w, "%-14s %s\n", s[0], s[1])
+	}
+}
+
+// maxIPsSetting describes --max-ips as applied: across all targets, or
+// per CIDR with --per-cidr-max.
+func maxIPsSetting() string {
+	s := strconv.FormatUint(maxIPs, 10)
+	if perCIDRMax {
+		s += " per CIDR"
+	}
+	return s
+}
+
+// maxHeaderTargets is how many targets the --verbose header lists before
+// summarizing the rest, so an --asn or --input-file scan stays one line.
+const maxHeaderTargets = 8
+
+// writeScanHeader writes the --verbose line naming what is about to be
+// scanned, so a log of a long session shows what each run was.
+func writeScanHeader(w io.Writer, targets []string, queried int) {
+	list := strings.Join(targets, " ")
+	if len(targets) > maxHeaderTargets {
+		list = fmt.Sprintf("%s and %d more", strings.Join(targets[:maxHeaderTargets], " "), len(targets)-maxHeaderTargets)
+	}
+	fmt.Fprintf(w, "scanning %s (%d addresses, max-ips %s, concurrency %d)\n", list, queried, maxIPsSetting(), min(concurrency, queried))
+}
+
+// writeWildcards notes, for --verbose, each target block whose addresses
+// nearly all share one PTR.
+func writeWildcards(w io.Writer, results []LookupResult, sources []string) {
+	for _, wc := range DetectWildcards(results, sources) {
+		fmt.Fprintf(w, "warning: %s: %d of %d addresses share the PTR %q; the zone probably has a wildcard record\n", wc.Block, wc.Count, wc.Queried, wc.PTR)
+	}
+}
+
+// run scans the targets and, with --manifest and --metrics-file, records
+// the run once it has completed successfully. If the reader of the output
+// goes away (as with "sr ... | head"), it stops quietly with success and
+// writes neither. A scan below --min-resolved-pct has completed: it is
+// recorded, then exits with status 2.
 func run(cmd *cobra.Command, args []string) error {
+	start := time.Now()
+	var tally scanTally
+	err := scan(cmd, args, &tally)
+	var exitErr *exitCodeError
+	if errors.As(err, &exitErr) {
+		// Not a usage problem; don't print the flags
+		cmd.SilenceUsage = true
+	} else if err != nil {
+		if errors.Is(err, syscall.EPIPE) {
+			return nil
+		}
+		return err
+	}
+	end := time.Now()
+	if verbose && tally.scanned {
+		fmt.Fprintf(os.Stderr, "done: %d addresses in %s\n", tally.total, end.Sub(start).Round(time.Millisecond))
+	}
+	if manifestPath != "" {
+		if werr := WriteManifest(manifestPath, Manifest{
+			Version:         version,
+			Command:         os.Args,
+			Targets:         args,
+			Flags:           changedFlags(cmd.Flags()),
+			Resolver:        resolverDescription(),
+			Start:           start,
+			End:             end,
+			DurationSeconds: end.Sub(start).Seconds(),
+		}); werr != nil {
+			return werr
+		}
+	}
+	if metricsFile != "" && tally.scanned {
+		if werr := WriteMetricsFile(metricsFile, tally.queried, tally.inferred, end.Sub(start)); werr != nil {
+			return werr
+		}
+	}
+	return err
+}
+
+// scan validates the flags, looks up the targets, and writes the output.
+// The lookup results are tallied in *tally for run.
+func scan(cmd *cobra.Command, args []string, tally *scanTally) error {
+	if err := applyServerEnv(cmd); err != nil {
+		return err
+	}
+
 	// Validate flags
 	if resolvedOnly && nxdomainOnly {
 		return fmt.Errorf("--resolved-only and --nxdomain-only are mutually exclusive")
 	}
 
-	if outputFormat != "text" && outputFormat != "json" {
-		return fmt.Errorf("invalid output format %q: must be text or json", outputFormat)
+	if hideNXDomain && nxdomainOnly {
+		return fmt.Errorf("--hide-nxdomain and --nxdomain-only are mutually exclusive")
 	}
 
-	if concurrency < 1 {
-		return fmt.Errorf("concurrency must be at least 1")
+	if hideNXDomain && resolvedOnly {
+		return fmt.Errorf("--hide-nxdomain and --resolved-only are mutually exclusive")
 	}
 
-	// Parse CIDR blocks
-	ips, err := ParseCIDRs(args, maxIPs)
-	if err != nil {
+	if err := validateOutputFormat(outputFormat); err != nil {
 		return err
 	}
 
-	if len(ips) == 0 {
-		return fmt.Errorf("no IP addresses in specified CIDR blocks")
+	if outputFormat == "binary" && (outputFile == "" || outputFile == "-") && term.IsTerminal(int(os.Stdout.Fd())) {
+		return fmt.Errorf("--output binary is not for a terminal: redirect it or use --output-file")
 	}
 
-	// Perform lookups
-	ctx := context.Background()
-	var resolver Resolver
-	if dnsServer != "" {
+	if outputFormat == "domains" && (expandOutput || countOnly || alsoOutput != "") {
+		return fmt.Errorf("--output domains is a summary and cannot be combined with --expand, --count, or --also-output")
+	}
+
+	if jsonSchema != "default" && jsonSchema != "flat" {
+		return fmt.Errorf("invalid JSON schema %q: must be default or flat", jsonSchema)
+	}
+
+	if jsonSchema == "flat" && outputFormat != "json" && outputFormat != "ndjson" {
+		return fmt.Errorf("--json-schema flat requires --output json or ndjson")
+	}
+
+	if jsonCompact && outputFormat != "json" {
+		return fmt.Errorf("--json-compact requires --output json (ndjson is always compact)")
+	}
+
+	if streamText && (outputFormat != "text" || !expandOutput) {
+		return fmt.Errorf("--stream requires --expand and text output (use --output ndjson to stream JSON)")
+	}
+
+	if streamText && (sortOutput || formatTmpl != "") {
+		return fmt.Errorf("--stream cannot be combined with --sort or --format-template")
+	}
+
+	if sortBy != "ip" && sortBy != "ptr" {
+		return fmt.Errorf("invalid --sort-by %q: must be ip or ptr", sortBy)
+	}
+	sortByChanged := cmd.Flags().Changed("sort-by")
+
+	if sortByChanged && (outputFormat == "ndjson" || streamText) {
+		return fmt.Errorf("--sort-by cannot be combined with --output ndjson or --stream, which write results as they complete")
+	}
+
+	if sortBy == "ptr" && !expandOutput {
+		return fmt.Errorf("--sort-by ptr requires --expand")
+	}
+
+	if sortBy == "ptr" && sortDesc {
+		return fmt.Errorf("--sort-by ptr and --sort-desc are mutually exclusive")
+	}
+
+	if sortDesc && (outputFormat == "ndjson" || streamText) {
+		return fmt.Errorf("--sort-desc cannot be combined with --output ndjson or --stream, which write results as they complete")
+	}
+
+	if orderedOutput && outputFormat != "ndjson" && !streamText {
+		return fmt.Errorf("--ordered requires --output ndjson or --stream")
+	}
+
+	if outputFormat == "ndjson" && (verifyPTRs || dualStack || countOnly || compareServer) {
+		return fmt.Errorf("--output ndjson streams results and cannot be combined with --verify, --dual-stack, --compare-server, or --count")
+	}
+
+	if streamText && (verifyPTRs || dualStack || countOnly || compareServer) {
+		return fmt.Errorf("--stream cannot be combined with --verify, --dual-stack, --compare-server, or --count")
+	}
+
+	var err error
+	if concurrencySpec, err = ParseConcurrency(concurrencyFlag); err != nil {
+		return err
+	}
+
+	var tmpl *template.Template
+	if formatTmpl != "" {
+		if outputFormat != "text" {
+			return fmt.Errorf("--format-template cannot be combined with --output %s", outputFormat)
+		}
 		var err error
-		resolver, err = CustomResolver(dnsServer)
+		tmpl, err = ParseTemplate(formatTmpl)
 		if err != nil {
 			return err
 		}
-	} else {
-		resolver = DefaultResolver()
 	}
-	resultChan := LookupWorkers(ctx, ips, concurrency, resolver)
 
-	// Collect results
-	total := len(ips)
-	results := make([]LookupResult, 0, total)
-	showProgress := term.IsTerminal(int(os.Stderr.Fd()))
+	if dualStack && !expandOutput {
+		return fmt.Errorf("--dual-stack requires --expand")
+	}
+
+	if dohURL != "" && len(dnsServers) > 0 {
+		return fmt.Errorf("--doh and --server are mutually exclusive")
+	}
+
+	if dohURL != "" && bindInterface != "" {
+		return fmt.Errorf("--interface cannot be used with --doh")
+	}
+
+	if mockFile != "" && (dohURL != "" || len(dnsServers) > 0 || bindInterface != "" || useDNSClient()) {
+		return fmt.Errorf("--mock-file replaces DNS and cannot be combined with --server, --doh, --interface, or built-in DNS client flags")
+	}
 
-	if showProgress {
-		start := time.Now()
-		ticker := time.NewTicker(500 * time.Millisecond)
-		defer ticker.Stop()
-
-		for result := range resultChan {
-			results = append(results, result)
-			select {
-			case <-ticker.C:
-				if time.Since(start) >= 2*time.Second {
-					fmt.Fprintf(os.Stderr, "\rLooking up IPs... %d/%d (%d%%)", len(results), total, 100*len(results)/total)
-				}
-			default:
+	if compareServer && len(dnsServers) == 0 {
+		return fmt.Errorf("--compare-server requires --server")
+	}
+
+	if dialTimeout < 0 || queryTimeout < 0 {
+		return fmt.Errorf("--dial-timeout and --query-timeout must not be negative")
+	}
+
+	if minResolved < 0 || minResolved > 100 {
+		return fmt.Errorf("--min-resolved-pct must be between 0 and 100")
+	}
+
+	if progressDelay < 0 {
+		return fmt.Errorf("--progress-delay must not be negative")
+	}
+
+	if retries < 0 {
+		return fmt.Errorf("--retries must not be negative")
+	}
+
+	if perServer < 0 {
+		return fmt.Errorf("--concurrency-per-server must not be negative")
+	}
+
+	if perServer > 0 && len(dnsServers) == 0 {
+		return fmt.Errorf("--concurrency-per-server requires --server")
+	}
+
+	if compareServer && !expandOutput {
+		return fmt.Errorf("--compare-server requires --expand")
+	}
+
+	if showCNAMEs && !expandOutput {
+		return fmt.Errorf("--show-cname-chain requires --expand")
+	}
+
+	if showAuthority && !expandOutput {
+		return fmt.Errorf("--show-authority requires --expand")
+	}
+
+	if dnssec && !expandOutput {
+		return fmt.Errorf("--dnssec requires --expand")
+	}
+
+	if verifySame && !verifyPTRs {
+		return fmt.Errorf("--verify-same-server requires --verify")
+	}
+
+	if searchDomain != "" && !verifyPTRs {
+		return fmt.Errorf("--search-domain requires --verify")
+	}
+
+	if inferAfter < 0 {
+		return fmt.Errorf("--infer-patterns must not be negative")
+	}
+
+	if maxPTRLength < 0 {
+		return fmt.Errorf("max PTR length must not be negative")
+	}
+
+	if batchSize < 0 {
+		return fmt.Errorf("--batch-size must not be negative")
+	}
+
+	if batchSize > 0 && outputFormat != "ndjson" && !streamText {
+		return fmt.Errorf("--batch-size requires --output ndjson or --stream")
+	}
+
+	if cmd.Flags().Changed("seed") && !shuffle {
+		return fmt.Errorf("--seed requires --shuffle")
+	}
+	if shuffle && !cmd.Flags().Changed("seed") {
+		seed = rand.Uint64()
+	}
+
+	if queueSize < 0 {
+		return fmt.Errorf("queue size must not be negative")
+	}
+
+	if ipv4Only && ipv6Only {
+		return fmt.Errorf("--ipv4-only and --ipv6-only are mutually exclusive")
+	}
+
+	if fromHost && inputFile != "" {
+		return fmt.Errorf("--from-host cannot be combined with --input-file")
+	}
+
+	recheckStatus, recheckFile, _ := strings.Cut(recheck, "=")
+	if recheck != "" && recheckFile == "" {
+		return fmt.Errorf("invalid --recheck %q: want resolved=FILE or errors=FILE", recheck)
+	}
+
+	if fromHost && recheck != "" {
+		return fmt.Errorf("--from-host cannot be combined with --recheck")
+	}
+
+	if fromHost && len(asns) > 0 {
+		return fmt.Errorf("--from-host cannot be combined with --asn")
+	}
+
+	if metricsFile != "" && dryRun {
+		return fmt.Errorf("--metrics-file records lookups and cannot be combined with --dry-run")
+	}
+
+	if refreshAfter < 0 {
+		return fmt.Errorf("invalid --refresh-older-than %v: must not be negative", refreshAfter)
+	}
+
+	if refreshAfter > 0 && cacheFile == "" {
+		return fmt.Errorf("--refresh-older-than requires --cache-file")
+	}
+
+	if cacheFile != "" && (showCNAMEs || showAuthority || dnssec) {
+		return fmt.Errorf("--cache-file keeps only PTR names and cannot be combined with --show-cname-chain, --show-authority, or --dnssec")
+	}
+
+	if fromHost && firstHost {
+		return fmt.Errorf("--from-host and --first-host are mutually exclusive")
+	}
+
+	if usableOnly && (fromHost || firstHost) {
+		return fmt.Errorf("--usable-only cannot be combined with --from-host or --first-host")
+	}
+
+	if rangeArgs {
+		if len(args) != 2 {
+			return fmt.Errorf("--range takes exactly two arguments, the first and last address, got %d", len(args))
+		}
+		cidrs, err := RangeToCIDRs(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		args = cidrs
+	} else if !fromHost {
+		// Like --input-file lines, targets may be bare IPs, IP:port, or
+		// integers as well as CIDRs
+		targets := make([]string, len(args))
+		for i, arg := range args {
+			cidr, err := targetCIDR(arg)
+			if err != nil {
+				return fmt.Errorf("invalid CIDR %q: %w", arg, err)
 			}
+			targets[i] = cidr
 		}
-		// Clear the progress line
-		fmt.Fprintf(os.Stderr, "\r%-60s\r", "")
-	} else {
-		for result := range resultChan {
-			results = append(results, result)
+		args = targets
+	}
+
+	if prefer != "pattern" && prefer != "exact" {
+		return fmt.Errorf("invalid --prefer %q: must be exact or pattern", prefer)
+	}
+
+	if mergeFamilies && expandOutput {
+		return fmt.Errorf("--merge-families applies to consolidated output and cannot be combined with --expand")
+	}
+
+	if groupPatterns && (outputFormat != "json" || expandOutput || jsonSchema == "flat") {
+		return fmt.Errorf("--group-patterns requires consolidated --output json and cannot be combined with --expand or --json-schema flat")
+	}
+
+	if alsoOutput != "" {
+		if outputFormat == "ndjson" || streamText || countOnly || formatTmpl != "" {
+			return fmt.Errorf("--also-output cannot be combined with --output ndjson, --stream, --count, or --format-template")
+		}
+		if alsoOutput == outputFile || (alsoOutput == "-" && outputFile == "") {
+			return fmt.Errorf("--also-output must name a different destination than the main output (see --output-file)")
+		}
+	}
+
+	if showArpa && !expandOutput {
+		return fmt.Errorf("--show-arpa requires --expand")
+	}
+
+	if minPrefix < 0 || minPrefix > 128 {
+		return fmt.Errorf("--min-prefix must be between 0 and 128")
+	}
+
+	if mergeEmpty && expandOutput {
+		return fmt.Errorf("--merge-empty applies to consolidated output and cannot be combined with --expand")
+	}
+
+	if minPrefix > 0 && expandOutput {
+		return fmt.Errorf("--min-prefix applies to consolidated output and cannot be combined with --expand")
+	}
+
+	if explain && expandOutput {
+		return fmt.Errorf("--explain applies to consolidated output and cannot be combined with --expand")
+	}
+
+	if matchRatio && expandOutput {
+		return fmt.Errorf("--match-ratio applies to consolidated output and cannot be combined with --expand")
+	}
+
+	if showKind && expandOutput {
+		return fmt.Errorf("--show-kind applies to consolidated output and cannot be combined with --expand")
+	}
+
+	if debugConsol && expandOutput {
+		return fmt.Errorf("--debug-consolidation applies to consolidated output and cannot be combined with --expand")
+	}
+
+	if onlyPatterns && expandOutput {
+		return fmt.Errorf("--only-patterns applies to consolidated output and cannot be combined with --expand")
+	}
+
+	if onlyPatterns && nxdomainOnly {
+		return fmt.Errorf("--only-patterns and --nxdomain-only are mutually exclusive")
+	}
+
+	if dropOtherFamily && !ipv4Only && !ipv6Only {
+		return fmt.Errorf("--drop-other-family requires --ipv4-only or --ipv6-only")
+	}
+
+	if cmd.Flags().Changed("aggregate-threshold") && !aggressiveAggregate {
+		return fmt.Errorf("--aggregate-threshold requires --aggressive-aggregate")
+	}
+
+	if aggregateThreshold <= 0 || aggregateThreshold > 1 {
+		return fmt.Errorf("invalid aggregate threshold %v: must be greater than 0 and at most 1", aggregateThreshold)
+	}
+
+	if spill {
+		if outputFormat != "text" && outputFormat != "ndjson" {
+			return fmt.Errorf("--spill writes per-IP output: use --output text or ndjson")
+		}
+		if outputFormat == "text" && !expandOutput {
+			return fmt.Errorf("--spill requires --expand with --output text: consolidated output needs every result at once")
+		}
+		if sortOutput || sortByChanged || sortDesc || streamText || countOnly || alsoOutput != "" || formatTmpl != "" {
+			return fmt.Errorf("--spill cannot be combined with --sort, --sort-by, --sort-desc, --stream, --count, --also-output, or --format-template")
+		}
+		if verifyPTRs || dualStack || compareServer || showCNAMEs || showAuthority || dnssec {
+			return fmt.Errorf("--spill cannot be combined with --verify, --dual-stack, --compare, --show-cname-chain, --show-authority, or --dnssec")
+		}
+	}
+
+	ctx := context.Background()
+	if spill {
+		// Cancel the scan on interrupt, so the spill file is removed
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+	}
+	resolver, err := newResolver()
+	if err != nil {
+		return err
+	}
+
+	if checkResolver {
+		check := CheckResolver(ctx, CheckIP, resolver)
+		check.Resolver = resolverDescription()
+		if err := WriteResolverCheck(os.Stdout, check, outputFormat); err != nil {
+			return err
 		}
+		if !check.OK {
+			return fmt.Errorf("resolver check failed")
+		}
+		return nil
+	}
+
+	if inputFile != "" {
+		targets, err := readInputFile(inputFile)
+		if err != nil {
+			return err
+		}
+		args = append(args, targets...)
+	}
+	if len(asns) > 0 {
+		client := &http.Client{Timeout: ASNTimeout}
+		for _, asn := range asns {
+			prefixes, err := ASNPrefixes(ctx, client, asnSource, asn)
+			if err != nil {
+				return err
+			}
+			if len(prefixes) == 0 {
+				return fmt.Errorf("no announced prefixes for %s", asn)
+			}
+			args = append(args, prefixes...)
+		}
+	}
+	if recheck != "" {
+		targets, err := readRecheckFile(recheckFile, recheckStatus)
+		if err != nil {
+			return err
+		}
+		if len(targets) == 0 {
+			return fmt.Errorf("nothing to recheck: no %s results in %s", recheckStatus, recheckFile)
+		}
+		args = append(args, targets...)
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("no targets: give at least one CIDR or use --input-file or --asn")
+	}
+
+	// Restrict inputs to one address family if requested
+	family := ""
+	if ipv4Only {
+		family = "ipv4"
+	} else if ipv6Only {
+		family = "ipv6"
+	}
+
+	var inFlight *InFlight
+	if verbose {
+		inFlight = NewInFlight()
+	}
+	var cache *Cache
+	if cacheFile != "" {
+		if cache, err = LoadCache(cacheFile, refreshAfter); err != nil {
+			return err
+		}
+	}
+	cfg := Config{
+		Targets:         args,
+		Resolver:        resolver,
+		MaxIPs:          maxIPs,
+		PerCIDRMax:      perCIDRMax,
+		Family:          family,
+		DropOtherFamily: dropOtherFamily,
+		Excludes:        excludes,
+		FirstHost:       firstHost,
+		UsableOnly:      usableOnly,
+		FromHost:        fromHost,
+		Workers: WorkerOptions{
+			QueueSize:  queueSize,
+			InferAfter: inferAfter,
+			InFlight:   inFlight,
+			Shuffle:    shuffle,
+			Seed:       seed,
+
+			QueryTimeout:  queryTimeout,
+			Retries:       retries,
+			LoopbackNames: loopbackNames,
+			Cache:         cache,
+
+			TimeoutAsNXDomain: timeoutAsNX,
+			DropSelfPTR:       dropSelfPTR,
+		},
+		Verify:       verifyPTRs,
+		SearchDomain: searchDomain,
+		DualStack:    dualStack,
+	}
+	if compareServer {
+		cfg.Compare = DefaultResolver()
+	}
+	if verifyPTRs && !verifySame && (len(dnsServers) > 0 || dohURL != "") {
+		// Forward-confirm as other clients would see the name
+		cfg.VerifyResolver = DefaultResolver().(ForwardResolver)
+	}
+
+	// Expand arguments into IPs
+	plan, err := PlanScan(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	ips := plan.IPs
+	concurrency = concurrencySpec.Workers(len(ips))
+	cfg.Concurrency = concurrency
+	if showConfig {
+		writeEffectiveConfig(os.Stderr, cmd, plan)
+	}
+
+	// The plan is a report, not scan output: --output-file and --also-output
+	// are left untouched
+	if dryRun {
+		return WritePlan(os.Stdout, Plan{Total: plan.Total, Queried: len(ips)}, outputFormat)
+	}
+
+	// Fail fast on an unreachable --server or --doh instead of once per IP.
+	// Each pooled server is checked, not just whichever is next in turn.
+	if (len(dnsServers) > 0 || dohURL != "") && !noPreflight {
+		preflight := []Resolver{resolver}
+		if pool, ok := resolver.(*ServerPool); ok {
+			preflight = pool.Resolvers()
+		}
+		for _, r := range preflight {
+			if err := Preflight(ctx, ips[0], r); err != nil {
+				return fmt.Errorf("preflight query failed: %w (use --no-preflight to skip)", err)
+			}
+		}
+	}
+
+	// Only now create or truncate the output files, so a scan that never
+	// starts leaves them as they were
+	out, err := createOutput(outputFile)
+	if err != nil {
+		return err
+	}
+	if usePager && (outputFile == "" || outputFile == "-") && term.IsTerminal(int(os.Stdout.Fd())) {
+		out = newPager(os.Getenv("PAGER"), os.Stdout)
+	}
+	defer out.Close()
+	var also io.WriteCloser
+	if alsoOutput != "" {
+		if also, err = createOutput(alsoOutput); err != nil {
+			return err
+		}
+		defer also.Close()
+	}
+
+	showProgress := term.IsTerminal(int(os.Stderr.Fd()))
+	if showProgress && !firstHost && plan.Total.Cmp(big.NewInt(int64(len(ips)))) > 0 {
+		fmt.Fprintf(os.Stderr, "note: querying %d of %s addresses (truncated by --max-ips)\n", len(ips), plan.Total)
+	}
+	cfg.Status, cfg.ShowProgress, cfg.ProgressDelay = os.Stderr, showProgress, progressDelay
+	if verbose {
+		writeScanHeader(os.Stderr, args, len(ips))
 	}
 
-	// Output results
 	opts := OutputOptions{
 		Format:       outputFormat,
 		ResolvedOnly: resolvedOnly,
 		NXDomainOnly: nxdomainOnly,
-		Sort:         sortOutput,
+		HideNXDomain: hideNXDomain,
+		Sort:         sortOutput || sortByChanged,
+		SortBy:       sortBy,
+		Descending:   sortDesc,
 		Expand:       expandOutput,
+		Template:     tmpl,
+		Verify:       verifyPTRs,
+		DualStack:    dualStack,
+		CNAMEChain:   showCNAMEs,
+		Compare:      compareServer,
+		TagProvider:  tagProvider,
+		MergeFamily:  mergeFamilies,
+		Explain:      explain,
+		PreferExact:  prefer == "exact",
+		MaxPTRLength: maxPTRLength,
+		ExpandIPv6:   ipv6Expand,
+		JSONSchema:   jsonSchema,
+		BatchSize:    batchSize,
+		JSONCompact:  jsonCompact,
+		ShowArpa:     showArpa,
+		MinPrefix:    minPrefix,
+		MergeEmpty:   mergeEmpty,
+		Authority:    showAuthority,
+		DNSSEC:       dnssec,
+		MatchRatio:   matchRatio,
+		ShowKind:     showKind,
+		OnlyPatterns: onlyPatterns,
+		GroupByPTR:   groupPatterns,
+	}
+	if aggressiveAggregate {
+		opts.AggregateThreshold = aggregateThreshold
+	}
+	if debugConsol {
+		opts.ConsolidationLog = os.Stderr
+	}
+	if outputFormat == "table" {
+		// Box drawing and fitting the PTR column only suit a terminal
+		fd := int(os.Stdout.Fd())
+		tty := (outputFile == "" || outputFile == "-") && term.IsTerminal(fd)
+		opts.TableASCII = !tty
+		if width, _, err := term.GetSize(fd); tty && err == nil {
+			opts.TableWidth = width
+		}
+	}
+
+	// --spill keeps the results on disk, and writes them back in input order
+	if spill {
+		sp, err := NewSpill("", len(ips))
+		if err != nil {
+			return err
+		}
+		defer sp.Close()
+		t := scanTally{scanned: true}
+		var addErr error
+		resultChan := LookupWorkersWith(ctx, ips, concurrency, resolver, cfg.Workers)
+		drainResults(resultChan, len(ips), cfg.ShowProgress, cfg.ProgressDelay, cfg.Status, cfg.Workers.InFlight, func(r LookupResult) {
+			if addErr == nil {
+				addErr = sp.Add(r)
+			}
+			t.add(r)
+		})
+		if addErr != nil {
+			return addErr
+		}
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("interrupted: %w", err)
+		}
+		if err := sp.Write(out, opts); err != nil {
+			return err
+		}
+		*tally = t
+		if cache != nil {
+			if err := cache.Save(cacheFile); err != nil {
+				return err
+			}
+		}
+		return checkMinResolved(*tally)
 	}
 
-	return WriteOutput(os.Stdout, results, opts)
+	// NDJSON and --stream write each result as it completes, without collecting
+	if outputFormat == "ndjson" || streamText {
+		resultChan := LookupWorkersWith(ctx, ips, concurrency, resolver, cfg.Workers)
+		var results []LookupResult
+		var err error
+		if streamText {
+			results, err = StreamText(out, resultChan, opts, orderedOutput)
+		} else {
+			results, err = StreamNDJSON(out, resultChan, opts, orderedOutput)
+		}
+		if err != nil {
+			return err
+		}
+		*tally = tallyResults(results)
+		if cache != nil {
+			if err := cache.Save(cacheFile); err != nil {
+				return err
+			}
+		}
+		if verbose {
+			writeWildcards(os.Stderr, results, plan.Sources)
+		}
+		return checkMinResolved(*tally)
+	}
+
+	results, err := ExecutePlan(ctx, cfg, plan)
+	if err != nil {
+		return err
+	}
+	*tally = tallyResults(results)
+	if cache != nil {
+		if err := cache.Save(cacheFile); err != nil {
+			return err
+		}
+	}
+	if verbose {
+		writeWildcards(os.Stderr, results, plan.Sources)
+	}
+
+	if err := writeResults(out, also, results, opts); err != nil {
+		return err
+	}
+	return checkMinResolved(*tally)
+}
+
+// writeResults writes the collected results in the selected format, and the
+// other view to also if set.
+func writeResults(out, also io.Writer, results []LookupResult, opts OutputOptions) error {
+	if countOnly {
+		return WriteCounts(out, results, opts)
+	}
+	if err := WriteOutput(out, results, opts); err != nil {
+		return err
+	}
+	if also != nil {
+		// The other view of the same results, without a second scan
+		other := opts
+		other.Expand = !opts.Expand
+		other.Sort = true // per-IP text is otherwise in completion order
+		return WriteOutput(also, results, other)
+	}
+	return nil
 }
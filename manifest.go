@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// Manifest records how a run was invoked, for audit trails and re-running
+// the same scan. It is written as a JSON sidecar by --manifest.
+type Manifest struct {
+	Version         string            `json:"version"`
+	Command         []string          `json:"command"`  // Full argv, to re-run the scan
+	Targets         []string          `json:"targets"`  // Positional CIDR/host arguments
+	Flags           map[string]string `json:"flags"`    // Flags set on the command line
+	Resolver        string            `json:"resolver"` // "system", or the server queried
+	Start           time.Time         `json:"start"`
+	End             time.Time         `json:"end"`
+	DurationSeconds float64           `json:"duration_seconds"`
+}
+
+// changedFlags returns the flags explicitly set in fs, by name.
+func changedFlags(fs *pflag.FlagSet) map[string]string {
+	flags := make(map[string]string)
+	fs.Visit(func(f *pflag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	return flags
+}
+
+// WriteManifest writes m as indented JSON to path.
+func WriteManifest(path string, m Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
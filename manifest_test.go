@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+func TestChangedFlags(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.Int("concurrency", 50, "")
+	fs.String("server", "", "")
+	fs.Bool("expand", false, "")
+	if err := fs.Parse([]string{"--server", "1.1.1.1", "--expand"}); err != nil {
+		t.Fatal(err)
+	}
+
+	flags := changedFlags(fs)
+	if len(flags) != 2 || flags["server"] != "1.1.1.1" || flags["expand"] != "true" {
+		t.Errorf("changedFlags = %v; want only server and expand", flags)
+	}
+}
+
+func TestWriteManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.json")
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	m := Manifest{
+		Version:         "1.2.3",
+		Command:         []string{"sr", "-S", "1.1.1.1", "192.0.2.0/30"},
+		Targets:         []string{"192.0.2.0/30"},
+		Flags:           map[string]string{"server": "1.1.1.1"},
+		Resolver:        "server 1.1.1.1",
+		Start:           start,
+		End:             start.Add(1500 * time.Millisecond),
+		DurationSeconds: 1.5,
+	}
+	if err := WriteManifest(path, m); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("manifest is not JSON: %v", err)
+	}
+	if got["version"] != "1.2.3" || got["resolver"] != "server 1.1.1.1" || got["duration_seconds"] != 1.5 {
+		t.Errorf("manifest = %s", data)
+	}
+	if got["start"] != "2024-01-02T03:04:05Z" {
+		t.Errorf("start = %v; want RFC 3339", got["start"])
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// metric is one sample in the --metrics-file output.
+type metric struct {
+	name  string
+	typ   string // Prometheus type: counter or gauge
+	help  string
+	value float64
+}
+
+// WriteMetrics writes the run's tallies and duration to w in the
+// Prometheus text exposition format. c counts only the addresses looked up
+// (see CountQueried); inferred counts those given an inferred pattern
+// instead.
+func WriteMetrics(w io.Writer, c Counts, inferred int, duration time.Duration) error {
+	metrics := []metric{
+		{"sr_lookups_total", "counter", "Addresses looked up.", float64(c.Total)},
+		{"sr_resolved_total", "counter", "Addresses looked up with a PTR record.", float64(c.Resolved)},
+		{"sr_nxdomain_total", "counter", "Addresses looked up without a PTR record.", float64(c.NXDomain)},
+		{"sr_errors_total", "counter", "Addresses whose lookup failed.", float64(c.Errors)},
+		{"sr_inferred_total", "counter", "Addresses given a pattern inferred from their neighbours instead of a lookup.", float64(inferred)},
+		{"sr_duration_seconds", "gauge", "Wall-clock time the run took.", duration.Seconds()},
+	}
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", m.name, m.help, m.name, m.typ, m.name, m.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteMetricsFile writes the metrics to path for a node_exporter textfile
+// collector. The file is written under a temporary name and renamed into
+// place, so the collector never reads a partial one.
+func WriteMetricsFile(path string, c Counts, inferred int, duration time.Duration) error {
+	var buf bytes.Buffer
+	if err := WriteMetrics(&buf, c, inferred, duration); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	c := Counts{Total: 256, Resolved: 200, NXDomain: 50, Errors: 6}
+	if err := WriteMetrics(&buf, c, 12, 1500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE sr_lookups_total counter\nsr_lookups_total 256\n",
+		"sr_resolved_total 200\n",
+		"sr_nxdomain_total 50\n",
+		"sr_errors_total 6\n",
+		"sr_inferred_total 12\n",
+		"# TYPE sr_duration_seconds gauge\nsr_duration_seconds 1.5\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %q:\n%s", want, out)
+		}
+	}
+	// Every sample has HELP and TYPE lines
+	if n := strings.Count(out, "# HELP "); n != 6 {
+		t.Errorf("got %d HELP lines, want 6", n)
+	}
+}
+
+func TestWriteMetricsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sr.prom")
+	if err := os.WriteFile(path, []byte("stale\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteMetricsFile(path, Counts{Total: 4, Resolved: 1, NXDomain: 3}, 0, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "sr_lookups_total 4\n") || strings.Contains(string(data), "stale") {
+		t.Errorf("metrics file = %q", data)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
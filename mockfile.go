@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// FileResolver answers from a fixture of IP to PTR mappings instead of DNS,
+// for reproducible demos and tests (--mock-file). Unmapped IPs are NXDOMAIN.
+// Forward lookups are answered from the same mappings, so --verify works
+// offline too.
+type FileResolver struct {
+	ptrs    map[string][]string     // IP (canonical form) -> PTR names
+	forward map[string][]net.IPAddr // lowercase PTR name -> IPs mapped to it
+}
+
+// ReadFileResolver reads a fixture, one IP per line followed by its PTR
+// names: "192.0.2.1 host.example.com". Blank lines and lines starting with
+// "#" are ignored.
+func ReadFileResolver(r io.Reader) (*FileResolver, error) {
+	f := &FileResolver{
+		ptrs:    make(map[string][]string),
+		forward: make(map[string][]net.IPAddr),
+	}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			return nil, fmt.Errorf("line %d: invalid IP address %q", line, fields[0])
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: no PTR name for %s", line, fields[0])
+		}
+		key := ip.String()
+		for _, name := range fields[1:] {
+			name = strings.TrimSuffix(name, ".")
+			f.ptrs[key] = append(f.ptrs[key], name+".")
+			host := strings.ToLower(name)
+			f.forward[host] = append(f.forward[host], net.IPAddr{IP: ip})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// LoadFileResolver reads the fixture at path.
+func LoadFileResolver(path string) (*FileResolver, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	f, err := ReadFileResolver(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// LookupAddr implements Resolver.
+func (f *FileResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(addr)
+	if ip != nil {
+		if names, ok := f.ptrs[ip.String()]; ok {
+			return names, nil
+		}
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+}
+
+// LookupIPAddr implements ForwardResolver.
+func (f *FileResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if addrs, ok := f.forward[strings.ToLower(strings.TrimSuffix(host, "."))]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
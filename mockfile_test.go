@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReadFileResolver(t *testing.T) {
+	fixture := `# demo fixture
+192.0.2.1 host.example.com.
+192.0.2.2   host.example.com  alias.example.com
+
+2001:db8::1 v6.example.com
+`
+	f, err := ReadFileResolver(strings.NewReader(fixture))
+	if err != nil {
+		t.Fatalf("ReadFileResolver error: %v", err)
+	}
+
+	result := lookupIP(context.Background(), net.ParseIP("192.0.2.1"), f)
+	if result.Error != nil || result.PTR != "host.example.com" {
+		t.Errorf("192.0.2.1 = %q, %v; want host.example.com", result.PTR, result.Error)
+	}
+	names, _ := f.LookupAddr(context.Background(), "192.0.2.2")
+	if len(names) != 2 || names[1] != "alias.example.com." {
+		t.Errorf("192.0.2.2 names = %v, want both PTRs", names)
+	}
+	if result := lookupIP(context.Background(), net.ParseIP("2001:0db8::0001"), f); result.PTR != "v6.example.com" {
+		t.Errorf("2001:db8::1 = %q, want v6.example.com", result.PTR)
+	}
+
+	// Unmapped IPs are NXDOMAIN, not errors
+	result = lookupIP(context.Background(), net.ParseIP("192.0.2.3"), f)
+	if result.Error != nil || result.PTR != "" {
+		t.Errorf("192.0.2.3 = %q, %v; want NXDOMAIN", result.PTR, result.Error)
+	}
+
+	// Forward lookups come from the same mappings
+	addrs, err := f.LookupIPAddr(context.Background(), "HOST.example.com.")
+	if err != nil || len(addrs) != 2 {
+		t.Errorf("LookupIPAddr = %v, %v; want the two mapped IPs", addrs, err)
+	}
+}
+
+func TestReadFileResolverErrors(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    string
+	}{
+		{"192.0.2.1 host.example.com\nbogus host.example.com\n", "line 2: invalid IP address"},
+		{"192.0.2.1\n", "line 1: no PTR name"},
+	}
+	for _, tt := range tests {
+		_, err := ReadFileResolver(strings.NewReader(tt.fixture))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ReadFileResolver(%q) error = %v, want %q", tt.fixture, err, tt.want)
+		}
+	}
+}
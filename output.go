@@ -1,113 +1,604 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
+	"math/big"
 	"net"
+	"slices"
 	"sort"
 	"strings"
+	"text/template"
 )
 
 // OutputOptions controls how results are formatted and filtered.
 type OutputOptions struct {
-	Format       string // "text" or "json"
+	Format       string // "text", "json", or "ndjson"
 	ResolvedOnly bool   // Only show IPs with PTR records
 	NXDomainOnly bool   // Only show IPs without PTR records
+	HideNXDomain bool   // Drop NXDOMAIN entries but keep errors
 	Sort         bool   // Sort output by IP address
+	Descending   bool   // Order output from the highest IP down (--sort-desc)
+	SortBy       string // Key Sort orders per-IP output by: "ip" (or "") or "ptr" (--sort-by)
 	Expand       bool   // Show per-IP output instead of consolidated CIDRs
+	Verify       bool   // Show forward-confirmation (FCrDNS) status
+	DualStack    bool   // Show address families of each PTR name
+	CNAMEChain   bool   // Show the CNAME chain followed to each PTR
+	TagProvider  bool   // Tag results with the provider guessed from the PTR suffix
+	Compare      bool   // Show the system resolver's answer where it differs
+	MergeFamily  bool   // Merge consolidated entries sharing a PTR across IPv4 and IPv6
+	PreferExact  bool   // Keep concrete PTRs rather than collapsing single IPs into patterns
+	MaxPTRLength int    // Truncate PTRs in text output to this many characters (0 = no limit)
+	Explain      bool   // List the member IPs and original PTRs of each pattern entry
+	ExpandIPv6   bool   // Write IPv6 addresses fully expanded (2001:0db8:0000:...)
+	JSONSchema   string // "flat" writes "ip" plus "prefix_length" instead of "network"; "" or "default" keeps the usual keys
+	BatchSize    int    // Streaming: flush output every N results (0 = after each result)
+	JSONCompact  bool   // Write JSON on one line instead of indented
+	ShowArpa     bool   // Show the in-addr.arpa/ip6.arpa name queried for each IP
+	TableASCII   bool   // Table output: draw borders with +-| instead of box-drawing characters
+	TableWidth   int    // Table output: truncate the PTR column to fit this many columns (0 = no limit)
+	MinPrefix    int    // Split consolidated networks shorter than this prefix length (0 = no limit)
+	MergeEmpty   bool   // Merge adjacent NXDOMAIN and error entries into "no data" ranges
+	Authority    bool   // Show whether each PTR came from an authoritative answer
+	MatchRatio   bool   // Show how many addresses of each pattern entry actually matched it
+	ShowKind     bool   // Text: mark each resolved entry as exact or pattern
+	OnlyPatterns bool   // Keep only consolidated "*." pattern entries (--only-patterns)
+	DNSSEC       bool   // Show whether each PTR answer was signed and validated (--dnssec)
+	GroupByPTR   bool   // JSON: one entry per PTR listing all its networks (--group-patterns)
+
+	// ConsolidationLog, if set, receives a line for each group formed while
+	// consolidating (--debug-consolidation).
+	ConsolidationLog io.Writer
+
+	// Template, if set, replaces text output with one executed line per result.
+	Template *template.Template
+
+	// AggregateThreshold enables aggressive aggregation when > 0: a supernet
+	// is emitted if at least this fraction of it shares one PTR.
+	AggregateThreshold float64
 }
 
+// Kinds of consolidated entry, so consumers can tell a PTR that every
+// address literally shares from a "*." pattern standing in for many names.
+const (
+	KindExact    = "exact"    // IPs sharing one literal PTR, or a single IP
+	KindPattern  = "pattern"  // IP-templated PTRs folded into "*.suffix"
+	KindNXDomain = "nxdomain" // No PTR record
+	KindError    = "error"    // Lookup failed
+	KindNoData   = "nodata"   // NXDOMAIN and errors merged by MergeEmpty
+)
+
 // ConsolidatedResult groups IPs with the same PTR into CIDR networks.
 type ConsolidatedResult struct {
 	Network *net.IPNet // Always set (single IPs get /32 or /128 mask)
 	PTR     string     // Empty for NXDOMAIN
 	Error   error      // Non-nil only for error entries
+	NoData  bool       // NXDOMAIN and error addresses merged by MergeEmpty
+	Kind    string     // How the entry was formed: KindExact, KindPattern, ... (set by ConsolidateResults)
+
+	Verified int      // IPs whose PTR forward-confirms (set by AnnotateVerification)
+	Checked  int      // Resolved IPs checked for forward confirmation
+	Provider string   // Hosting provider guessed from the PTR suffix (--tag-provider)
+	Sources  []string // Input CIDRs contributing to Network (set by AnnotateSources)
+	Inferred int      // IPs inferred rather than queried (set by AnnotateInferred)
+	Matched  int      // Queried IPs whose own PTR fits the "*." pattern (set by AnnotateMatches)
+
+	// Members holds the per-IP results behind a "*." pattern entry, with
+	// their original PTRs (set by AnnotateMembers).
+	Members []LookupResult
+
+	// Merged lists further networks sharing this PTR, from both address
+	// families (set by MergeFamilies). Network is the first of the group.
+	Merged []*net.IPNet
 }
 
 // FilterResults applies filtering options to results.
 func FilterResults(results []LookupResult, opts OutputOptions) []LookupResult {
-	if !opts.ResolvedOnly && !opts.NXDomainOnly {
+	if opts.TagProvider {
+		results = tagProviders(results)
+	}
+
+	if !opts.ResolvedOnly && !opts.NXDomainOnly && !opts.HideNXDomain {
 		return results
 	}
 
+	// Each filter removes entries; a result is kept only if every set
+	// filter keeps it
 	filtered := make([]LookupResult, 0, len(results))
 	for _, r := range results {
-		if opts.ResolvedOnly && r.PTR != "" {
-			filtered = append(filtered, r)
-		} else if opts.NXDomainOnly && r.PTR == "" && r.Error == nil {
-			filtered = append(filtered, r)
+		nxdomain := r.PTR == "" && r.Error == nil
+		if opts.ResolvedOnly && r.PTR == "" {
+			continue
+		}
+		if opts.NXDomainOnly && !nxdomain {
+			continue
+		}
+		if opts.HideNXDomain && nxdomain {
+			continue
 		}
+		filtered = append(filtered, r)
 	}
 	return filtered
 }
 
 // SortResults sorts results by IP address.
 func SortResults(results []LookupResult) {
-	sort.Slice(results, func(i, j int) bool {
-		return bytes.Compare(results[i].IP, results[j].IP) < 0
+	SortResultsBy(results, ByIP)
+}
+
+// SortResultsBy sorts results by less, keeping the order of equal results.
+func SortResultsBy(results []LookupResult, less func(a, b LookupResult) bool) {
+	sort.SliceStable(results, func(i, j int) bool {
+		return less(results[i], results[j])
+	})
+}
+
+// ByIP orders results by IP address.
+func ByIP(a, b LookupResult) bool {
+	return bytes.Compare(a.IP, b.IP) < 0
+}
+
+// ByPTR orders results by PTR name, grouping the IPs of each host, with
+// NXDOMAIN after every PTR and errors last. Ties are ordered by IP.
+func ByPTR(a, b LookupResult) bool {
+	if ra, rb := ptrRank(a), ptrRank(b); ra != rb {
+		return ra < rb
+	}
+	if a.PTR != b.PTR {
+		return a.PTR < b.PTR
+	}
+	return ByIP(a, b)
+}
+
+// ptrRank places a result for ByPTR: 0 with a PTR, 1 for NXDOMAIN, 2 for
+// an error.
+func ptrRank(r LookupResult) int {
+	switch {
+	case r.Error != nil:
+		return 2
+	case r.PTR == "":
+		return 1
+	}
+	return 0
+}
+
+// resultOrder returns the order opts asks per-IP output in: by PTR with
+// --sort-by ptr, else by IP, highest first with --sort-desc.
+func resultOrder(opts OutputOptions) func(a, b LookupResult) bool {
+	switch {
+	case opts.SortBy == "ptr":
+		return ByPTR
+	case opts.Descending:
+		return func(a, b LookupResult) bool { return ByIP(b, a) }
+	}
+	return ByIP
+}
+
+// sortConsolidated sorts consolidated results as consolidatedLess orders
+// them, or in exactly the reverse order if desc is set.
+func sortConsolidated(results []ConsolidatedResult, desc bool) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if desc {
+			return consolidatedLess(results[j], results[i])
+		}
+		return consolidatedLess(results[i], results[j])
 	})
 }
 
 // FormatText writes results in plain text format.
 func FormatText(w io.Writer, results []LookupResult) error {
+	return formatText(w, results, OutputOptions{})
+}
+
+// formatText is FormatText with per-result annotations controlled by opts.
+func formatText(w io.Writer, results []LookupResult, opts OutputOptions) error {
 	// Calculate the maximum IP width for alignment
 	// IPv4 max is 15 chars, IPv6 max is 39 chars
+	// The strings are kept for the write loop rather than formatted twice
+	ips := make([]string, len(results))
 	width := 15
-	for _, r := range results {
-		if len(r.IP.String()) > width {
-			width = len(r.IP.String())
-		}
+	for i, r := range results {
+		ips[i] = ipString(r.IP, opts.ExpandIPv6)
+		width = max(width, len(ips[i]))
 	}
 
 	format := fmt.Sprintf("%%-%ds %%s\n", width)
-	for _, r := range results {
-		var err error
-		if r.Error != nil {
-			_, err = fmt.Fprintf(w, format, r.IP, "ERROR: "+r.Error.Error())
-		} else if r.PTR != "" {
-			_, err = fmt.Fprintf(w, format, r.IP, r.PTR)
-		} else {
-			_, err = fmt.Fprintf(w, format, r.IP, "NXDOMAIN")
-		}
-		if err != nil {
+	for i, r := range results {
+		if _, err := fmt.Fprintf(w, format, ips[i], textLine(r, opts)); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// textLine returns the text shown after the IP for one result: the PTR with
+// its annotations, NXDOMAIN, or the error.
+func textLine(r LookupResult, opts OutputOptions) string {
+	var line string
+	if r.Error != nil {
+		line = "ERROR: " + r.Error.Error()
+	} else if r.PTR != "" {
+		line = truncatePTR(r.PTR, opts.MaxPTRLength)
+		if opts.Verify && !r.Inferred {
+			if r.Verified {
+				line += " (verified)"
+			} else {
+				line += " (unverified)"
+			}
+		}
+		if opts.CNAMEChain && len(r.CNAMEs) > 0 {
+			line += " (via " + strings.Join(r.CNAMEs, " -> ") + ")"
+		}
+		if opts.Authority && !r.Inferred {
+			if r.Authoritative {
+				line += " (authoritative)"
+			} else {
+				line += " (non-authoritative)"
+			}
+		}
+		if opts.DNSSEC && !r.Inferred {
+			switch {
+			case r.AuthenticData:
+				line += " (dnssec: validated)"
+			case r.Signed:
+				line += " (dnssec: signed)"
+			default:
+				line += " (dnssec: unsigned)"
+			}
+		}
+		if opts.DualStack {
+			if len(r.Families) == 0 {
+				line += " [no address]"
+			} else {
+				line += " [" + strings.Join(r.Families, ",") + "]"
+			}
+		}
+		if r.Provider != "" {
+			line += " (" + r.Provider + ")"
+		}
+		if r.Inferred {
+			line += " (inferred)"
+		}
+		if r.Warning != nil {
+			line += " (partial answer: " + r.Warning.Error() + ")"
+		}
+	} else {
+		line = "NXDOMAIN"
+	}
+	if opts.Compare {
+		line += compareAnnotation(r)
+	}
+	if opts.ShowArpa {
+		line += " (arpa: " + reverseName(r.IP) + ")"
+	}
+	return line
+}
+
+// truncatePTR shortens ptr to max characters for display, ending it with an
+// ellipsis. A max of 0 or less leaves ptr unchanged.
+func truncatePTR(ptr string, max int) string {
+	if max <= 0 || len(ptr) <= max {
+		return ptr
+	}
+	if max == 1 {
+		return "…"
+	}
+	return ptr[:max-1] + "…"
+}
+
+// compareAnnotation describes the comparison lookup when it disagrees with
+// the original one or failed.
+func compareAnnotation(r LookupResult) string {
+	switch {
+	case r.CompareError != nil:
+		return " [system: ERROR: " + r.CompareError.Error() + "]"
+	case !r.Mismatch:
+		return ""
+	case r.ComparePTR == "":
+		return " [MISMATCH system: NXDOMAIN]"
+	default:
+		return " [MISMATCH system: " + r.ComparePTR + "]"
+	}
+}
+
 // JSONResult is the JSON representation of a lookup result.
 type JSONResult struct {
-	IP    string  `json:"ip"`
-	PTR   *string `json:"ptr"`
-	Error *string `json:"error,omitempty"`
+	IP           string    `json:"ip"`
+	PrefixLength *int      `json:"prefix_length,omitempty"` // Flat schema only
+	PTR          *string   `json:"ptr"`
+	Error        *string   `json:"error,omitempty"`
+	Category     string    `json:"error_category,omitempty"` // Error category: timeout, servfail, refused, network, canceled, or other
+	Warning      *string   `json:"warning,omitempty"`        // Soft error returned with the PTR
+	Verified     *bool     `json:"verified,omitempty"`
+	Families     *[]string `json:"families,omitempty"`
+	CNAMEs       []string  `json:"cname_chain,omitempty"`
+	Authority    *bool     `json:"authoritative,omitempty"`
+	Signed       *bool     `json:"dnssec_signed,omitempty"` // --dnssec: RRSIGs present
+	Validated    *bool     `json:"dnssec_ad,omitempty"`     // --dnssec: AD bit set
+	Provider     *string   `json:"provider,omitempty"`
+	Inferred     bool      `json:"inferred,omitempty"`
+
+	Arpa         string  `json:"arpa,omitempty"` // Reverse name queried (--show-arpa)
+	ComparePTR   *string `json:"system_ptr,omitempty"`
+	CompareError *string `json:"system_error,omitempty"`
+	Mismatch     *bool   `json:"mismatch,omitempty"`
 }
 
-// FormatJSON writes results in JSON format.
-func FormatJSON(w io.Writer, results []LookupResult) error {
-	jsonResults := make([]JSONResult, len(results))
+// priorEntry is the part of a JSON result that --recheck reads back. Per-IP
+// entries carry "ip"; consolidated ones carry "network", or "ip" plus
+// "prefix_length" in the flat schema.
+type priorEntry struct {
+	IP           string  `json:"ip"`
+	Network      string  `json:"network"`
+	PrefixLength *int    `json:"prefix_length"`
+	PTR          *string `json:"ptr"`
+	Error        *string `json:"error"`
+}
 
-	for i, r := range results {
-		jr := JSONResult{IP: r.IP.String()}
+// ReadRecheckTargets reads results from a prior run's --output json or
+// ndjson and returns those with the given status as CIDR targets: "resolved"
+// (a PTR was found) or "errors" (the lookup failed). Consolidated entries
+// yield their whole network.
+func ReadRecheckTargets(r io.Reader, status string) ([]string, error) {
+	if status != "resolved" && status != "errors" {
+		return nil, fmt.Errorf("invalid recheck status %q: must be resolved or errors", status)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
 
-		if r.Error != nil {
-			errStr := r.Error.Error()
-			jr.Error = &errStr
-		} else if r.PTR != "" {
-			jr.PTR = &r.PTR
+	var entries []priorEntry
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, fmt.Errorf("invalid JSON results: %w", err)
 		}
-		// If no PTR and no error, PTR stays nil (NXDOMAIN)
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var e priorEntry
+			if err := dec.Decode(&e); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("invalid NDJSON results: %w", err)
+			}
+			entries = append(entries, e)
+		}
+	}
 
-		jsonResults[i] = jr
+	var targets []string
+	for _, e := range entries {
+		matches := e.Error != nil
+		if status == "resolved" {
+			matches = e.PTR != nil && e.Error == nil
+		}
+		if !matches {
+			continue
+		}
+		target := e.IP
+		switch {
+		case e.Network != "":
+			target = e.Network
+		case e.PrefixLength != nil:
+			target = fmt.Sprintf("%s/%d", e.IP, *e.PrefixLength)
+		}
+		cidr, err := normalizeTarget(target)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, cidr)
 	}
+	return targets, nil
+}
 
+// jsonEncoder returns a JSON encoder for w, indented for readability unless
+// opts.JSONCompact is set.
+func jsonEncoder(w io.Writer, opts OutputOptions) *json.Encoder {
 	encoder := json.NewEncoder(w)
-	encoder.SetIndent("", "  ")
+	if !opts.JSONCompact {
+		encoder.SetIndent("", "  ")
+	}
+	return encoder
+}
+
+// FormatJSON writes results in JSON format. Results are always sorted by
+// IP so JSON artifacts diff cleanly across runs; the input is not modified.
+func FormatJSON(w io.Writer, results []LookupResult) error {
+	return formatJSON(w, results, OutputOptions{})
+}
+
+// formatJSON is FormatJSON with per-result annotations controlled by opts.
+func formatJSON(w io.Writer, results []LookupResult, opts OutputOptions) error {
+	results = append([]LookupResult(nil), results...)
+	SortResultsBy(results, resultOrder(opts))
+
+	jsonResults := make([]JSONResult, len(results))
+	for i, r := range results {
+		jsonResults[i] = toJSONResult(r, opts)
+	}
+
+	encoder := jsonEncoder(w, opts)
 	return encoder.Encode(jsonResults)
 }
 
+// toJSONResult converts a lookup result to its JSON representation.
+func toJSONResult(r LookupResult, opts OutputOptions) JSONResult {
+	jr := JSONResult{IP: ipString(r.IP, opts.ExpandIPv6)}
+	if opts.ShowArpa {
+		jr.Arpa = reverseName(r.IP)
+	}
+	if opts.JSONSchema == "flat" {
+		ones, _ := singleIPNet(r.IP).Mask.Size()
+		jr.PrefixLength = &ones
+	}
+
+	if r.Error != nil {
+		errStr := r.Error.Error()
+		jr.Error = &errStr
+		jr.Category = ErrorCategory(r.Error)
+	} else if r.PTR != "" {
+		jr.PTR = &r.PTR
+		jr.Inferred = r.Inferred
+		if r.Warning != nil {
+			warn := r.Warning.Error()
+			jr.Warning = &warn
+		}
+		if opts.Verify && !r.Inferred {
+			jr.Verified = &r.Verified
+		}
+		if opts.CNAMEChain {
+			jr.CNAMEs = r.CNAMEs
+		}
+		if opts.Authority && !r.Inferred {
+			jr.Authority = &r.Authoritative
+		}
+		if opts.DNSSEC && !r.Inferred {
+			jr.Signed = &r.Signed
+			jr.Validated = &r.AuthenticData
+		}
+		if opts.DualStack {
+			families := r.Families
+			if families == nil {
+				families = []string{} // resolved nowhere: emit [] rather than omit
+			}
+			jr.Families = &families
+		}
+		if opts.TagProvider {
+			provider := r.Provider // "" for unknown suffixes
+			jr.Provider = &provider
+		}
+	}
+	// If no PTR and no error, PTR stays nil (NXDOMAIN)
+
+	if opts.Compare {
+		if r.CompareError != nil {
+			errStr := r.CompareError.Error()
+			jr.CompareError = &errStr
+		} else if r.ComparePTR != "" {
+			jr.ComparePTR = &r.ComparePTR
+		}
+		jr.Mismatch = &r.Mismatch
+	}
+
+	return jr
+}
+
+// StreamNDJSON writes each result as a single JSON line as soon as it arrives,
+// applying the filters in opts. If ordered is set, lines are emitted in input
+// order (by Index), holding back only results that complete ahead of an
+// earlier one. Returns the results read, including filtered ones.
+func StreamNDJSON(w io.Writer, resultChan <-chan LookupResult, opts OutputOptions, ordered bool) ([]LookupResult, error) {
+	bw := newBatchWriter(w, opts.BatchSize)
+	all, err := streamResults(resultChan, opts, ordered, ndjsonLineWriter(bw, opts))
+	return all, bw.close(err)
+}
+
+// StreamText writes each result as a tab-separated "IP<TAB>PTR" line as soon
+// as it arrives; columns cannot be aligned without seeing every result.
+// Filtering and ordering work as in StreamNDJSON.
+func StreamText(w io.Writer, resultChan <-chan LookupResult, opts OutputOptions, ordered bool) ([]LookupResult, error) {
+	bw := newBatchWriter(w, opts.BatchSize)
+	all, err := streamResults(resultChan, opts, ordered, textLineWriter(bw, opts))
+	return all, bw.close(err)
+}
+
+// ndjsonLineWriter returns the function StreamNDJSON writes each result to
+// bw with.
+func ndjsonLineWriter(bw *batchWriter, opts OutputOptions) func(LookupResult) error {
+	encoder := json.NewEncoder(bw)
+	return func(r LookupResult) error {
+		if err := encoder.Encode(toJSONResult(r, opts)); err != nil {
+			return err
+		}
+		return bw.written()
+	}
+}
+
+// textLineWriter returns the function StreamText writes each result to bw
+// with.
+func textLineWriter(bw *batchWriter, opts OutputOptions) func(LookupResult) error {
+	return func(r LookupResult) error {
+		if _, err := fmt.Fprintf(bw, "%s\t%s\n", ipString(r.IP, opts.ExpandIPv6), textLine(r, opts)); err != nil {
+			return err
+		}
+		return bw.written()
+	}
+}
+
+// batchWriter buffers streamed output and flushes it every size results, so
+// a slow sink gets steady chunks rather than one small write per line.
+type batchWriter struct {
+	*bufio.Writer
+	size    int // Results per flush; at least 1
+	pending int // Results written since the last flush
+}
+
+func newBatchWriter(w io.Writer, size int) *batchWriter {
+	return &batchWriter{Writer: bufio.NewWriter(w), size: max(size, 1)}
+}
+
+// written records one result and flushes once a batch is complete.
+func (b *batchWriter) written() error {
+	b.pending++
+	if b.pending < b.size {
+		return nil
+	}
+	b.pending = 0
+	return b.Flush()
+}
+
+// close flushes the final partial batch and returns err, or the flush error
+// if err is nil.
+func (b *batchWriter) close(err error) error {
+	if flushErr := b.Flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// streamResults passes each result that survives the filters in opts to
+// write as it arrives, or in input order (by Index) if ordered is set.
+// After a write error, remaining results are drained but not written.
+func streamResults(resultChan <-chan LookupResult, opts OutputOptions, ordered bool, write func(LookupResult) error) ([]LookupResult, error) {
+	var all []LookupResult
+	var writeErr error
+
+	emit := func(r LookupResult) {
+		if writeErr != nil {
+			return
+		}
+		for _, f := range FilterResults([]LookupResult{r}, opts) {
+			writeErr = write(f)
+		}
+	}
+
+	pending := make(map[int]LookupResult) // reorder buffer, keyed by Index
+	next := 0
+	for r := range resultChan {
+		all = append(all, r)
+		if !ordered {
+			emit(r)
+			continue
+		}
+		pending[r.Index] = r
+		for {
+			p, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			emit(p)
+			next++
+		}
+	}
+
+	return all, writeErr
+}
+
 // extractPTRPattern checks if a PTR record contains an IP-derived hostname
 // (e.g., ISP-style records like "1.100.147.64.static.nyinternet.net") and
 // returns a pattern like "*.static.nyinternet.net". Returns "" if no pattern found.
@@ -209,11 +700,7 @@ func extractIPv6PTRPattern(ip net.IP, ptr string) string {
 	}
 
 	// 1. Full expanded dashes: 2001-0db8-0000-0000-0000-0000-0000-0001
-	groups := make([]string, 8)
-	for i := 0; i < 8; i++ {
-		groups[i] = fmt.Sprintf("%02x%02x", ip16[i*2], ip16[i*2+1])
-	}
-	fullExpanded := strings.Join(groups, "-")
+	fullExpanded := strings.ReplaceAll(expandIPv6(ip16), ":", "-")
 
 	// 2. Compressed dashes: 2001-db8--1 (Go's net.IP.String() with colons replaced)
 	compressed := strings.ReplaceAll(ip.String(), ":", "-")
@@ -251,23 +738,74 @@ func extractIPv6PTRPattern(ip net.IP, ptr string) string {
 	return ""
 }
 
+// dedupeSortedIPs drops consecutive duplicates from a sorted IP slice.
+func dedupeSortedIPs(ips []net.IP) []net.IP {
+	deduped := []net.IP{ips[0]}
+	for i := 1; i < len(ips); i++ {
+		if !ips[i].Equal(ips[i-1]) {
+			deduped = append(deduped, ips[i])
+		}
+	}
+	return deduped
+}
+
 // ConsolidateResults groups IPs with the same PTR record into CIDR networks.
 // It performs two consolidation passes:
 //  1. Exact PTR match: IPs with identical PTR records are grouped together.
 //  2. Pattern match: Single-IP groups with IP-templated PTR records (e.g.,
 //     "1.100.147.64.static.nyinternet.net") are re-grouped by their common
 //     suffix pattern (e.g., "*.static.nyinternet.net").
+//
+// Exact matches always take precedence: an IP whose PTR is shared with
+// another IP is never folded into a pattern. Where patterns nest, the most
+// specific one wins: "cust-192-0-2-4.dsl.isp.net" joins "*.dsl.isp.net" if
+// another IP does, and only otherwise a "*.isp.net" that other IPs yield.
+// Use ConsolidateResultsExact to also keep single IPs' concrete hostnames.
+//
+// Each entry's Kind records which of these produced it.
 func ConsolidateResults(results []LookupResult) []ConsolidatedResult {
+	return consolidateResults(results, false, nil)
+}
+
+// ConsolidateResultsExact is ConsolidateResults without pass 2: single IPs
+// keep their concrete PTR instead of collapsing into a "*.suffix" pattern.
+func ConsolidateResultsExact(results []LookupResult) []ConsolidatedResult {
+	return consolidateResults(results, true, nil)
+}
+
+// consolidateResults implements ConsolidateResults, skipping the pattern
+// pass for queried IPs if exactOnly is set. If debug is set, it gets a line
+// for each group formed in either pass and each single IP's fate
+// (--debug-consolidation).
+func consolidateResults(results []LookupResult, exactOnly bool, debug io.Writer) []ConsolidatedResult {
+	debugf := func(format string, args ...any) {
+		if debug != nil {
+			fmt.Fprintf(debug, format+"\n", args...)
+		}
+	}
+
 	// Separate errors from non-errors
 	var errors []LookupResult
-	groups := make(map[string][]net.IP) // PTR (or "") -> IPs
+	groups := make(map[string][]net.IP)        // PTR (or "") -> IPs
+	patternGroups := make(map[string][]net.IP) // pattern -> IPs (filled in pass 2)
 
 	for _, r := range results {
 		if r.Error != nil {
 			errors = append(errors, r)
 			continue
 		}
-		groups[r.PTR] = append(groups[r.PTR], r.IP)
+		// A 16-byte or ::ffff:-mapped IPv4 address would otherwise
+		// consolidate as IPv6 (a /127 where the IPv4 form is a /31)
+		r.IP = canonicalIP(r.IP)
+		// lookupIP strips the trailing dot, but results merged from a cache
+		// or another tool may not; group "host." with "host"
+		ptr := strings.TrimSuffix(r.PTR, ".")
+		if r.Inferred {
+			// Inferred PTRs are already patterns; join the pattern pass
+			patternGroups[ptr] = append(patternGroups[ptr], r.IP)
+			continue
+		}
+		groups[ptr] = append(groups[ptr], r.IP)
 	}
 
 	var consolidated []ConsolidatedResult
@@ -279,78 +817,127 @@ func ConsolidateResults(results []LookupResult) []ConsolidatedResult {
 	}
 	var singles []singleEntry
 
-	// Pass 1: Process each exact-PTR group
-	for ptr, ips := range groups {
+	// Pass 1: Process each exact-PTR group, in PTR order so the debug log
+	// is stable
+	for _, ptr := range slices.Sorted(maps.Keys(groups)) {
+		ips := groups[ptr]
 		// Sort IPs within the group
 		sort.Slice(ips, func(i, j int) bool {
 			return bytes.Compare(ips[i], ips[j]) < 0
 		})
 
-		// Deduplicate consecutive duplicates
-		deduped := []net.IP{ips[0]}
-		for i := 1; i < len(ips); i++ {
-			if !ips[i].Equal(ips[i-1]) {
-				deduped = append(deduped, ips[i])
-			}
-		}
+		deduped := dedupeSortedIPs(ips)
 
 		// Single-IP groups with a PTR are candidates for pattern consolidation
 		if len(deduped) == 1 && ptr != "" {
+			debugf("consolidation pass 1: %s is the only IP with %s; trying a pattern", deduped[0], ptr)
 			singles = append(singles, singleEntry{ip: deduped[0], ptr: ptr})
 			continue
 		}
 
+		kind := KindExact
+		if ptr == "" {
+			kind = KindNXDomain
+		}
 		networks := IPsToNetworks(deduped)
+		debugf("consolidation pass 1: %d IPs share %s -> %s", len(deduped), ptrOrNXDomain(ptr), joinNetworks(networks))
 		for _, n := range networks {
 			consolidated = append(consolidated, ConsolidatedResult{
 				Network: n,
 				PTR:     ptr,
+				Kind:    kind,
 			})
 		}
 	}
 
-	// Pass 2: Pattern-based consolidation of single-IP entries
-	patternGroups := make(map[string][]net.IP) // pattern -> IPs
+	// Pass 2: Pattern-based consolidation of single-IP entries. Each PTR
+	// yields one pattern of its own, but it also fits the more generic
+	// patterns other IPs yield ("*.isp.net" for a "*.dsl.isp.net" name).
+	// The longest suffix that more than one IP fits wins.
 	var unmatched []singleEntry
+	own := make([]string, len(singles))
+	yielded := make(map[string]bool) // Patterns some IP yields itself
+	for pattern := range patternGroups {
+		yielded[pattern] = true // Inferred
+	}
+	if !exactOnly {
+		for i, s := range singles {
+			if own[i] = ptrPattern(s.ip, s.ptr); own[i] != "" {
+				yielded[own[i]] = true
+			}
+		}
+	}
+	candidates := make([][]string, len(singles)) // Most specific first
+	fits := make(map[string]int)                 // Pattern -> IPs fitting it
+	for pattern, ips := range patternGroups {
+		fits[pattern] += len(ips)
+	}
+	for i := range singles {
+		if own[i] == "" {
+			continue
+		}
+		for _, pattern := range nestingPatterns(own[i]) {
+			if yielded[pattern] {
+				candidates[i] = append(candidates[i], pattern)
+				fits[pattern]++
+			}
+		}
+	}
 
-	for _, s := range singles {
-		var pattern string
-		if s.ip.To4() != nil {
-			pattern = extractPTRPattern(s.ip, s.ptr)
-		} else {
-			pattern = extractIPv6PTRPattern(s.ip, s.ptr)
+	for i, s := range singles {
+		if own[i] == "" {
+			debugf("consolidation pass 2: %s (%s) matches no pattern; kept as is", s.ip, s.ptr)
+			unmatched = append(unmatched, s)
+			continue
+		}
+		pattern := own[i]
+		for _, c := range candidates[i] {
+			if fits[c] > 1 {
+				pattern = c
+				break
+			}
 		}
-		if pattern != "" {
-			patternGroups[pattern] = append(patternGroups[pattern], s.ip)
+		if pattern != own[i] {
+			debugf("consolidation pass 2: %s (%s) matches %s; no other IP matches %s", s.ip, s.ptr, pattern, own[i])
 		} else {
-			unmatched = append(unmatched, s)
+			debugf("consolidation pass 2: %s (%s) matches %s", s.ip, s.ptr, pattern)
 		}
+		patternGroups[pattern] = append(patternGroups[pattern], s.ip)
 	}
 
-	for pattern, ips := range patternGroups {
+	for _, pattern := range slices.Sorted(maps.Keys(patternGroups)) {
+		ips := patternGroups[pattern]
 		if len(ips) < 2 {
 			// Single-IP pattern group: find the original PTR and keep it
+			// (an inferred IP has none and keeps the pattern)
+			ptr, kind := pattern, KindPattern
 			for _, s := range singles {
 				if s.ip.Equal(ips[0]) {
-					consolidated = append(consolidated, ConsolidatedResult{
-						Network: singleIPNet(s.ip),
-						PTR:     s.ptr,
-					})
+					ptr, kind = s.ptr, KindExact
 					break
 				}
 			}
+			debugf("consolidation pass 2: %s matched only %s; kept as %s", pattern, ips[0], ptr)
+			consolidated = append(consolidated, ConsolidatedResult{
+				Network: singleIPNet(ips[0]),
+				PTR:     ptr,
+				Kind:    kind,
+			})
 			continue
 		}
 
 		sort.Slice(ips, func(i, j int) bool {
 			return bytes.Compare(ips[i], ips[j]) < 0
 		})
+		ips = dedupeSortedIPs(ips)
 
 		networks := IPsToNetworks(ips)
+		debugf("consolidation pass 2: %d IPs match %s -> %s", len(ips), pattern, joinNetworks(networks))
 		for _, n := range networks {
 			consolidated = append(consolidated, ConsolidatedResult{
 				Network: n,
 				PTR:     pattern,
+				Kind:    KindPattern,
 			})
 		}
 	}
@@ -360,6 +947,7 @@ func ConsolidateResults(results []LookupResult) []ConsolidatedResult {
 		consolidated = append(consolidated, ConsolidatedResult{
 			Network: singleIPNet(s.ip),
 			PTR:     s.ptr,
+			Kind:    KindExact,
 		})
 	}
 
@@ -368,17 +956,586 @@ func ConsolidateResults(results []LookupResult) []ConsolidatedResult {
 		consolidated = append(consolidated, ConsolidatedResult{
 			Network: singleIPNet(r.IP),
 			Error:   r.Error,
+			Kind:    KindError,
 		})
 	}
 
-	// Sort all results by network IP
+	// Sort all results by network IP, with ties broken so that map iteration
+	// order above never shows through
 	sort.Slice(consolidated, func(i, j int) bool {
-		return bytes.Compare(consolidated[i].Network.IP, consolidated[j].Network.IP) < 0
+		return consolidatedLess(consolidated[i], consolidated[j])
 	})
 
 	return consolidated
 }
 
+// ptrOrNXDomain returns ptr, or "NXDOMAIN" for the empty PTR.
+func ptrOrNXDomain(ptr string) string {
+	if ptr == "" {
+		return "NXDOMAIN"
+	}
+	return ptr
+}
+
+// joinNetworks returns networks as a space-separated list.
+func joinNetworks(networks []*net.IPNet) string {
+	s := make([]string, len(networks))
+	for i, n := range networks {
+		s[i] = n.String()
+	}
+	return strings.Join(s, " ")
+}
+
+// consolidatedLess orders consolidated entries by network IP, then prefix
+// length, then PTR, then error text, giving a total order.
+func consolidatedLess(a, b ConsolidatedResult) bool {
+	if c := bytes.Compare(a.Network.IP, b.Network.IP); c != 0 {
+		return c < 0
+	}
+	oa, _ := a.Network.Mask.Size()
+	ob, _ := b.Network.Mask.Size()
+	if oa != ob {
+		return oa < ob
+	}
+	if a.PTR != b.PTR {
+		return a.PTR < b.PTR
+	}
+	return errorString(a.Error) < errorString(b.Error)
+}
+
+// errorString returns err's message, or "" for nil.
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// supernetTally summarizes the consolidated entries inside a candidate supernet.
+type supernetTally struct {
+	ptr     string // The only PTR seen, if any
+	matched uint64 // Addresses with that PTR
+	ok      bool   // False if uncovered, errored, or holding multiple PTRs
+}
+
+// tallySupernet counts the consolidated entries inside parent. The entries
+// must be sorted by network IP, as returned by ConsolidateResults.
+func tallySupernet(consolidated []ConsolidatedResult, parent *net.IPNet) supernetTally {
+	last := make(net.IP, len(parent.IP))
+	for i := range last {
+		last[i] = parent.IP[i] | ^parent.Mask[i]
+	}
+
+	start := sort.Search(len(consolidated), func(i int) bool {
+		return bytes.Compare(consolidated[i].Network.IP, parent.IP) >= 0
+	})
+
+	var t supernetTally
+	var covered uint64
+	for _, c := range consolidated[start:] {
+		if bytes.Compare(c.Network.IP, last) > 0 {
+			break
+		}
+		if len(c.Network.IP) != len(parent.IP) {
+			continue
+		}
+		if c.Error != nil {
+			return supernetTally{}
+		}
+		size := networkSize(c.Network)
+		covered += size
+		if c.PTR == "" {
+			continue
+		}
+		if t.ptr != "" && t.ptr != c.PTR {
+			return supernetTally{}
+		}
+		t.ptr = c.PTR
+		t.matched += size
+	}
+
+	t.ok = covered == networkSize(parent)
+	return t
+}
+
+// MergeEmpty merges adjacent NXDOMAIN and error entries into the fewest
+// networks covering them, so a sparse scan isn't a long list of small empty
+// blocks. A merged network that contains any error becomes a NoData entry;
+// one that is all NXDOMAIN stays NXDOMAIN. Entries with a PTR are kept as
+// they are. The input must be sorted by network IP, as returned by
+// ConsolidateResults.
+func MergeEmpty(consolidated []ConsolidatedResult) []ConsolidatedResult {
+	// Each empty address, flagged if it failed rather than had no PTR
+	type emptyIP struct {
+		ip     net.IP
+		failed bool
+	}
+	var empty []emptyIP
+	merged := make([]ConsolidatedResult, 0, len(consolidated))
+	for _, c := range consolidated {
+		if c.PTR != "" && c.Error == nil {
+			merged = append(merged, c)
+			continue
+		}
+		ip := copyIP(c.Network.IP)
+		for n := networkSize(c.Network); n > 0; n-- {
+			empty = append(empty, emptyIP{ip: copyIP(ip), failed: c.Error != nil || c.NoData})
+			incIP(ip)
+		}
+	}
+	if len(empty) == 0 {
+		return consolidated
+	}
+
+	sort.Slice(empty, func(i, j int) bool {
+		return bytes.Compare(empty[i].ip.To16(), empty[j].ip.To16()) < 0
+	})
+	ips := make([]net.IP, len(empty))
+	for i, e := range empty {
+		ips[i] = e.ip
+	}
+
+	// The networks cover the sorted addresses in order, so walk both at once
+	pos := 0
+	for _, n := range IPsToNetworks(ips) {
+		failed := false
+		for end := pos + int(networkSize(n)); pos < end; pos++ {
+			failed = failed || empty[pos].failed
+		}
+		kind := KindNXDomain
+		if failed {
+			kind = KindNoData
+		}
+		merged = append(merged, ConsolidatedResult{Network: n, NoData: failed, Kind: kind})
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		return consolidatedLess(merged[i], merged[j])
+	})
+	return merged
+}
+
+// OnlyPatterns returns the consolidated entries whose PTR is a "*." pattern,
+// dropping exact PTRs, NXDOMAIN, errors, and no-data ranges: a map of the
+// blocks a provider names from a template.
+func OnlyPatterns(consolidated []ConsolidatedResult) []ConsolidatedResult {
+	var patterns []ConsolidatedResult
+	for _, c := range consolidated {
+		if c.Kind == KindPattern {
+			patterns = append(patterns, c)
+		}
+	}
+	return patterns
+}
+
+// CapPrefix splits consolidated entries whose network is shorter than
+// minPrefix into networks of exactly that length, each keeping the entry's
+// PTR and error, so a homogeneous /16 becomes 256 /24s. The input order is
+// kept. A minPrefix of 0 or less returns consolidated unchanged.
+func CapPrefix(consolidated []ConsolidatedResult, minPrefix int) []ConsolidatedResult {
+	if minPrefix <= 0 {
+		return consolidated
+	}
+	capped := make([]ConsolidatedResult, 0, len(consolidated))
+	for _, c := range consolidated {
+		for _, n := range splitToPrefix(c.Network, minPrefix) {
+			part := c
+			part.Network = n
+			capped = append(capped, part)
+		}
+	}
+	return capped
+}
+
+// AggregateResults merges consolidated entries into enclosing supernets when
+// at least threshold (0-1] of a supernet's addresses share one PTR and the
+// rest are NXDOMAIN. A supernet must be fully covered by scanned results, so
+// unscanned space is never claimed; errors and other PTRs block merging.
+// The input must be sorted by network IP, as returned by ConsolidateResults.
+func AggregateResults(consolidated []ConsolidatedResult, threshold float64) []ConsolidatedResult {
+	if threshold <= 0 || len(consolidated) == 0 {
+		return consolidated
+	}
+
+	// Tallies depend only on the supernet, so cache them across entries
+	cache := make(map[string]supernetTally)
+	var chosen []ConsolidatedResult
+
+	for _, c := range consolidated {
+		if c.Error != nil || c.PTR == "" {
+			continue
+		}
+		if len(chosen) > 0 && chosen[len(chosen)-1].Network.Contains(c.Network.IP) {
+			continue // already absorbed
+		}
+
+		// Walk upward, keeping the largest supernet that qualifies.
+		// Uncovered or blocked supernets stay that way at every larger size.
+		var best *net.IPNet
+		ones, _ := c.Network.Mask.Size()
+		for p := ones - 1; p >= 0; p-- {
+			parent := supernet(c.Network, p)
+			size := networkSize(parent)
+			if size == SentinelSize {
+				break
+			}
+			key := parent.String()
+			t, ok := cache[key]
+			if !ok {
+				t = tallySupernet(consolidated, parent)
+				cache[key] = t
+			}
+			if !t.ok {
+				break
+			}
+			if float64(t.matched)/float64(size) >= threshold {
+				best = parent
+			}
+		}
+
+		if best != nil {
+			chosen = append(chosen, ConsolidatedResult{Network: best, PTR: c.PTR, Kind: c.Kind})
+		}
+	}
+
+	if len(chosen) == 0 {
+		return consolidated
+	}
+
+	// The entries and the chosen supernets are both in IP order, and the
+	// supernets don't overlap, so one walk per address length finds the
+	// supernet, if any, absorbing each entry
+	supernets := make(map[int][]*net.IPNet) // IP length -> chosen networks
+	for _, s := range chosen {
+		supernets[len(s.Network.IP)] = append(supernets[len(s.Network.IP)], s.Network)
+	}
+	next := make(map[int]int) // IP length -> first supernet not yet passed
+	aggregated := make([]ConsolidatedResult, 0, len(consolidated))
+	aggregated = append(aggregated, chosen...)
+	for _, c := range consolidated {
+		ipLen := len(c.Network.IP)
+		nets, i := supernets[ipLen], next[ipLen]
+		for i < len(nets) && !nets[i].Contains(c.Network.IP) && bytes.Compare(nets[i].IP, c.Network.IP) < 0 {
+			i++ // Entirely before c
+		}
+		next[ipLen] = i
+		if i < len(nets) && nets[i].Contains(c.Network.IP) {
+			continue // Absorbed
+		}
+		aggregated = append(aggregated, c)
+	}
+
+	sort.Slice(aggregated, func(i, j int) bool {
+		return bytes.Compare(aggregated[i].Network.IP, aggregated[j].Network.IP) < 0
+	})
+
+	return aggregated
+}
+
+// MergeFamilies combines consolidated entries that share a PTR (or pattern)
+// and span both IPv4 and IPv6 into one entry listing every network, for a
+// "this provider owns these blocks" view. Entries whose PTR appears in only
+// one family, NXDOMAIN entries, and errors are left as they are. The merged
+// entry takes the position of its first network.
+func MergeFamilies(consolidated []ConsolidatedResult) []ConsolidatedResult {
+	type families struct{ v4, v6 bool }
+	seen := make(map[string]families)
+	for _, c := range consolidated {
+		if c.PTR == "" || c.Error != nil {
+			continue
+		}
+		f := seen[c.PTR]
+		if _, bits := c.Network.Mask.Size(); bits == 32 {
+			f.v4 = true
+		} else {
+			f.v6 = true
+		}
+		seen[c.PTR] = f
+	}
+
+	merged := make([]ConsolidatedResult, 0, len(consolidated))
+	first := make(map[string]int) // PTR -> index of its merged entry
+	for _, c := range consolidated {
+		f := seen[c.PTR]
+		if c.PTR == "" || c.Error != nil || !f.v4 || !f.v6 {
+			merged = append(merged, c)
+			continue
+		}
+		idx, ok := first[c.PTR]
+		if !ok {
+			first[c.PTR] = len(merged)
+			merged = append(merged, c)
+			continue
+		}
+		mergeInto(&merged[idx], c)
+	}
+	return merged
+}
+
+// GroupByPTR combines all consolidated entries that share a PTR (or
+// pattern) into one entry listing every network, wherever they lie and in
+// either family (--group-patterns). NXDOMAIN entries and errors are left as
+// they are. The grouped entry takes the position of its first network.
+func GroupByPTR(consolidated []ConsolidatedResult) []ConsolidatedResult {
+	grouped := make([]ConsolidatedResult, 0, len(consolidated))
+	first := make(map[string]int) // PTR -> index of its grouped entry
+	for _, c := range consolidated {
+		if c.PTR == "" || c.Error != nil {
+			grouped = append(grouped, c)
+			continue
+		}
+		idx, ok := first[c.PTR]
+		if !ok {
+			first[c.PTR] = len(grouped)
+			grouped = append(grouped, c)
+			continue
+		}
+		mergeInto(&grouped[idx], c)
+	}
+	return grouped
+}
+
+// mergeInto adds c's networks and annotations to m, which shares its PTR.
+func mergeInto(m *ConsolidatedResult, c ConsolidatedResult) {
+	m.Merged = append(m.Merged, c.Network)
+	m.Merged = append(m.Merged, c.Merged...)
+	m.Verified += c.Verified
+	m.Checked += c.Checked
+	m.Inferred += c.Inferred
+	m.Matched += c.Matched
+	m.Members = append(m.Members, c.Members...)
+	for _, src := range c.Sources {
+		if !containsString(m.Sources, src) {
+			m.Sources = append(m.Sources, src)
+		}
+	}
+}
+
+// containsString reports whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// AnnotateVerification sets Verified and Checked on each consolidated entry
+// with a PTR, counting the queried, resolved per-IP results inside its network.
+func AnnotateVerification(consolidated []ConsolidatedResult, results []LookupResult) {
+	resolved := sortedByIP(results, func(r LookupResult) bool {
+		return r.PTR != "" && r.Error == nil && !r.Inferred
+	})
+
+	for i := range consolidated {
+		c := &consolidated[i]
+		if c.PTR == "" || c.Error != nil {
+			continue
+		}
+		for _, r := range within(resolved, c.Network) {
+			c.Checked++
+			if r.Verified {
+				c.Verified++
+			}
+		}
+	}
+}
+
+// AnnotateInferred sets Inferred on each consolidated entry to the number of
+// inferred (not queried) per-IP results inside its network.
+func AnnotateInferred(consolidated []ConsolidatedResult, results []LookupResult) {
+	inferred := sortedByIP(results, func(r LookupResult) bool { return r.Inferred })
+	if len(inferred) == 0 {
+		return
+	}
+	for i := range consolidated {
+		consolidated[i].Inferred = len(within(inferred, consolidated[i].Network))
+	}
+}
+
+// AnnotateMatches sets Matched on each pattern entry ("*.suffix") to the
+// number of queried IPs inside its network whose own PTR yields the pattern.
+// The rest of the network was inferred, or folded in by aggregation without
+// a matching PTR, so a low Matched/size ratio marks a loose pattern.
+func AnnotateMatches(consolidated []ConsolidatedResult, results []LookupResult) {
+	resolved := sortedByIP(results, func(r LookupResult) bool {
+		return r.PTR != "" && r.Error == nil && !r.Inferred
+	})
+	for i := range consolidated {
+		c := &consolidated[i]
+		if !strings.HasPrefix(c.PTR, "*.") {
+			continue
+		}
+		for _, r := range within(resolved, c.Network) {
+			if ptrPattern(r.IP, strings.TrimSuffix(r.PTR, ".")) == c.PTR {
+				c.Matched++
+			}
+		}
+	}
+}
+
+// ptrPattern returns the "*.suffix" pattern of ip's PTR, or "" if the PTR
+// doesn't embed the IP.
+func ptrPattern(ip net.IP, ptr string) string {
+	if ip.To4() != nil {
+		return extractPTRPattern(ip, ptr)
+	}
+	return extractIPv6PTRPattern(ip, ptr)
+}
+
+// nestingPatterns returns pattern and the more generic patterns it nests
+// in, most specific first: "*.dsl.isp.net" gives "*.dsl.isp.net" and
+// "*.isp.net". Like ptrPattern's, each suffix has at least two labels.
+func nestingPatterns(pattern string) []string {
+	patterns := []string{pattern}
+	suffix := strings.TrimPrefix(pattern, "*.")
+	for {
+		_, rest, _ := strings.Cut(suffix, ".")
+		if !strings.Contains(rest, ".") {
+			return patterns
+		}
+		patterns = append(patterns, "*."+rest)
+		suffix = rest
+	}
+}
+
+// patternMatchRatio returns the fraction of the addresses in c's networks that
+// matched its pattern.
+func patternMatchRatio(c ConsolidatedResult) float64 {
+	total, _ := new(big.Float).SetInt(addressCount(append([]*net.IPNet{c.Network}, c.Merged...))).Float64()
+	return float64(c.Matched) / total
+}
+
+// Wildcard detection thresholds: a PTR answered for at least
+// WildcardFraction of at least WildcardMinIPs addresses queried in one target
+// block is probably a zone wildcard.
+const (
+	WildcardFraction = 0.9
+	WildcardMinIPs   = 16
+)
+
+// Wildcard is a target block whose queried addresses nearly all share one
+// PTR.
+type Wildcard struct {
+	Block   string // Target the addresses came from
+	PTR     string
+	Count   int // Addresses answered with PTR
+	Queried int // Addresses queried in Block
+}
+
+// DetectWildcards reports the target blocks, sorted, where one PTR covers
+// nearly all of the queried addresses. One identical name across a whole
+// block usually comes from a wildcard record ("*.2.0.192.in-addr.arpa"), not
+// from real per-host PTRs. Each block is checked on its own, so a wildcarded
+// /24 is found inside a larger scan; sources gives the block of each result
+// by Index, as in ScanPlan.Sources. Inferred results were not queried and
+// are skipped.
+func DetectWildcards(results []LookupResult, sources []string) []Wildcard {
+	queried := make(map[string]int)
+	counts := make(map[string]map[string]int) // Block -> PTR -> addresses
+	for _, r := range results {
+		if r.Inferred {
+			continue
+		}
+		var block string
+		if r.Index >= 0 && r.Index < len(sources) {
+			block = sources[r.Index]
+		}
+		queried[block]++
+		if r.PTR == "" || r.Error != nil {
+			continue
+		}
+		if counts[block] == nil {
+			counts[block] = make(map[string]int)
+		}
+		counts[block][r.PTR]++
+	}
+
+	var found []Wildcard
+	for _, block := range slices.Sorted(maps.Keys(counts)) {
+		n := queried[block]
+		if n < WildcardMinIPs {
+			continue
+		}
+		for ptr, count := range counts[block] {
+			// At most one PTR can reach a fraction above one half
+			if float64(count) >= WildcardFraction*float64(n) {
+				found = append(found, Wildcard{Block: block, PTR: ptr, Count: count, Queried: n})
+			}
+		}
+	}
+	return found
+}
+
+// AnnotateMembers sets Members on each pattern entry ("*.suffix") to the
+// resolved results it covers, in IP order, so the summary can be traced back
+// to the original per-IP PTRs.
+func AnnotateMembers(consolidated []ConsolidatedResult, results []LookupResult) {
+	resolved := sortedByIP(results, func(r LookupResult) bool { return r.PTR != "" && r.Error == nil })
+	for i := range consolidated {
+		c := &consolidated[i]
+		if strings.HasPrefix(c.PTR, "*.") {
+			c.Members = within(resolved, c.Network)
+		}
+	}
+}
+
+// sortedByIP returns the results accepted by keep, sorted by IP.
+func sortedByIP(results []LookupResult, keep func(r LookupResult) bool) []LookupResult {
+	var kept []LookupResult
+	for _, r := range results {
+		if keep(r) {
+			kept = append(kept, r)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool {
+		return bytes.Compare(kept[i].IP.To16(), kept[j].IP.To16()) < 0
+	})
+	return kept
+}
+
+// within returns the results inside n from a slice sorted by sortedByIP.
+func within(sorted []LookupResult, n *net.IPNet) []LookupResult {
+	first := n.IP.To16()
+	start := sort.Search(len(sorted), func(k int) bool {
+		return bytes.Compare(sorted[k].IP.To16(), first) >= 0
+	})
+	end := start
+	for end < len(sorted) && n.Contains(sorted[end].IP) {
+		end++
+	}
+	return sorted[start:end]
+}
+
+// AnnotateSources sets Sources on each consolidated entry to the distinct
+// input CIDRs of the per-IP results inside its network, in input order.
+// Results without a Source are ignored.
+func AnnotateSources(consolidated []ConsolidatedResult, results []LookupResult) {
+	sourced := sortedByIP(results, func(r LookupResult) bool { return r.Source != "" })
+	if len(sourced) == 0 {
+		return
+	}
+
+	for i := range consolidated {
+		c := &consolidated[i]
+		order := make(map[string]int) // source -> lowest input Index seen
+		for _, r := range within(sourced, c.Network) {
+			if idx, ok := order[r.Source]; !ok || r.Index < idx {
+				order[r.Source] = r.Index
+			}
+		}
+
+		c.Sources = nil
+		for src := range order {
+			c.Sources = append(c.Sources, src)
+		}
+		sort.Slice(c.Sources, func(a, b int) bool {
+			return order[c.Sources[a]] < order[c.Sources[b]]
+		})
+	}
+}
+
 // singleIPNet returns a /32 (IPv4) or /128 (IPv6) network for a single IP.
 func singleIPNet(ip net.IP) *net.IPNet {
 	bits := 32
@@ -399,96 +1556,476 @@ func isSingleHost(n *net.IPNet) bool {
 	return ones == bits
 }
 
+// expandIPv6 returns ip as eight zero-padded hex groups without "::"
+// compression, e.g. 2001:0db8:0000:0000:0000:0000:0000:0001.
+func expandIPv6(ip net.IP) string {
+	ip16 := ip.To16()
+	groups := make([]string, 8)
+	for i := 0; i < 8; i++ {
+		groups[i] = fmt.Sprintf("%02x%02x", ip16[i*2], ip16[i*2+1])
+	}
+	return strings.Join(groups, ":")
+}
+
+// ipString formats ip, fully expanding IPv6 addresses if expand is set.
+// IPv4 addresses are unaffected.
+func ipString(ip net.IP, expand bool) string {
+	if expand && ip.To4() == nil && ip.To16() != nil {
+		return expandIPv6(ip)
+	}
+	return ip.String()
+}
+
 // networkString returns a CIDR string, or a plain IP for single hosts.
-func networkString(n *net.IPNet) string {
+// If expand is set, IPv6 addresses are written fully expanded.
+func networkString(n *net.IPNet, expand bool) string {
+	s := ipString(n.IP, expand)
 	if isSingleHost(n) {
-		return n.IP.String()
+		return s
+	}
+	ones, _ := n.Mask.Size()
+	return fmt.Sprintf("%s/%d", s, ones)
+}
+
+// networksString returns the network of r followed by any merged networks,
+// comma-separated.
+func networksString(r ConsolidatedResult, expand bool) string {
+	s := networkString(r.Network, expand)
+	for _, n := range r.Merged {
+		s += "," + networkString(n, expand)
 	}
-	return n.String()
+	return s
 }
 
 // FormatTextConsolidated writes consolidated results in plain text format.
 func FormatTextConsolidated(w io.Writer, results []ConsolidatedResult) error {
+	return formatTextConsolidated(w, results, OutputOptions{})
+}
+
+// formatTextConsolidated is FormatTextConsolidated with display controlled by opts.
+func formatTextConsolidated(w io.Writer, results []ConsolidatedResult, opts OutputOptions) error {
+	rows := consolidatedRows(results, opts)
+
 	// Calculate the maximum network string width for alignment
 	width := 15
-	for _, r := range results {
-		s := networkString(r.Network)
-		if len(s) > width {
-			width = len(s)
-		}
+	for _, row := range rows {
+		width = max(width, len(row[0]))
 	}
 
 	format := fmt.Sprintf("%%-%ds %%s\n", width)
-	for _, r := range results {
-		var err error
-		s := networkString(r.Network)
-		if r.Error != nil {
-			_, err = fmt.Fprintf(w, format, s, "ERROR: "+r.Error.Error())
-		} else if r.PTR != "" {
-			_, err = fmt.Fprintf(w, format, s, r.PTR)
-		} else {
-			_, err = fmt.Fprintf(w, format, s, "NXDOMAIN")
-		}
-		if err != nil {
+	for _, row := range rows {
+		if _, err := fmt.Fprintf(w, format, row[0], row[1]); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// consolidatedRows returns the network and text shown for each consolidated
+// result, followed by its --explain members as indented rows.
+func consolidatedRows(results []ConsolidatedResult, opts OutputOptions) [][2]string {
+	rows := make([][2]string, 0, len(results))
+	for _, r := range results {
+		s := networksString(r, opts.ExpandIPv6)
+		switch {
+		case r.Error != nil:
+			rows = append(rows, [2]string{s, "ERROR: " + r.Error.Error()})
+		case r.NoData:
+			rows = append(rows, [2]string{s, "NO DATA"})
+		case r.PTR != "":
+			ptr := truncatePTR(r.PTR, opts.MaxPTRLength)
+			if r.Checked > 0 {
+				ptr += fmt.Sprintf(" (%d/%d verified)", r.Verified, r.Checked)
+			}
+			if r.Provider != "" {
+				ptr += " (" + r.Provider + ")"
+			}
+			if r.Inferred > 0 {
+				ptr += fmt.Sprintf(" (%d inferred)", r.Inferred)
+			}
+			if opts.MatchRatio && strings.HasPrefix(r.PTR, "*.") {
+				ptr += fmt.Sprintf(" (%d matched, %.0f%%)", r.Matched, 100*patternMatchRatio(r))
+			}
+			if opts.ShowKind && r.Kind != "" {
+				ptr += " (" + r.Kind + ")"
+			}
+			rows = append(rows, [2]string{s, ptr})
+			// --explain: the member IPs behind a pattern, indented
+			for _, m := range r.Members {
+				memberPTR := truncatePTR(m.PTR, opts.MaxPTRLength)
+				if m.Inferred {
+					memberPTR += " (inferred)"
+				}
+				rows = append(rows, [2]string{"  " + ipString(m.IP, opts.ExpandIPv6), memberPTR})
+			}
+		default:
+			rows = append(rows, [2]string{s, "NXDOMAIN"})
+		}
+	}
+	return rows
+}
+
 // ConsolidatedJSONResult is the JSON representation of a consolidated result.
 type ConsolidatedJSONResult struct {
-	Network string  `json:"network"`
-	PTR     *string `json:"ptr"`
-	Error   *string `json:"error,omitempty"`
+	Network      string `json:"network,omitempty"`
+	IP           string `json:"ip,omitempty"`            // Flat schema: network address
+	PrefixLength *int   `json:"prefix_length,omitempty"` // Flat schema: network prefix length
+
+	Count    *big.Int `json:"count"` // Addresses covered, across all networks
+	PTR      *string  `json:"ptr"`
+	Error    *string  `json:"error,omitempty"`
+	Category string   `json:"error_category,omitempty"` // Error category, as in JSONResult
+	NoData   bool     `json:"no_data,omitempty"`        // --merge-empty: NXDOMAIN and errors
+	Kind     string   `json:"kind,omitempty"`           // exact, pattern, nxdomain, error, or nodata
+	Verified *int     `json:"verified,omitempty"`
+	Checked  *int     `json:"checked,omitempty"`
+	Provider *string  `json:"provider,omitempty"`
+	Sources  []string `json:"sources,omitempty"`
+	Networks []string `json:"networks,omitempty"` // All networks, when merged across families
+	Inferred *int     `json:"inferred,omitempty"`
+
+	// --match-ratio, pattern entries only
+	Matched    *int     `json:"matched,omitempty"`
+	MatchRatio *float64 `json:"match_ratio,omitempty"`
+
+	Members []MemberJSONResult `json:"members,omitempty"` // --explain
+}
+
+// MemberJSONResult is one IP behind a consolidated pattern entry.
+type MemberJSONResult struct {
+	IP       string `json:"ip"`
+	PTR      string `json:"ptr"`
+	Inferred bool   `json:"inferred,omitempty"`
 }
 
-// FormatJSONConsolidated writes consolidated results in JSON format.
+// addressCount returns the number of addresses the networks cover together.
+// It is exact even for IPv6 networks too large for CIDRSize.
+func addressCount(networks []*net.IPNet) *big.Int {
+	count := new(big.Int)
+	for _, n := range networks {
+		ones, bits := n.Mask.Size()
+		count.Add(count, new(big.Int).Lsh(big.NewInt(1), uint(bits-ones)))
+	}
+	return count
+}
+
+// FormatJSONConsolidated writes consolidated results in JSON format, sorted
+// by network IP (then prefix length) regardless of input order.
 func FormatJSONConsolidated(w io.Writer, results []ConsolidatedResult) error {
+	return formatJSONConsolidated(w, results, OutputOptions{})
+}
+
+// formatJSONConsolidated is FormatJSONConsolidated with annotations controlled by opts.
+func formatJSONConsolidated(w io.Writer, results []ConsolidatedResult, opts OutputOptions) error {
+	results = append([]ConsolidatedResult(nil), results...)
+	sortConsolidated(results, opts.Descending)
+
 	jsonResults := make([]ConsolidatedJSONResult, len(results))
 
 	for i, r := range results {
-		jr := ConsolidatedJSONResult{Network: networkString(r.Network)}
+		jr := ConsolidatedJSONResult{Network: networkString(r.Network, opts.ExpandIPv6), Sources: r.Sources}
+		if opts.JSONSchema == "flat" {
+			ones, _ := r.Network.Mask.Size()
+			jr.IP = ipString(r.Network.IP, opts.ExpandIPv6)
+			jr.PrefixLength = &ones
+			jr.Network = ""
+		}
+		if len(r.Merged) > 0 || opts.GroupByPTR {
+			if opts.GroupByPTR {
+				jr.Network = "" // the same shape for one network or many
+			}
+			jr.Networks = []string{networkString(r.Network, opts.ExpandIPv6)}
+			for _, n := range r.Merged {
+				jr.Networks = append(jr.Networks, networkString(n, opts.ExpandIPv6))
+			}
+		}
+		jr.Count = addressCount(append([]*net.IPNet{r.Network}, r.Merged...))
+		jr.NoData = r.NoData
+		jr.Kind = r.Kind
 
 		if r.Error != nil {
 			errStr := r.Error.Error()
 			jr.Error = &errStr
+			jr.Category = ErrorCategory(r.Error)
 		} else if r.PTR != "" {
 			jr.PTR = &r.PTR
+			if r.Checked > 0 {
+				jr.Verified = &r.Verified
+				jr.Checked = &r.Checked
+			}
+			if opts.TagProvider {
+				jr.Provider = &r.Provider
+			}
+			if r.Inferred > 0 {
+				jr.Inferred = &r.Inferred
+			}
+			if opts.MatchRatio && strings.HasPrefix(r.PTR, "*.") {
+				ratio := patternMatchRatio(r)
+				jr.Matched = &r.Matched
+				jr.MatchRatio = &ratio
+			}
+			for _, m := range r.Members {
+				jr.Members = append(jr.Members, MemberJSONResult{
+					IP:       ipString(m.IP, opts.ExpandIPv6),
+					PTR:      m.PTR,
+					Inferred: m.Inferred,
+				})
+			}
 		}
 
 		jsonResults[i] = jr
 	}
 
-	encoder := json.NewEncoder(w)
-	encoder.SetIndent("", "  ")
+	encoder := jsonEncoder(w, opts)
 	return encoder.Encode(jsonResults)
 }
 
-// WriteOutput writes results in the specified format.
+// TemplateRecord is the data passed to --format-template for each line.
+// Expanded output fills IP; consolidated output fills Network.
+type TemplateRecord struct {
+	IP       string
+	Network  string
+	PTR      string
+	Error    string
+	Status   string // "resolved", "nxdomain", "error", or "nodata" (--merge-empty)
+	Provider string // Set with --tag-provider
+}
+
+// ParseTemplate parses a per-line output template and checks it against a
+// sample record, so unknown fields are reported before any lookups run.
+// A trailing newline is added if missing.
+func ParseTemplate(text string) (*template.Template, error) {
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	tmpl, err := template.New("line").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid format template: %w", err)
+	}
+	if err := tmpl.Execute(io.Discard, TemplateRecord{}); err != nil {
+		return nil, fmt.Errorf("invalid format template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// templateStatus classifies a result for TemplateRecord.Status.
+func templateStatus(ptr string, err error) (string, string) {
+	switch {
+	case err != nil:
+		return "error", err.Error()
+	case ptr != "":
+		return "resolved", ""
+	default:
+		return "nxdomain", ""
+	}
+}
+
+// FormatTemplate writes one line per result using tmpl. If expand is set,
+// IPv6 addresses are written fully expanded.
+func FormatTemplate(w io.Writer, results []LookupResult, tmpl *template.Template, expand bool) error {
+	for _, r := range results {
+		status, errStr := templateStatus(r.PTR, r.Error)
+		rec := TemplateRecord{IP: ipString(r.IP, expand), PTR: r.PTR, Error: errStr, Status: status, Provider: r.Provider}
+		if err := tmpl.Execute(w, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FormatTemplateConsolidated writes one line per consolidated result using
+// tmpl. If expand is set, IPv6 addresses are written fully expanded.
+func FormatTemplateConsolidated(w io.Writer, results []ConsolidatedResult, tmpl *template.Template, expand bool) error {
+	for _, r := range results {
+		status, errStr := templateStatus(r.PTR, r.Error)
+		if r.NoData {
+			status = "nodata"
+		}
+		rec := TemplateRecord{Network: networksString(r, expand), PTR: r.PTR, Error: errStr, Status: status, Provider: r.Provider}
+		if err := tmpl.Execute(w, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Counts tallies lookup outcomes.
+type Counts struct {
+	Total    int `json:"total"`
+	Resolved int `json:"resolved"`
+	NXDomain int `json:"nxdomain"`
+	Errors   int `json:"errors"`
+}
+
+// CountResults tallies resolved, NXDOMAIN, and errored results.
+func CountResults(results []LookupResult) Counts {
+	var c Counts
+	for _, r := range results {
+		c.add(r)
+	}
+	return c
+}
+
+// add tallies one result.
+func (c *Counts) add(r LookupResult) {
+	c.Total++
+	switch {
+	case r.Error != nil:
+		c.Errors++
+	case r.PTR != "":
+		c.Resolved++
+	default:
+		c.NXDomain++
+	}
+}
+
+// CountQueried is CountResults over only the results that were looked up,
+// leaving out patterns --infer-patterns filled in; it also returns how many
+// of those there were.
+func CountQueried(results []LookupResult) (c Counts, inferred int) {
+	for _, r := range results {
+		if r.Inferred {
+			inferred++
+			continue
+		}
+		c.add(r)
+	}
+	return c, inferred
+}
+
+// WriteCounts writes only the tallies, after filtering, in the given format.
+// Like --metrics-file and --min-resolved-pct, it counts only the results that
+// were looked up; patterns --infer-patterns filled in are reported apart.
+func WriteCounts(w io.Writer, results []LookupResult, opts OutputOptions) error {
+	c, inferred := CountQueried(FilterResults(results, opts))
+
+	if opts.Format == "json" {
+		return jsonEncoder(w, opts).Encode(struct {
+			Counts
+			Inferred int `json:"inferred,omitempty"`
+		}{c, inferred})
+	}
+
+	_, err := fmt.Fprintf(w, "total     %d\nresolved  %d\nnxdomain  %d\nerrors    %d\n",
+		c.Total, c.Resolved, c.NXDomain, c.Errors)
+	if err == nil && inferred > 0 {
+		_, err = fmt.Fprintf(w, "inferred  %d\n", inferred)
+	}
+	return err
+}
+
+// ResolverCheck is the outcome of --check-resolver.
+type ResolverCheck struct {
+	Resolver  string  `json:"resolver"` // As in the run manifest
+	Query     string  `json:"query"`
+	PTR       string  `json:"ptr,omitempty"` // Empty for NXDOMAIN or failure
+	Error     string  `json:"error,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+	OK        bool    `json:"ok"` // The resolver answered (NXDOMAIN counts)
+}
+
+// WriteResolverCheck writes a resolver check as JSON for the JSON formats,
+// else as aligned text.
+func WriteResolverCheck(w io.Writer, c ResolverCheck, format string) error {
+	if isJSONFormat(format) {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(c)
+	}
+
+	answer := c.PTR
+	switch {
+	case !c.OK:
+		answer = "ERROR: " + c.Error
+	case answer == "":
+		answer = "NXDOMAIN"
+	}
+	_, err := fmt.Fprintf(w, "resolver  %s\nquery     %s\nanswer    %s\nlatency   %.1fms\n",
+		c.Resolver, c.Query, answer, c.LatencyMS)
+	return err
+}
+
+// Plan describes the addresses a run would query.
+type Plan struct {
+	Total   *big.Int `json:"total"`   // Addresses in the input CIDRs
+	Queried int      `json:"queried"` // Addresses actually looked up after --max-ips
+}
+
+// isJSONFormat reports whether an --output format is a JSON one, for the
+// reports that otherwise fall back to plain text.
+func isJSONFormat(format string) bool {
+	return format == "json" || format == "ndjson"
+}
+
+// WritePlan writes the dry-run report in the given format.
+func WritePlan(w io.Writer, p Plan, format string) error {
+	if isJSONFormat(format) {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(p)
+	}
+
+	_, err := fmt.Fprintf(w, "total     %s\nqueried   %d\n", p.Total, p.Queried)
+	return err
+}
+
+// WriteOutput writes results in the format registered as opts.Format.
 func WriteOutput(w io.Writer, results []LookupResult, opts OutputOptions) error {
+	writer := lookupOutputWriter(opts.Format)
+	if writer.Summary != nil {
+		return writer.Summary(w, results, opts)
+	}
+
 	// Apply filtering
 	results = FilterResults(results, opts)
 
-	if opts.Expand {
+	if opts.Expand || writer.Consolidated == nil {
 		// Per-IP output (original behavior)
-		if opts.Sort {
-			SortResults(results)
+		if opts.Sort || opts.Descending {
+			SortResultsBy(results, resultOrder(opts))
 		}
-		switch opts.Format {
-		case "json":
-			return FormatJSON(w, results)
-		default:
-			return FormatText(w, results)
+		if opts.Template != nil {
+			return FormatTemplate(w, results, opts.Template, opts.ExpandIPv6)
 		}
+		return writer.Expanded(w, results, opts)
 	}
 
 	// Consolidated output (default)
-	consolidated := ConsolidateResults(results)
-	switch opts.Format {
-	case "json":
-		return FormatJSONConsolidated(w, consolidated)
-	default:
-		return FormatTextConsolidated(w, consolidated)
+	consolidated := consolidateResults(results, opts.PreferExact, opts.ConsolidationLog)
+	if opts.AggregateThreshold > 0 {
+		consolidated = AggregateResults(consolidated, opts.AggregateThreshold)
+	}
+	if opts.MergeEmpty {
+		consolidated = MergeEmpty(consolidated)
+	}
+	if opts.OnlyPatterns {
+		consolidated = OnlyPatterns(consolidated)
+	}
+	consolidated = CapPrefix(consolidated, opts.MinPrefix)
+	if opts.Verify {
+		AnnotateVerification(consolidated, results)
+	}
+	if opts.TagProvider {
+		tagConsolidatedProviders(consolidated)
+	}
+	AnnotateSources(consolidated, results)
+	AnnotateInferred(consolidated, results)
+	if opts.MatchRatio {
+		AnnotateMatches(consolidated, results)
+	}
+	if opts.Explain {
+		AnnotateMembers(consolidated, results)
+	}
+	if opts.GroupByPTR {
+		consolidated = GroupByPTR(consolidated)
+	} else if opts.MergeFamily {
+		consolidated = MergeFamilies(consolidated)
+	}
+	if opts.Descending {
+		sortConsolidated(consolidated, true)
+	}
+	if opts.Template != nil {
+		return FormatTemplateConsolidated(w, consolidated, opts.Template, opts.ExpandIPv6)
 	}
+	return writer.Consolidated(w, consolidated, opts)
 }
@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"maps"
 	"net"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -37,6 +40,16 @@ func TestFilterResults(t *testing.T) {
 			opts:    OutputOptions{NXDomainOnly: true},
 			wantLen: 1, // only 192.168.1.2 (error doesn't count)
 		},
+		{
+			name:    "hide nxdomain",
+			opts:    OutputOptions{HideNXDomain: true},
+			wantLen: 3, // host1, host3, and the error
+		},
+		{
+			name:    "resolved only and hide nxdomain",
+			opts:    OutputOptions{ResolvedOnly: true, HideNXDomain: true},
+			wantLen: 2, // host1 and host3; the error is still not resolved
+		},
 	}
 
 	for _, tt := range tests {
@@ -190,6 +203,73 @@ func TestFormatJSON(t *testing.T) {
 	}
 }
 
+func TestFormatJSONErrorCategory(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("192.0.2.1").To4(), PTR: "host1.example.com"},
+		{IP: net.ParseIP("192.0.2.2").To4(), Error: &net.DNSError{Err: "i/o timeout", IsTimeout: true}},
+		{IP: net.ParseIP("192.0.2.3").To4(), Error: &net.DNSError{Err: "server misbehaving: RCodeRefused"}},
+	}
+	want := map[string]string{"192.0.2.1": "", "192.0.2.2": ErrorTimeout, "192.0.2.3": ErrorRefused}
+
+	for _, expand := range []bool{true, false} {
+		var buf bytes.Buffer
+		if err := WriteOutput(&buf, results, OutputOptions{Format: "json", Expand: expand}); err != nil {
+			t.Fatalf("WriteOutput error: %v", err)
+		}
+		var entries []struct {
+			IP       string  `json:"ip"`
+			Network  string  `json:"network"`
+			Error    *string `json:"error"`
+			Category string  `json:"error_category"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &entries); err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != len(want) {
+			t.Fatalf("expand=%v: got %d entries, want %d", expand, len(entries), len(want))
+		}
+		for _, e := range entries {
+			ip := e.IP + e.Network
+			if e.Category != want[ip] {
+				t.Errorf("expand=%v: %s: error_category = %q, want %q", expand, ip, e.Category, want[ip])
+			}
+			if (e.Error != nil) != (want[ip] != "") {
+				t.Errorf("expand=%v: %s: error = %v alongside category %q", expand, ip, e.Error, e.Category)
+			}
+		}
+	}
+}
+
+func TestFormatJSONSorted(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("192.168.1.10")},
+		{IP: net.ParseIP("192.168.1.2")},
+		{IP: net.ParseIP("10.0.0.1")},
+	}
+
+	var buf bytes.Buffer
+	if err := FormatJSON(&buf, results); err != nil {
+		t.Fatalf("FormatJSON error: %v", err)
+	}
+
+	var jsonResults []JSONResult
+	if err := json.Unmarshal(buf.Bytes(), &jsonResults); err != nil {
+		t.Fatalf("failed to parse JSON output: %v", err)
+	}
+
+	want := []string{"10.0.0.1", "192.168.1.2", "192.168.1.10"}
+	for i, w := range want {
+		if jsonResults[i].IP != w {
+			t.Errorf("jsonResults[%d].IP = %s, want %s", i, jsonResults[i].IP, w)
+		}
+	}
+
+	// Input order must be left alone
+	if results[0].IP.String() != "192.168.1.10" {
+		t.Errorf("FormatJSON reordered its input: first = %s", results[0].IP)
+	}
+}
+
 func TestWriteOutput(t *testing.T) {
 	results := []LookupResult{
 		{IP: net.ParseIP("192.168.1.10")},
@@ -229,6 +309,106 @@ func TestWriteOutput(t *testing.T) {
 	})
 }
 
+func TestWriteOutputDescending(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("10.0.0.4").To4(), PTR: "b.example.com"},
+		{IP: net.ParseIP("10.0.0.0").To4(), PTR: "a.example.com"},
+		{IP: net.ParseIP("10.0.0.5").To4(), PTR: "b.example.com"},
+		{IP: net.ParseIP("10.0.0.1").To4(), PTR: "a.example.com"},
+		{IP: net.ParseIP("10.0.0.9").To4()},
+	}
+
+	firstColumn := func(out string) string {
+		var col []string
+		for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+			col = append(col, strings.Fields(line)[0])
+		}
+		return strings.Join(col, " ")
+	}
+
+	tests := []struct {
+		name   string
+		expand bool
+		want   string
+	}{
+		{"expanded", true, "10.0.0.9 10.0.0.5 10.0.0.4 10.0.0.1 10.0.0.0"},
+		{"consolidated", false, "10.0.0.9 10.0.0.4/31 10.0.0.0/31"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			opts := OutputOptions{Format: "text", Expand: tt.expand, Descending: true}
+			if err := WriteOutput(&buf, results, opts); err != nil {
+				t.Fatalf("WriteOutput error: %v", err)
+			}
+			if got := firstColumn(buf.String()); got != tt.want {
+				t.Errorf("order = %s, want %s", got, tt.want)
+			}
+
+			// JSON sorts on its own and must keep the direction
+			buf.Reset()
+			opts.Format = "json"
+			if err := WriteOutput(&buf, results, opts); err != nil {
+				t.Fatalf("WriteOutput error: %v", err)
+			}
+			var entries []struct {
+				IP      string `json:"ip"`
+				Network string `json:"network"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &entries); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.IP+e.Network)
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("JSON order = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortResultsByPTR(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("10.0.0.6").To4(), Error: errors.New("timeout")},
+		{IP: net.ParseIP("10.0.0.5").To4(), PTR: "b.example.com"},
+		{IP: net.ParseIP("10.0.0.4").To4()},
+		{IP: net.ParseIP("10.0.0.3").To4(), PTR: "a.example.com"},
+		{IP: net.ParseIP("10.0.0.2").To4(), Error: errors.New("timeout")},
+		{IP: net.ParseIP("10.0.0.1").To4(), PTR: "b.example.com"},
+		{IP: net.ParseIP("10.0.0.0").To4()},
+	}
+	want := "10.0.0.3 10.0.0.1 10.0.0.5 10.0.0.0 10.0.0.4 10.0.0.2 10.0.0.6"
+
+	sorted := append([]LookupResult(nil), results...)
+	SortResultsBy(sorted, ByPTR)
+	var got []string
+	for _, r := range sorted {
+		got = append(got, r.IP.String())
+	}
+	if strings.Join(got, " ") != want {
+		t.Errorf("order = %s, want %s", strings.Join(got, " "), want)
+	}
+
+	// JSON sorts on its own and must follow the key too
+	var buf bytes.Buffer
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "json", Expand: true, Sort: true, SortBy: "ptr"}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	var entries []JSONResult
+	if err := json.Unmarshal(buf.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	got = got[:0]
+	for _, e := range entries {
+		got = append(got, e.IP)
+	}
+	if strings.Join(got, " ") != want {
+		t.Errorf("JSON order = %s, want %s", strings.Join(got, " "), want)
+	}
+}
+
 func TestConsolidateResults(t *testing.T) {
 	results := []LookupResult{
 		{IP: net.ParseIP("10.0.0.0").To4(), PTR: "host.example.com"},
@@ -368,6 +548,148 @@ func TestFormatJSONConsolidated(t *testing.T) {
 	if jsonResults[2].Error == nil {
 		t.Error("error = nil, want error")
 	}
+
+	for i, want := range []int64{4, 1, 1} {
+		if c := jsonResults[i].Count; c == nil || c.Int64() != want {
+			t.Errorf("results[%d] count = %v, want %d", i, c, want)
+		}
+	}
+}
+
+func TestFormatJSONConsolidatedCount(t *testing.T) {
+	consolidated := []ConsolidatedResult{
+		// Merged across families: 4 IPv4 + 2^64 IPv6 addresses
+		{
+			Network: mustParseCIDR("192.0.2.0/30"),
+			PTR:     "host.example.com",
+			Merged:  []*net.IPNet{mustParseCIDR("2001:db8::/64")},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := FormatJSONConsolidated(&buf, consolidated); err != nil {
+		t.Fatalf("FormatJSONConsolidated error: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"count": 18446744073709551620`) {
+		t.Errorf("want exact count of merged networks, got:\n%s", buf.String())
+	}
+}
+
+func TestConsolidateResultsKind(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("10.0.0.0").To4(), PTR: "host.example.com"},
+		{IP: net.ParseIP("10.0.0.1").To4(), PTR: "host.example.com"},
+		{IP: net.ParseIP("10.0.0.2").To4(), PTR: "10-0-0-2.dyn.example.net"},
+		{IP: net.ParseIP("10.0.0.3").To4(), PTR: "10-0-0-3.dyn.example.net"},
+		{IP: net.ParseIP("10.0.0.4").To4(), PTR: "10-0-0-4.static.example.net"}, // alone: keeps its name
+		{IP: net.ParseIP("10.0.0.5").To4()},
+		{IP: net.ParseIP("10.0.0.6").To4(), Error: errors.New("timeout")},
+		{IP: net.ParseIP("10.0.1.9").To4(), PTR: "*.inferred.example.net", Inferred: true},
+	}
+
+	got := make(map[string]string)
+	for _, c := range ConsolidateResults(results) {
+		got[c.Network.String()] = c.Kind
+	}
+	want := map[string]string{
+		"10.0.0.0/31": KindExact,
+		"10.0.0.2/31": KindPattern,
+		"10.0.0.4/32": KindExact,
+		"10.0.0.5/32": KindNXDomain,
+		"10.0.0.6/32": KindError,
+		"10.0.1.9/32": KindPattern,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("kinds = %v, want %v", got, want)
+	}
+
+	merged := MergeEmpty(ConsolidateResults([]LookupResult{
+		{IP: net.ParseIP("10.0.0.6").To4()},
+		{IP: net.ParseIP("10.0.0.7").To4(), Error: errors.New("timeout")},
+	}))
+	if len(merged) != 1 || merged[0].Kind != KindNoData {
+		t.Errorf("MergeEmpty = %+v, want one nodata entry", merged)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOutput(&buf, results[:4], OutputOptions{Format: "text", ShowKind: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	for _, want := range []string{"host.example.com (exact)", "*.dyn.example.net (pattern)"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("text output missing %q:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "json"}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	var entries []ConsolidatedJSONResult
+	if err := json.Unmarshal(buf.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Kind != want[e.Network+"/32"] && e.Kind != want[e.Network] {
+			t.Errorf("%s: JSON kind = %q", e.Network, e.Kind)
+		}
+	}
+}
+
+func TestConsolidationLog(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("10.0.0.0").To4()},
+		{IP: net.ParseIP("10.0.0.1").To4(), PTR: "10-0-0-1.dyn.example.net"},
+		{IP: net.ParseIP("10.0.0.2").To4(), PTR: "10-0-0-2.dyn.example.net"},
+		{IP: net.ParseIP("10.0.0.3").To4(), PTR: "mail.example.com"},
+		{IP: net.ParseIP("10.0.0.4").To4(), PTR: "web.example.com"},
+		{IP: net.ParseIP("10.0.0.5").To4(), PTR: "web.example.com"},
+		{IP: net.ParseIP("10.0.0.6").To4(), PTR: "10-0-0-6.static.example.org"},
+	}
+
+	var log, plain, logged bytes.Buffer
+	if err := WriteOutput(&plain, results, OutputOptions{Format: "text"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteOutput(&logged, results, OutputOptions{Format: "text", ConsolidationLog: &log}); err != nil {
+		t.Fatal(err)
+	}
+	if plain.String() != logged.String() {
+		t.Errorf("output changed by logging:\n%s\nvs\n%s", logged.String(), plain.String())
+	}
+
+	for _, want := range []string{
+		"consolidation pass 1: 2 IPs share web.example.com -> 10.0.0.4/31\n",
+		"consolidation pass 1: 10.0.0.3 is the only IP with mail.example.com; trying a pattern\n",
+		"consolidation pass 2: 10.0.0.1 (10-0-0-1.dyn.example.net) matches *.dyn.example.net\n",
+		"consolidation pass 2: 10.0.0.3 (mail.example.com) matches no pattern; kept as is\n",
+		"consolidation pass 2: 2 IPs match *.dyn.example.net -> 10.0.0.1/32 10.0.0.2/32\n",
+		"consolidation pass 2: *.static.example.org matched only 10.0.0.6; kept as 10-0-0-6.static.example.org\n",
+	} {
+		if !strings.Contains(log.String(), want) {
+			t.Errorf("log missing %q:\n%s", want, log.String())
+		}
+	}
+}
+
+func TestOnlyPatterns(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("10.0.0.0").To4(), PTR: "host.example.com"},
+		{IP: net.ParseIP("10.0.0.1").To4(), PTR: "host.example.com"},
+		{IP: net.ParseIP("10.0.0.2").To4(), PTR: "10-0-0-2.dyn.example.net"},
+		{IP: net.ParseIP("10.0.0.3").To4(), PTR: "10-0-0-3.dyn.example.net"},
+		{IP: net.ParseIP("10.0.0.5").To4()},
+		{IP: net.ParseIP("10.0.0.6").To4(), Error: errors.New("timeout")},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "text", OnlyPatterns: true, MergeEmpty: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	want := "10.0.0.2/31     *.dyn.example.net\n"
+	if buf.String() != want {
+		t.Errorf("output = %q, want %q", buf.String(), want)
+	}
 }
 
 func TestWriteOutputConsolidated(t *testing.T) {
@@ -524,6 +846,142 @@ func TestConsolidateResultsWithPatterns(t *testing.T) {
 	}
 }
 
+func TestConsolidateResultsNestedPatterns(t *testing.T) {
+	block := func(ptrs ...string) []LookupResult {
+		results := make([]LookupResult, len(ptrs))
+		for i, ptr := range ptrs {
+			results[i] = LookupResult{IP: net.IPv4(192, 0, 2, byte(i)).To4(), PTR: ptr}
+		}
+		return results
+	}
+
+	tests := []struct {
+		name    string
+		results []LookupResult
+		want    string
+	}{
+		{
+			// The *.dsl.isp.net names also fit *.isp.net, but the longer
+			// suffix wins
+			"specific pattern wins",
+			block(
+				"0.2.0.192.dsl.isp.net", "1.2.0.192.dsl.isp.net",
+				"192-0-2-2.isp.net", "192-0-2-3.isp.net",
+				"cust-192-0-2-4.dsl.isp.net", "cust-192-0-2-5.dsl.isp.net",
+			),
+			"192.0.2.0/31 *.dsl.isp.net, 192.0.2.2/31 *.isp.net, 192.0.2.4/31 *.dsl.isp.net",
+		},
+		{
+			// Alone in *.dsl.isp.net, 192.0.2.4 joins the generic pattern
+			"lone specific name joins generic pattern",
+			block(
+				"192-0-2-0.isp.net", "192-0-2-1.isp.net", "192-0-2-2.isp.net", "192-0-2-3.isp.net",
+				"cust-192-0-2-4.dsl.isp.net",
+				"192-0-2-5.isp.net", "192-0-2-6.isp.net", "192-0-2-7.isp.net",
+			),
+			"192.0.2.0/29 *.isp.net",
+		},
+		{
+			// A generic pattern no IP yields is never made up
+			"no generic pattern yielded",
+			block("192-0-2-0.other.net", "192-0-2-1.other.net", "cust-192-0-2-2.dsl.isp.net"),
+			"192.0.2.0/31 *.other.net, 192.0.2.2/32 cust-192-0-2-2.dsl.isp.net",
+		},
+	}
+
+	for _, tt := range tests {
+		for _, order := range []string{"forward", "reversed"} {
+			results := slices.Clone(tt.results)
+			if order == "reversed" {
+				slices.Reverse(results)
+			}
+			var got []string
+			for _, c := range ConsolidateResults(results) {
+				got = append(got, c.Network.String()+" "+c.PTR)
+			}
+			if strings.Join(got, ", ") != tt.want {
+				t.Errorf("%s, %s: got %v, want %s", tt.name, order, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestConsolidateResultsTrailingDot(t *testing.T) {
+	// Mixed dotted and undotted names, as from a cache or merged sources
+	results := []LookupResult{
+		{IP: net.ParseIP("192.0.2.0").To4(), PTR: "host.example.com."},
+		{IP: net.ParseIP("192.0.2.1").To4(), PTR: "host.example.com"},
+		{IP: net.ParseIP("192.0.2.2").To4(), PTR: "host.example.com."},
+		{IP: net.ParseIP("192.0.2.3").To4(), PTR: "host.example.com"},
+		{IP: net.ParseIP("192.0.2.4").To4(), PTR: "4.2.0.192.static.isp.net."},
+		{IP: net.ParseIP("192.0.2.5").To4(), PTR: "5.2.0.192.static.isp.net"},
+	}
+
+	consolidated := ConsolidateResults(results)
+
+	var got []string
+	for _, c := range consolidated {
+		got = append(got, c.Network.String()+" "+c.PTR)
+	}
+	want := []string{"192.0.2.0/30 host.example.com", "192.0.2.4/31 *.static.isp.net"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestConsolidateResultsIPv4Mapped(t *testing.T) {
+	// 16-byte and ::ffff:-mapped forms of IPv4 consolidate as IPv4
+	results := []LookupResult{
+		{IP: net.ParseIP("192.0.2.0"), PTR: "host.example.com"},
+		{IP: net.ParseIP("::ffff:192.0.2.1"), PTR: "host.example.com"},
+		{IP: net.ParseIP("192.0.2.2").To4(), PTR: "host.example.com"},
+		{IP: net.ParseIP("::ffff:192.0.2.3"), PTR: "host.example.com"},
+	}
+
+	consolidated := ConsolidateResults(results)
+	if len(consolidated) != 1 || consolidated[0].Network.String() != "192.0.2.0/30" {
+		var got []string
+		for _, c := range consolidated {
+			got = append(got, c.Network.String())
+		}
+		t.Errorf("networks = %v, want [192.0.2.0/30]", got)
+	}
+}
+
+func TestConsolidateResultsDeterministic(t *testing.T) {
+	// Duplicate IPs from merged runs collide on network IP: the same address
+	// resolved, errored, and with a second name
+	results := []LookupResult{
+		{IP: net.ParseIP("192.0.2.1").To4(), PTR: "b.example.com"},
+		{IP: net.ParseIP("192.0.2.1").To4(), PTR: "a.example.com"},
+		{IP: net.ParseIP("192.0.2.1").To4(), Error: errors.New("timeout")},
+		{IP: net.ParseIP("192.0.2.1").To4(), Error: errors.New("refused")},
+		{IP: net.ParseIP("192.0.2.2").To4(), PTR: "2.2.0.192.isp.net"},
+		{IP: net.ParseIP("192.0.2.3").To4(), PTR: "3.2.0.192.isp.net"},
+		{IP: net.ParseIP("192.0.2.0").To4()},
+	}
+
+	summarize := func(consolidated []ConsolidatedResult) string {
+		var parts []string
+		for _, c := range consolidated {
+			parts = append(parts, fmt.Sprintf("%s=%s/%v", c.Network, c.PTR, c.Error))
+		}
+		return strings.Join(parts, " ")
+	}
+
+	first := summarize(ConsolidateResults(results))
+	for range 50 {
+		if got := summarize(ConsolidateResults(results)); got != first {
+			t.Fatalf("consolidation not deterministic:\n%s\n%s", first, got)
+		}
+	}
+	want := "192.0.2.0/32=/<nil> 192.0.2.1/32=/refused 192.0.2.1/32=/timeout " +
+		"192.0.2.1/32=a.example.com/<nil> 192.0.2.1/32=b.example.com/<nil> 192.0.2.2/31=*.isp.net/<nil>"
+	if first != want {
+		t.Errorf("got  %s\nwant %s", first, want)
+	}
+}
+
 func TestConsolidateResultsPatternThreshold(t *testing.T) {
 	// A single IP with a pattern-matching PTR should keep its exact PTR
 	results := []LookupResult{
@@ -561,7 +1019,7 @@ func TestConsolidateResultsMixedPatternAndExact(t *testing.T) {
 	if len(consolidated) != 3 {
 		var lines []string
 		for _, c := range consolidated {
-			lines = append(lines, networkString(c.Network)+" "+c.PTR)
+			lines = append(lines, networkString(c.Network, false)+" "+c.PTR)
 		}
 		t.Fatalf("got %d results %v, want 3", len(consolidated), lines)
 	}
@@ -751,11 +1209,1422 @@ func TestConsolidateResultsMixedIPVersions(t *testing.T) {
 	}
 }
 
-// mustParseCIDR parses a CIDR string or panics.
-func mustParseCIDR(s string) *net.IPNet {
-	_, n, err := net.ParseCIDR(s)
+func TestCountResults(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("10.0.0.1"), PTR: "a.example.com"},
+		{IP: net.ParseIP("10.0.0.2"), PTR: "b.example.com"},
+		{IP: net.ParseIP("10.0.0.3")},
+		{IP: net.ParseIP("10.0.0.4"), Error: errors.New("timeout")},
+	}
+
+	got := CountResults(results)
+	want := Counts{Total: 4, Resolved: 2, NXDomain: 1, Errors: 1}
+	if got != want {
+		t.Errorf("CountResults = %+v, want %+v", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCounts(&buf, results, OutputOptions{Format: "json"}); err != nil {
+		t.Fatalf("WriteCounts error: %v", err)
+	}
+	var decoded Counts
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("failed to parse JSON: %v\noutput: %s", err, buf.String())
+	}
+	if decoded != want {
+		t.Errorf("JSON counts = %+v, want %+v", decoded, want)
+	}
+
+	// Inferred patterns were never looked up
+	withInferred := append(slices.Clone(results), LookupResult{IP: net.ParseIP("10.0.0.5"), PTR: "*.example.com", Inferred: true})
+	if got, inferred := CountQueried(withInferred); got != want || inferred != 1 {
+		t.Errorf("CountQueried = %+v, %d; want %+v, 1", got, inferred, want)
+	}
+
+	buf.Reset()
+	if err := WriteCounts(&buf, results, OutputOptions{Format: "text"}); err != nil {
+		t.Fatalf("WriteCounts error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "resolved  2") || strings.Contains(buf.String(), "a.example.com") || strings.Contains(buf.String(), "inferred") {
+		t.Errorf("unexpected text counts:\n%s", buf.String())
+	}
+
+	// --count agrees with --metrics-file: inferred results go on their own line
+	buf.Reset()
+	if err := WriteCounts(&buf, withInferred, OutputOptions{Format: "text"}); err != nil {
+		t.Fatalf("WriteCounts error: %v", err)
+	}
+	if wantText := "total     4\nresolved  2\nnxdomain  1\nerrors    1\ninferred  1\n"; buf.String() != wantText {
+		t.Errorf("text counts with inferred = %q, want %q", buf.String(), wantText)
+	}
+	buf.Reset()
+	if err := WriteCounts(&buf, withInferred, OutputOptions{Format: "json"}); err != nil {
+		t.Fatalf("WriteCounts error: %v", err)
+	}
+	var withField struct {
+		Counts
+		Inferred int `json:"inferred"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &withField); err != nil {
+		t.Fatalf("failed to parse JSON: %v\noutput: %s", err, buf.String())
+	}
+	if withField.Counts != want || withField.Inferred != 1 {
+		t.Errorf("JSON counts with inferred = %+v, want %+v and 1 inferred", withField, want)
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	if _, err := ParseTemplate("{{.IP}},{{.PTR}}"); err != nil {
+		t.Errorf("valid template rejected: %v", err)
+	}
+	if _, err := ParseTemplate("{{.IP"); err == nil {
+		t.Error("expected syntax error")
+	}
+	if _, err := ParseTemplate("{{.Hostname}}"); err == nil {
+		t.Error("expected error for unknown field")
+	}
+}
+
+func TestFormatTemplate(t *testing.T) {
+	tmpl, err := ParseTemplate("{{.IP}},{{.PTR}},{{.Status}},{{.Error}}")
 	if err != nil {
-		panic(err)
+		t.Fatalf("ParseTemplate error: %v", err)
+	}
+
+	results := []LookupResult{
+		{IP: net.ParseIP("10.0.0.1"), PTR: "host.example.com"},
+		{IP: net.ParseIP("10.0.0.2")},
+		{IP: net.ParseIP("10.0.0.3"), Error: errors.New("timeout")},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOutput(&buf, results, OutputOptions{Expand: true, Template: tmpl}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+
+	want := "10.0.0.1,host.example.com,resolved,\n10.0.0.2,,nxdomain,\n10.0.0.3,,error,timeout\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestFormatTemplateExpandIPv6(t *testing.T) {
+	tmpl, err := ParseTemplate("{{.IP}} {{.PTR}}")
+	if err != nil {
+		t.Fatalf("ParseTemplate error: %v", err)
+	}
+	results := []LookupResult{
+		{IP: net.ParseIP("2001:db8::1"), PTR: "v6.example.com"},
+		{IP: net.ParseIP("10.0.0.1"), PTR: "v4.example.com"},
+	}
+
+	tests := []struct {
+		expand bool
+		want   string
+	}{
+		{false, "2001:db8::1 v6.example.com\n10.0.0.1 v4.example.com\n"},
+		{true, "2001:0db8:0000:0000:0000:0000:0000:0001 v6.example.com\n10.0.0.1 v4.example.com\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteOutput(&buf, results, OutputOptions{Expand: true, Template: tmpl, ExpandIPv6: tt.expand}); err != nil {
+			t.Fatalf("WriteOutput error: %v", err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("ExpandIPv6=%v: got %q, want %q", tt.expand, buf.String(), tt.want)
+		}
+	}
+
+	// Consolidated networks too
+	tmpl, err = ParseTemplate("{{.Network}}")
+	if err != nil {
+		t.Fatalf("ParseTemplate error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteOutput(&buf, results[:1], OutputOptions{Template: tmpl, ExpandIPv6: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	if want := "2001:0db8:0000:0000:0000:0000:0000:0001\n"; buf.String() != want {
+		t.Errorf("consolidated: got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestFormatTemplateConsolidated(t *testing.T) {
+	tmpl, err := ParseTemplate("{{.Network}} {{.PTR}}")
+	if err != nil {
+		t.Fatalf("ParseTemplate error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	results := mixedBlock("host.example.com")
+	if err := WriteOutput(&buf, results, OutputOptions{Template: tmpl}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+
+	if buf.String() != "10.0.0.0/28 host.example.com\n" {
+		t.Errorf("got %q", buf.String())
+	}
+}
+
+func TestWriteOutputVerify(t *testing.T) {
+	results := mixedBlock("host.example.com", 15)
+	for i := 0; i < 12; i++ {
+		results[i].Verified = true
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "text", Verify: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	// 15 resolved IPs split across /29, /30, /31, /32; the /29 is all verified
+	if !strings.Contains(buf.String(), "10.0.0.0/29") || !strings.Contains(buf.String(), "(8/8 verified)") {
+		t.Errorf("missing verified suffix:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "(0/2 verified)") {
+		t.Errorf("missing unverified /31 counts:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "json", Verify: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	var jsonResults []ConsolidatedJSONResult
+	if err := json.Unmarshal(buf.Bytes(), &jsonResults); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
+	}
+	first := jsonResults[0]
+	if first.Verified == nil || first.Checked == nil || *first.Verified != 8 || *first.Checked != 8 {
+		t.Errorf("first entry verified/checked = %v/%v, want 8/8", first.Verified, first.Checked)
+	}
+	last := jsonResults[len(jsonResults)-1]
+	if last.Verified != nil || last.Checked != nil {
+		t.Errorf("NXDOMAIN entry should have no verification counts: %+v", last)
+	}
+}
+
+func TestWriteOutputVerifyExpanded(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("10.0.0.1"), PTR: "a.example.com", Verified: true},
+		{IP: net.ParseIP("10.0.0.2"), PTR: "b.example.com"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "text", Expand: true, Verify: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "a.example.com (verified)") || !strings.Contains(buf.String(), "b.example.com (unverified)") {
+		t.Errorf("missing verification status:\n%s", buf.String())
+	}
+
+	// Without Verify, no annotation
+	buf.Reset()
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "text", Expand: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	if strings.Contains(buf.String(), "verified") {
+		t.Errorf("unexpected verification status without Verify:\n%s", buf.String())
+	}
+}
+
+func TestFormatJSONDualStack(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("10.0.0.1"), PTR: "a.example.com", Families: []string{"ipv4", "ipv6"}},
+		{IP: net.ParseIP("10.0.0.2"), PTR: "b.example.com"},
+		{IP: net.ParseIP("10.0.0.3")},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "json", Expand: true, DualStack: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+
+	var decoded []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
+	}
+	if fam, ok := decoded[0]["families"].([]any); !ok || len(fam) != 2 {
+		t.Errorf("families[0] = %v, want [ipv4 ipv6]", decoded[0]["families"])
+	}
+	if fam, ok := decoded[1]["families"].([]any); !ok || len(fam) != 0 {
+		t.Errorf("families[1] = %v, want []", decoded[1]["families"])
+	}
+	if _, ok := decoded[2]["families"]; ok {
+		t.Error("NXDOMAIN entry should have no families field")
+	}
+}
+
+func TestWriteOutputCNAMEChain(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("192.0.2.130"), PTR: "host.example.com", CNAMEs: []string{"130.128-26.2.0.192.in-addr.arpa"}},
+		{IP: net.ParseIP("192.0.2.131"), PTR: "plain.example.com"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "text", Expand: true, CNAMEChain: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "host.example.com (via 130.128-26.2.0.192.in-addr.arpa)") {
+		t.Errorf("missing CNAME chain:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "plain.example.com (via") {
+		t.Errorf("chain shown for direct PTR:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "json", Expand: true, CNAMEChain: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	var jsonResults []JSONResult
+	if err := json.Unmarshal(buf.Bytes(), &jsonResults); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
+	}
+	if len(jsonResults[0].CNAMEs) != 1 || len(jsonResults[1].CNAMEs) != 0 {
+		t.Errorf("cname_chain = %v / %v", jsonResults[0].CNAMEs, jsonResults[1].CNAMEs)
+	}
+
+	// Default output is unchanged
+	buf.Reset()
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "text", Expand: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	if strings.Contains(buf.String(), "via") {
+		t.Errorf("chain shown without CNAMEChain:\n%s", buf.String())
+	}
+}
+
+func TestWriteOutputDNSSEC(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("192.0.2.1"), PTR: "a.example.com", Signed: true, AuthenticData: true},
+		{IP: net.ParseIP("192.0.2.2"), PTR: "b.example.com", Signed: true},
+		{IP: net.ParseIP("192.0.2.3"), PTR: "c.example.com"},
+		{IP: net.ParseIP("192.0.2.4")},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "text", Expand: true, DNSSEC: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{"a.example.com (dnssec: validated)", "b.example.com (dnssec: signed)", "c.example.com (dnssec: unsigned)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "NXDOMAIN (") {
+		t.Errorf("DNSSEC shown for NXDOMAIN:\n%s", out)
+	}
+
+	buf.Reset()
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "json", Expand: true, DNSSEC: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	var jsonResults []JSONResult
+	if err := json.Unmarshal(buf.Bytes(), &jsonResults); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
+	}
+	if r := jsonResults[1]; r.Signed == nil || !*r.Signed || r.Validated == nil || *r.Validated {
+		t.Errorf("signed-only entry: signed = %v, ad = %v", r.Signed, r.Validated)
+	}
+	if jsonResults[3].Signed != nil {
+		t.Error("NXDOMAIN entry should have no DNSSEC fields")
+	}
+}
+
+func TestWriteOutputAuthority(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("192.0.2.1"), PTR: "ns.example.com", Authoritative: true},
+		{IP: net.ParseIP("192.0.2.2"), PTR: "cached.example.com"},
+		{IP: net.ParseIP("192.0.2.3")},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "text", Expand: true, Authority: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "ns.example.com (authoritative)") || !strings.Contains(out, "cached.example.com (non-authoritative)") {
+		t.Errorf("missing authority annotations:\n%s", out)
+	}
+	if strings.Contains(out, "NXDOMAIN (") {
+		t.Errorf("authority shown for NXDOMAIN:\n%s", out)
+	}
+
+	buf.Reset()
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "json", Expand: true, Authority: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	var jsonResults []JSONResult
+	if err := json.Unmarshal(buf.Bytes(), &jsonResults); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
+	}
+	if jsonResults[0].Authority == nil || !*jsonResults[0].Authority {
+		t.Errorf("authoritative = %v, want true", jsonResults[0].Authority)
+	}
+	if jsonResults[1].Authority == nil || *jsonResults[1].Authority {
+		t.Errorf("authoritative = %v, want false", jsonResults[1].Authority)
+	}
+	if jsonResults[2].Authority != nil {
+		t.Error("NXDOMAIN entry should have no authoritative field")
+	}
+
+	// Default output is unchanged
+	buf.Reset()
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "text", Expand: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	if strings.Contains(buf.String(), "authoritative") {
+		t.Errorf("authority shown without Authority:\n%s", buf.String())
+	}
+}
+
+func TestStreamNDJSONOrdered(t *testing.T) {
+	ch := make(chan LookupResult, 4)
+	// Completions arrive out of order
+	ch <- LookupResult{IP: net.ParseIP("10.0.0.3"), Index: 2}
+	ch <- LookupResult{IP: net.ParseIP("10.0.0.1"), Index: 0, PTR: "a.example.com"}
+	ch <- LookupResult{IP: net.ParseIP("10.0.0.4"), Index: 3, Error: errors.New("timeout")}
+	ch <- LookupResult{IP: net.ParseIP("10.0.0.2"), Index: 1}
+	close(ch)
+
+	var buf bytes.Buffer
+	all, err := StreamNDJSON(&buf, ch, OutputOptions{}, true)
+	if err != nil {
+		t.Fatalf("StreamNDJSON error: %v", err)
+	}
+	if len(all) != 4 {
+		t.Errorf("got %d results back, want 4", len(all))
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(want), buf.String())
+	}
+	for i, line := range lines {
+		var jr JSONResult
+		if err := json.Unmarshal([]byte(line), &jr); err != nil {
+			t.Fatalf("line %d not JSON: %v", i, err)
+		}
+		if jr.IP != want[i] {
+			t.Errorf("line %d IP = %s, want %s", i, jr.IP, want[i])
+		}
+	}
+}
+
+func TestStreamNDJSONFiltered(t *testing.T) {
+	ch := make(chan LookupResult, 3)
+	ch <- LookupResult{IP: net.ParseIP("10.0.0.2"), Index: 1}
+	ch <- LookupResult{IP: net.ParseIP("10.0.0.3"), Index: 2, PTR: "c.example.com"}
+	ch <- LookupResult{IP: net.ParseIP("10.0.0.1"), Index: 0, PTR: "a.example.com"}
+	close(ch)
+
+	var buf bytes.Buffer
+	if _, err := StreamNDJSON(&buf, ch, OutputOptions{ResolvedOnly: true}, false); err != nil {
+		t.Fatalf("StreamNDJSON error: %v", err)
+	}
+
+	// Unordered: arrival order, NXDOMAIN filtered out
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "c.example.com") || !strings.Contains(lines[1], "a.example.com") {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
+
+// mixedBlock builds a /28 where every address resolves to ptr except the
+// listed host offsets, which are NXDOMAIN.
+func mixedBlock(ptr string, nxdomain ...int) []LookupResult {
+	skip := make(map[int]bool)
+	for _, i := range nxdomain {
+		skip[i] = true
+	}
+	results := make([]LookupResult, 16)
+	for i := range results {
+		results[i] = LookupResult{IP: net.IPv4(10, 0, 0, byte(i)).To4()}
+		if !skip[i] {
+			results[i].PTR = ptr
+		}
+	}
+	return results
+}
+
+// separatedBlocks returns two aggregatable /28s of host.example.com with an
+// unaggregatable /28 between them, and an IPv6 answer.
+func separatedBlocks() []LookupResult {
+	results := mixedBlock("host.example.com", 3)
+	for i := 16; i < 32; i++ {
+		r := LookupResult{IP: net.IPv4(10, 0, 0, byte(i)).To4()}
+		if i == 20 {
+			r.PTR = "other.example.com"
+		}
+		results = append(results, r)
+	}
+	for _, r := range mixedBlock("host.example.com", 5) {
+		r.IP = net.IPv4(10, 0, 0, 32+r.IP[3]).To4()
+		results = append(results, r)
+	}
+	return append(results, LookupResult{IP: net.ParseIP("2001:db8::1"), PTR: "v6.example.com"})
+}
+
+func TestAggregateResults(t *testing.T) {
+	tests := []struct {
+		name      string
+		results   []LookupResult
+		threshold float64
+		want      []string
+	}{
+		{
+			name:      "gaps absorbed above threshold",
+			results:   mixedBlock("host.example.com", 3, 9),
+			threshold: 0.8,
+			want:      []string{"10.0.0.0/28 host.example.com"},
+		},
+		{
+			name:      "largest qualifying supernet wins",
+			results:   mixedBlock("host.example.com", 15),
+			threshold: 0.9,
+			want:      []string{"10.0.0.0/28 host.example.com"},
+		},
+		{
+			name:      "below threshold keeps fragments",
+			results:   mixedBlock("host.example.com", 1, 5, 9, 13),
+			threshold: 0.9,
+			want: []string{
+				"10.0.0.0/32 host.example.com",
+				"10.0.0.2/31 host.example.com",
+				"10.0.0.4/32 host.example.com",
+				"10.0.0.6/31 host.example.com",
+				"10.0.0.8/32 host.example.com",
+				"10.0.0.10/31 host.example.com",
+				"10.0.0.12/32 host.example.com",
+				"10.0.0.14/31 host.example.com",
+			},
+		},
+		{
+			name:      "entries between supernets kept",
+			results:   separatedBlocks(),
+			threshold: 0.8,
+			want: []string{
+				"10.0.0.0/28 host.example.com",
+				"10.0.0.20/32 other.example.com",
+				"10.0.0.32/28 host.example.com",
+				"2001:db8::1/128 v6.example.com",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := AggregateResults(ConsolidateResults(tt.results), tt.threshold)
+			var lines []string
+			for _, r := range got {
+				if r.PTR != "" {
+					lines = append(lines, r.Network.String()+" "+r.PTR)
+				}
+			}
+			if strings.Join(lines, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %v, want %v", lines, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregateResultsBlockedByOtherPTR(t *testing.T) {
+	results := mixedBlock("host.example.com", 3)
+	results[3].PTR = "other.example.com"
+
+	got := AggregateResults(ConsolidateResults(results), 0.5)
+	for _, r := range got {
+		ones, _ := r.Network.Mask.Size()
+		if ones < 30 && r.PTR == "host.example.com" && r.Network.Contains(net.IPv4(10, 0, 0, 3)) {
+			t.Errorf("supernet %s swallowed a different PTR", r.Network)
+		}
+	}
+}
+
+func TestAggregateResultsRequiresFullCoverage(t *testing.T) {
+	// Only half of the /28 was scanned, so no /28 may be claimed
+	results := mixedBlock("host.example.com", 7)[:8]
+
+	got := AggregateResults(ConsolidateResults(results), 0.5)
+	for _, r := range got {
+		if ones, _ := r.Network.Mask.Size(); ones < 29 {
+			t.Errorf("claimed unscanned space: %s", r.Network)
+		}
+	}
+}
+
+func TestWriteOutputAggregate(t *testing.T) {
+	var buf bytes.Buffer
+	opts := OutputOptions{Format: "text", AggregateThreshold: 0.9}
+	if err := WriteOutput(&buf, mixedBlock("host.example.com", 6), opts); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], "10.0.0.0/28") {
+		t.Errorf("expected single aggregated /28, got:\n%s", buf.String())
+	}
+}
+
+func TestCapPrefix(t *testing.T) {
+	tests := []struct {
+		name      string
+		results   []LookupResult
+		minPrefix int
+		want      []string
+	}{
+		{
+			name:      "no cap",
+			results:   mixedBlock("host.example.com"),
+			minPrefix: 0,
+			want:      []string{"10.0.0.0/28 host.example.com"},
+		},
+		{
+			name:      "split to /30",
+			results:   mixedBlock("host.example.com"),
+			minPrefix: 30,
+			want: []string{
+				"10.0.0.0/30 host.example.com",
+				"10.0.0.4/30 host.example.com",
+				"10.0.0.8/30 host.example.com",
+				"10.0.0.12/30 host.example.com",
+			},
+		},
+		{
+			name:      "longer networks untouched",
+			results:   mixedBlock("host.example.com", 1),
+			minPrefix: 30,
+			want: []string{
+				"10.0.0.0/32 host.example.com",
+				"10.0.0.2/31 host.example.com",
+				"10.0.0.4/30 host.example.com",
+				"10.0.0.8/30 host.example.com",
+				"10.0.0.12/30 host.example.com",
+			},
+		},
+		{
+			name:      "cap beyond IPv4 length",
+			results:   mixedBlock("host.example.com")[:2],
+			minPrefix: 64,
+			want:      []string{"10.0.0.0/32 host.example.com", "10.0.0.1/32 host.example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CapPrefix(ConsolidateResults(tt.results), tt.minPrefix)
+			var lines []string
+			for _, r := range got {
+				if r.PTR != "" {
+					lines = append(lines, r.Network.String()+" "+r.PTR)
+				}
+			}
+			if strings.Join(lines, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %v, want %v", lines, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeEmpty(t *testing.T) {
+	timeout := errors.New("timeout")
+	results := []LookupResult{
+		{IP: net.IPv4(10, 0, 0, 0).To4(), PTR: "a.example.com"},
+		{IP: net.IPv4(10, 0, 0, 1).To4()},
+		{IP: net.IPv4(10, 0, 0, 2).To4(), Error: timeout},
+		{IP: net.IPv4(10, 0, 0, 3).To4()},
+		{IP: net.IPv4(10, 0, 0, 4).To4()},
+		{IP: net.IPv4(10, 0, 0, 5).To4()},
+		{IP: net.IPv4(10, 0, 0, 6).To4()},
+		{IP: net.IPv4(10, 0, 0, 7).To4()},
+		{IP: net.IPv4(10, 0, 0, 8).To4(), PTR: "b.example.com"},
+		{IP: net.IPv4(10, 0, 0, 9).To4()},
+	}
+
+	var lines []string
+	for _, c := range MergeEmpty(ConsolidateResults(results)) {
+		label := c.PTR
+		switch {
+		case c.Error != nil:
+			label = "error"
+		case c.NoData:
+			label = "nodata"
+		case label == "":
+			label = "nxdomain"
+		}
+		lines = append(lines, c.Network.String()+" "+label)
+	}
+	want := []string{
+		"10.0.0.0/32 a.example.com",
+		"10.0.0.1/32 nxdomain",
+		"10.0.0.2/31 nodata",
+		"10.0.0.4/30 nxdomain",
+		"10.0.0.8/32 b.example.com",
+		"10.0.0.9/32 nxdomain",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %v, want %v", lines, want)
+	}
+}
+
+func TestWriteOutputMergeEmpty(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.IPv4(10, 0, 0, 0).To4(), Error: errors.New("timeout")},
+		{IP: net.IPv4(10, 0, 0, 1).To4()},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "text"}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 2 {
+		t.Errorf("without --merge-empty want 2 lines, got:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "text", MergeEmpty: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	if got := strings.Fields(buf.String()); len(got) != 3 || got[0] != "10.0.0.0/31" || got[1]+" "+got[2] != "NO DATA" {
+		t.Errorf("want one NO DATA /31, got:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "json", MergeEmpty: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"no_data": true`) {
+		t.Errorf("want no_data in JSON, got:\n%s", buf.String())
+	}
+}
+
+func TestWriteOutputMinPrefixAfterAggregate(t *testing.T) {
+	// Aggregation claims the whole /28; the cap splits it again
+	var buf bytes.Buffer
+	opts := OutputOptions{Format: "text", AggregateThreshold: 0.9, MinPrefix: 29}
+	if err := WriteOutput(&buf, mixedBlock("host.example.com", 6), opts); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "10.0.0.0/29") || !strings.Contains(out, "10.0.0.8/29") || strings.Contains(out, "/28") {
+		t.Errorf("expected two /29s and no /28, got:\n%s", out)
+	}
+}
+
+func TestAnnotateSources(t *testing.T) {
+	// Two adjacent inputs share one PTR, so they consolidate into a single /30
+	results := []LookupResult{
+		{IP: net.ParseIP("10.0.0.2").To4(), PTR: "host.example.com", Index: 2, Source: "10.0.0.2/31"},
+		{IP: net.ParseIP("10.0.0.3").To4(), PTR: "host.example.com", Index: 3, Source: "10.0.0.2/31"},
+		{IP: net.ParseIP("10.0.0.0").To4(), PTR: "host.example.com", Index: 0, Source: "10.0.0.0/31"},
+		{IP: net.ParseIP("10.0.0.1").To4(), PTR: "host.example.com", Index: 1, Source: "10.0.0.0/31"},
+		{IP: net.ParseIP("192.0.2.1").To4(), Index: 4, Source: "192.0.2.1/32"},
+	}
+
+	consolidated := ConsolidateResults(results)
+	AnnotateSources(consolidated, results)
+
+	for _, c := range consolidated {
+		switch c.Network.String() {
+		case "10.0.0.0/30":
+			if len(c.Sources) != 2 || c.Sources[0] != "10.0.0.0/31" || c.Sources[1] != "10.0.0.2/31" {
+				t.Errorf("10.0.0.0/30 sources = %v, want [10.0.0.0/31 10.0.0.2/31]", c.Sources)
+			}
+		case "192.0.2.1/32":
+			if len(c.Sources) != 1 || c.Sources[0] != "192.0.2.1/32" {
+				t.Errorf("192.0.2.1 sources = %v, want [192.0.2.1/32]", c.Sources)
+			}
+		default:
+			t.Errorf("unexpected network %s", c.Network)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "json"}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"sources": [`) {
+		t.Errorf("expected sources in JSON, got:\n%s", buf.String())
+	}
+}
+
+func TestWriteOutputCompare(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("192.0.2.1"), PTR: "same.example.com", ComparePTR: "same.example.com"},
+		{IP: net.ParseIP("192.0.2.2"), PTR: "public.example.com", ComparePTR: "internal.corp.example", Mismatch: true},
+		{IP: net.ParseIP("192.0.2.3"), ComparePTR: "appears.example.com", Mismatch: true},
+		{IP: net.ParseIP("192.0.2.4"), PTR: "host.example.com", CompareError: errors.New("timeout")},
+	}
+
+	var buf bytes.Buffer
+	opts := OutputOptions{Format: "text", Expand: true, Compare: true}
+	if err := WriteOutput(&buf, results, opts); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{
+		"same.example.com",
+		"public.example.com [MISMATCH system: internal.corp.example]",
+		"NXDOMAIN [MISMATCH system: appears.example.com]",
+		"host.example.com [system: ERROR: timeout]",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(want), buf.String())
+	}
+	for i, w := range want {
+		if !strings.HasSuffix(lines[i], " "+w) {
+			t.Errorf("line %d = %q, want suffix %q", i, lines[i], w)
+		}
+	}
+
+	buf.Reset()
+	opts.Format = "json"
+	if err := WriteOutput(&buf, results, opts); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	var decoded []JSONResult
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if decoded[1].Mismatch == nil || !*decoded[1].Mismatch || decoded[1].ComparePTR == nil || *decoded[1].ComparePTR != "internal.corp.example" {
+		t.Errorf("expected mismatch with system_ptr for 192.0.2.2, got %+v", decoded[1])
+	}
+	if decoded[0].Mismatch == nil || *decoded[0].Mismatch {
+		t.Errorf("expected mismatch=false for 192.0.2.1, got %+v", decoded[0])
+	}
+	if decoded[3].CompareError == nil {
+		t.Errorf("expected system_error for 192.0.2.4, got %+v", decoded[3])
+	}
+}
+
+func TestWritePlan(t *testing.T) {
+	total, _ := TotalAddresses([]string{"10.0.0.0/8"})
+	p := Plan{Total: total, Queried: 1}
+
+	var buf bytes.Buffer
+	if err := WritePlan(&buf, p, "text"); err != nil {
+		t.Fatalf("WritePlan error: %v", err)
+	}
+	if buf.String() != "total     16777216\nqueried   1\n" {
+		t.Errorf("unexpected text plan:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := WritePlan(&buf, p, "json"); err != nil {
+		t.Fatalf("WritePlan error: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"total": 16777216`) || !strings.Contains(buf.String(), `"queried": 1`) {
+		t.Errorf("unexpected JSON plan:\n%s", buf.String())
+	}
+}
+
+func TestMergeFamilies(t *testing.T) {
+	consolidated := []ConsolidatedResult{
+		{Network: mustParseCIDR("192.0.2.0/28"), PTR: "*.cdn.example.net", Sources: []string{"192.0.2.0/28"}},
+		{Network: mustParseCIDR("192.0.2.16/28")},
+		{Network: mustParseCIDR("198.51.100.0/30"), PTR: "*.cdn.example.net"},
+		{Network: mustParseCIDR("198.51.100.8/30"), PTR: "v4only.example.com"},
+		{Network: mustParseCIDR("2001:db8::/124"), PTR: "*.cdn.example.net", Sources: []string{"2001:db8::/120"}},
+		{Network: mustParseCIDR("2001:db8::10/124")},
+	}
+
+	got := MergeFamilies(consolidated)
+	if len(got) != 4 {
+		t.Fatalf("got %d entries, want 4: %+v", len(got), got)
+	}
+	if s := networksString(got[0], false); s != "192.0.2.0/28,198.51.100.0/30,2001:db8::/124" {
+		t.Errorf("merged networks = %q", s)
+	}
+	if strings.Join(got[0].Sources, " ") != "192.0.2.0/28 2001:db8::/120" {
+		t.Errorf("merged sources = %v", got[0].Sources)
+	}
+	if got[2].PTR != "v4only.example.com" || len(got[2].Merged) != 0 {
+		t.Errorf("single-family entry should be untouched, got %+v", got[2])
+	}
+	if got[1].PTR != "" || got[3].PTR != "" {
+		t.Error("NXDOMAIN entries should be kept separately")
+	}
+
+	var buf bytes.Buffer
+	if err := FormatJSONConsolidated(&buf, got); err != nil {
+		t.Fatalf("FormatJSONConsolidated error: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"networks": [`) {
+		t.Errorf("expected networks list in JSON, got:\n%s", buf.String())
+	}
+}
+
+func TestGroupByPTR(t *testing.T) {
+	consolidated := []ConsolidatedResult{
+		{Network: mustParseCIDR("192.0.2.0/28"), PTR: "*.isp.net", Verified: 2},
+		{Network: mustParseCIDR("192.0.2.16/28")},
+		{Network: mustParseCIDR("192.0.2.32/28"), PTR: "*.isp.net", Verified: 3},
+		{Network: mustParseCIDR("192.0.2.48/28"), PTR: "mail.example.com"},
+		{Network: mustParseCIDR("192.0.2.64/28")},
+	}
+
+	got := GroupByPTR(consolidated)
+	if len(got) != 4 {
+		t.Fatalf("got %d entries, want 4: %+v", len(got), got)
+	}
+	if s := networksString(got[0], false); s != "192.0.2.0/28,192.0.2.32/28" {
+		t.Errorf("grouped networks = %q", s)
+	}
+	if got[0].Verified != 5 {
+		t.Errorf("grouped Verified = %d, want 5", got[0].Verified)
+	}
+	if got[1].PTR != "" || got[3].PTR != "" {
+		t.Error("NXDOMAIN entries should be kept separately")
+	}
+
+	var buf bytes.Buffer
+	if err := formatJSONConsolidated(&buf, got, OutputOptions{GroupByPTR: true}); err != nil {
+		t.Fatalf("formatJSONConsolidated error: %v", err)
+	}
+	var entries []ConsolidatedJSONResult
+	if err := json.Unmarshal(buf.Bytes(), &entries); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	for _, e := range entries {
+		if e.Network != "" || len(e.Networks) == 0 {
+			t.Errorf("entry %+v: want networks list and no network key", e)
+		}
+	}
+}
+
+func TestTruncatePTR(t *testing.T) {
+	tests := []struct {
+		ptr  string
+		max  int
+		want string
+	}{
+		{"host.example.com", 0, "host.example.com"},
+		{"host.example.com", 16, "host.example.com"},
+		{"host.example.com", 8, "host.ex…"},
+		{"host.example.com", 1, "…"},
+	}
+	for _, tt := range tests {
+		if got := truncatePTR(tt.ptr, tt.max); got != tt.want {
+			t.Errorf("truncatePTR(%q, %d) = %q, want %q", tt.ptr, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestWriteOutputMaxPTRLength(t *testing.T) {
+	long := "a-very-long-reverse-name.customers.example.com"
+	results := []LookupResult{{IP: net.ParseIP("192.0.2.1"), PTR: long}}
+
+	for _, expand := range []bool{true, false} {
+		var buf bytes.Buffer
+		opts := OutputOptions{Format: "text", Expand: expand, MaxPTRLength: 10}
+		if err := WriteOutput(&buf, results, opts); err != nil {
+			t.Fatalf("WriteOutput error: %v", err)
+		}
+		if !strings.Contains(buf.String(), "a-very-lo…") || strings.Contains(buf.String(), long) {
+			t.Errorf("expand=%v: expected truncated PTR, got:\n%s", expand, buf.String())
+		}
+
+		buf.Reset()
+		opts.Format = "json"
+		if err := WriteOutput(&buf, results, opts); err != nil {
+			t.Fatalf("WriteOutput error: %v", err)
+		}
+		if !strings.Contains(buf.String(), long) {
+			t.Errorf("expand=%v: JSON should keep the full PTR, got:\n%s", expand, buf.String())
+		}
+	}
+}
+
+func TestIPString(t *testing.T) {
+	tests := []struct {
+		ip     string
+		expand bool
+		want   string
+	}{
+		{"2001:db8::1", false, "2001:db8::1"},
+		{"2001:db8::1", true, "2001:0db8:0000:0000:0000:0000:0000:0001"},
+		{"::", true, "0000:0000:0000:0000:0000:0000:0000:0000"},
+		{"192.0.2.1", true, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		if got := ipString(net.ParseIP(tt.ip), tt.expand); got != tt.want {
+			t.Errorf("ipString(%s, %v) = %q, want %q", tt.ip, tt.expand, got, tt.want)
+		}
+	}
+
+	if got := networkString(mustParseCIDR("2001:db8::/64"), true); got != "2001:0db8:0000:0000:0000:0000:0000:0000/64" {
+		t.Errorf("networkString expanded = %q", got)
+	}
+}
+
+func TestWriteOutputExpandIPv6(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("2001:db8::1"), PTR: "host.example.com"},
+		{IP: net.ParseIP("2001:db8::2")},
+	}
+	const full = "2001:0db8:0000:0000:0000:0000:0000:0001"
+
+	for _, tt := range []struct {
+		format string
+		expand bool
+	}{{"text", true}, {"json", true}, {"text", false}, {"json", false}} {
+		var buf bytes.Buffer
+		opts := OutputOptions{Format: tt.format, Expand: tt.expand, ExpandIPv6: true}
+		if err := WriteOutput(&buf, results, opts); err != nil {
+			t.Fatalf("WriteOutput error: %v", err)
+		}
+		if !strings.Contains(buf.String(), full) || strings.Contains(buf.String(), "db8::") {
+			t.Errorf("%s expand=%v: expected fully expanded addresses, got:\n%s", tt.format, tt.expand, buf.String())
+		}
+	}
+}
+
+func TestWriteOutputFlatJSONSchema(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("10.0.0.0").To4(), PTR: "host.example.com"},
+		{IP: net.ParseIP("10.0.0.1").To4(), PTR: "host.example.com"},
+		{IP: net.ParseIP("2001:db8::1"), PTR: "v6.example.com"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "json", JSONSchema: "flat"}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	var flat []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &flat); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(flat) != 2 {
+		t.Fatalf("got %d entries, want 2:\n%s", len(flat), buf.String())
+	}
+	if flat[0]["ip"] != "10.0.0.0" || flat[0]["prefix_length"] != float64(31) {
+		t.Errorf("first entry = %v, want ip 10.0.0.0 with prefix_length 31", flat[0])
+	}
+	if flat[1]["ip"] != "2001:db8::1" || flat[1]["prefix_length"] != float64(128) {
+		t.Errorf("second entry = %v, want ip 2001:db8::1 with prefix_length 128", flat[1])
+	}
+	if _, ok := flat[0]["network"]; ok {
+		t.Error("flat schema should not include network")
+	}
+
+	// Expanded output gains prefix_length too
+	buf.Reset()
+	if err := WriteOutput(&buf, results[:1], OutputOptions{Format: "json", Expand: true, JSONSchema: "flat"}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"prefix_length": 32`) {
+		t.Errorf("expected prefix_length 32, got:\n%s", buf.String())
+	}
+
+	// Default schema is unchanged
+	buf.Reset()
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "json"}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"network": "10.0.0.0/31"`) || strings.Contains(buf.String(), "prefix_length") {
+		t.Errorf("default schema changed:\n%s", buf.String())
+	}
+}
+
+func TestStreamText(t *testing.T) {
+	ch := make(chan LookupResult, 3)
+	ch <- LookupResult{IP: net.ParseIP("10.0.0.2"), Index: 1}
+	ch <- LookupResult{IP: net.ParseIP("10.0.0.3"), Index: 2, Error: errors.New("timeout")}
+	ch <- LookupResult{IP: net.ParseIP("10.0.0.1"), Index: 0, PTR: "host.example.com"}
+	close(ch)
+
+	var buf bytes.Buffer
+	all, err := StreamText(&buf, ch, OutputOptions{Format: "text", Expand: true}, true)
+	if err != nil {
+		t.Fatalf("StreamText error: %v", err)
+	}
+	if len(all) != 3 {
+		t.Errorf("got %d results back, want 3", len(all))
+	}
+	want := "10.0.0.1\thost.example.com\n10.0.0.2\tNXDOMAIN\n10.0.0.3\tERROR: timeout\n"
+	if buf.String() != want {
+		t.Errorf("StreamText output = %q, want %q", buf.String(), want)
+	}
+}
+
+// writeRecorder records the size of each Write, in lines.
+type writeRecorder struct {
+	lines []int
+}
+
+func (w *writeRecorder) Write(p []byte) (int, error) {
+	w.lines = append(w.lines, bytes.Count(p, []byte("\n")))
+	return len(p), nil
+}
+
+func TestStreamBatchSize(t *testing.T) {
+	results := func() <-chan LookupResult {
+		ch := make(chan LookupResult, 5)
+		for i := range 5 {
+			ch <- LookupResult{IP: net.IPv4(10, 0, 0, byte(i)), Index: i}
+		}
+		close(ch)
+		return ch
+	}
+
+	tests := []struct {
+		name      string
+		batchSize int
+		want      []int
+	}{
+		{name: "default flushes each result", batchSize: 0, want: []int{1, 1, 1, 1, 1}},
+		{name: "batches of 2", batchSize: 2, want: []int{2, 2, 1}},
+		{name: "larger than output", batchSize: 100, want: []int{5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := OutputOptions{Format: "ndjson", Expand: true, BatchSize: tt.batchSize}
+
+			var w writeRecorder
+			if _, err := StreamNDJSON(&w, results(), opts, false); err != nil {
+				t.Fatalf("StreamNDJSON error: %v", err)
+			}
+			if fmt.Sprint(w.lines) != fmt.Sprint(tt.want) {
+				t.Errorf("StreamNDJSON writes = %v lines, want %v", w.lines, tt.want)
+			}
+
+			w = writeRecorder{}
+			opts.Format = "text"
+			if _, err := StreamText(&w, results(), opts, false); err != nil {
+				t.Fatalf("StreamText error: %v", err)
+			}
+			if fmt.Sprint(w.lines) != fmt.Sprint(tt.want) {
+				t.Errorf("StreamText writes = %v lines, want %v", w.lines, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsolidatePrecedence(t *testing.T) {
+	// 10.0.0.0/30 are IP-templated singles; 10.0.0.4-5 share a concrete
+	// hostname that is also IP-templated for 10.0.0.4
+	results := []LookupResult{
+		{IP: net.ParseIP("10.0.0.0").To4(), PTR: "0.0.0.10.dyn.isp.net"},
+		{IP: net.ParseIP("10.0.0.1").To4(), PTR: "1.0.0.10.dyn.isp.net"},
+		{IP: net.ParseIP("10.0.0.2").To4(), PTR: "2.0.0.10.dyn.isp.net"},
+		{IP: net.ParseIP("10.0.0.3").To4(), PTR: "3.0.0.10.dyn.isp.net"},
+		{IP: net.ParseIP("10.0.0.4").To4(), PTR: "4.0.0.10.dyn.isp.net"},
+		{IP: net.ParseIP("10.0.0.5").To4(), PTR: "4.0.0.10.dyn.isp.net"},
+	}
+
+	summarize := func(consolidated []ConsolidatedResult) string {
+		var parts []string
+		for _, c := range consolidated {
+			parts = append(parts, networkString(c.Network, false)+"="+c.PTR)
+		}
+		return strings.Join(parts, " ")
+	}
+
+	// Default: singles collapse to the pattern; the shared PTR stays exact
+	want := "10.0.0.0/30=*.dyn.isp.net 10.0.0.4/31=4.0.0.10.dyn.isp.net"
+	if got := summarize(ConsolidateResults(results)); got != want {
+		t.Errorf("pattern precedence:\n got %s\nwant %s", got, want)
+	}
+
+	// Exact: every concrete hostname is kept
+	want = "10.0.0.0=0.0.0.10.dyn.isp.net 10.0.0.1=1.0.0.10.dyn.isp.net 10.0.0.2=2.0.0.10.dyn.isp.net " +
+		"10.0.0.3=3.0.0.10.dyn.isp.net 10.0.0.4/31=4.0.0.10.dyn.isp.net"
+	if got := summarize(ConsolidateResultsExact(results)); got != want {
+		t.Errorf("exact precedence:\n got %s\nwant %s", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "text", PreferExact: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	if strings.Contains(buf.String(), "*.dyn.isp.net") {
+		t.Errorf("PreferExact output still has a pattern:\n%s", buf.String())
+	}
+}
+
+// mustParseCIDR parses a CIDR string or panics.
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func TestReadRecheckTargets(t *testing.T) {
+	perIP := `[
+  {"ip": "192.0.2.1", "ptr": "a.example.com"},
+  {"ip": "192.0.2.2", "ptr": null},
+  {"ip": "192.0.2.3", "ptr": null, "error": "timeout"},
+  {"ip": "2001:db8::1", "ptr": "v6.example.com"}
+]`
+	ndjson := `{"ip":"192.0.2.1","ptr":"a.example.com"}
+{"ip":"192.0.2.3","ptr":null,"error":"timeout"}
+`
+	consolidated := `[
+  {"network": "192.0.2.0/30", "ptr": "*.isp.example.com"},
+  {"network": "192.0.2.4", "ptr": null, "error": "timeout"}
+]`
+	flat := `[{"ip": "192.0.2.8", "prefix_length": 29, "ptr": "b.example.com"}]`
+
+	tests := []struct {
+		name   string
+		input  string
+		status string
+		want   []string
+	}{
+		{"json resolved", perIP, "resolved", []string{"192.0.2.1/32", "2001:db8::1/128"}},
+		{"json errors", perIP, "errors", []string{"192.0.2.3/32"}},
+		{"ndjson resolved", ndjson, "resolved", []string{"192.0.2.1/32"}},
+		{"ndjson errors", ndjson, "errors", []string{"192.0.2.3/32"}},
+		{"consolidated resolved", consolidated, "resolved", []string{"192.0.2.0/30"}},
+		{"consolidated errors", consolidated, "errors", []string{"192.0.2.4/32"}},
+		{"flat schema", flat, "resolved", []string{"192.0.2.8/29"}},
+		{"empty", "", "errors", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadRecheckTargets(strings.NewReader(tt.input), tt.status)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ReadRecheckTargets(strings.NewReader(perIP), "nxdomain"); err == nil {
+		t.Error("expected error for unknown status")
+	}
+	if _, err := ReadRecheckTargets(strings.NewReader("192.0.2.1\n"), "resolved"); err == nil {
+		t.Error("expected error for non-JSON input")
+	}
+}
+
+func TestWriteOutputExplain(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("192.0.2.1").To4(), PTR: "1.2.0.192.static.isp.net"},
+		{IP: net.ParseIP("192.0.2.0").To4(), PTR: "0.2.0.192.static.isp.net"},
+		{IP: net.ParseIP("192.0.2.4").To4(), PTR: "mail.example.com"},
+		{IP: net.ParseIP("192.0.2.5").To4(), PTR: "mail.example.com"},
+	}
+	opts := OutputOptions{Format: "text", Explain: true}
+
+	var buf bytes.Buffer
+	if err := WriteOutput(&buf, results, opts); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	want := "192.0.2.0/31    *.static.isp.net\n" +
+		"  192.0.2.0     0.2.0.192.static.isp.net\n" +
+		"  192.0.2.1     1.2.0.192.static.isp.net\n" +
+		"192.0.2.4/31    mail.example.com\n"
+	if buf.String() != want {
+		t.Errorf("text output =\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	opts.Format = "json"
+	if err := WriteOutput(&buf, results, opts); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	var got []ConsolidatedJSONResult
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
+	}
+	if len(got) != 2 || len(got[0].Members) != 2 || len(got[1].Members) != 0 {
+		t.Fatalf("members = %+v, want 2 under the pattern and none under the exact PTR", got)
+	}
+	if m := got[0].Members[1]; m.IP != "192.0.2.1" || m.PTR != "1.2.0.192.static.isp.net" {
+		t.Errorf("member = %+v, want 192.0.2.1 with its original PTR", m)
+	}
+}
+
+func TestWriteOutputMatchRatio(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("192.0.2.0").To4(), PTR: "0.2.0.192.static.isp.net"},
+		{IP: net.ParseIP("192.0.2.1").To4(), PTR: "1.2.0.192.static.isp.net"},
+		{IP: net.ParseIP("192.0.2.2").To4(), PTR: "2.2.0.192.static.isp.net"},
+		{IP: net.ParseIP("192.0.2.3").To4(), PTR: "*.static.isp.net", Inferred: true},
+		{IP: net.ParseIP("192.0.2.4").To4(), PTR: "mail.example.com"},
+		{IP: net.ParseIP("192.0.2.5").To4(), PTR: "mail.example.com"},
+	}
+	opts := OutputOptions{Format: "text", MatchRatio: true}
+
+	var buf bytes.Buffer
+	if err := WriteOutput(&buf, results, opts); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	want := "192.0.2.0/30    *.static.isp.net (1 inferred) (3 matched, 75%)\n" +
+		"192.0.2.4/31    mail.example.com\n"
+	if buf.String() != want {
+		t.Errorf("text output =\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	opts.Format = "json"
+	if err := WriteOutput(&buf, results, opts); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	var got []ConsolidatedJSONResult
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("failed to parse JSON: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d entries, want 2", len(got))
+	}
+	if got[0].Matched == nil || *got[0].Matched != 3 || got[0].MatchRatio == nil || *got[0].MatchRatio != 0.75 {
+		t.Errorf("pattern entry matched = %v, ratio = %v, want 3 and 0.75", got[0].Matched, got[0].MatchRatio)
+	}
+	if got[1].Matched != nil || got[1].MatchRatio != nil {
+		t.Error("exact PTR entry should have no match ratio")
+	}
+}
+
+func TestWriteResolverCheck(t *testing.T) {
+	tests := []struct {
+		name  string
+		check ResolverCheck
+		want  string
+	}{
+		{
+			name:  "answered",
+			check: ResolverCheck{Resolver: "server 1.1.1.1:53", Query: "8.8.8.8", PTR: "dns.google", LatencyMS: 12.34, OK: true},
+			want:  "resolver  server 1.1.1.1:53\nquery     8.8.8.8\nanswer    dns.google\nlatency   12.3ms\n",
+		},
+		{
+			name:  "nxdomain",
+			check: ResolverCheck{Resolver: "system", Query: "8.8.8.8", OK: true},
+			want:  "resolver  system\nquery     8.8.8.8\nanswer    NXDOMAIN\nlatency   0.0ms\n",
+		},
+		{
+			name:  "failed",
+			check: ResolverCheck{Resolver: "system", Query: "8.8.8.8", Error: "timeout", LatencyMS: 5000},
+			want:  "resolver  system\nquery     8.8.8.8\nanswer    ERROR: timeout\nlatency   5000.0ms\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteResolverCheck(&buf, tt.check, "text"); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectWildcards(t *testing.T) {
+	block := func(third byte, n int, ptr func(i int) string) []LookupResult {
+		var results []LookupResult
+		for i := range n {
+			results = append(results, LookupResult{IP: net.IPv4(192, 0, third, byte(i)).To4(), PTR: ptr(i)})
+		}
+		return results
+	}
+	same := func(i int) string { return "wild.example.com" }
+	mostly := func(i int) string {
+		if i%16 == 0 {
+			return "" // NXDOMAIN
+		}
+		return "wild.example.com"
+	}
+	templated := func(i int) string { return fmt.Sprintf("%d.2.0.192.isp.net", i) }
+	half := func(i int) string {
+		if i < 128 {
+			return "wild.example.com"
+		}
+		return fmt.Sprintf("h%d.example.com", i)
+	}
+	// scan numbers results as one input list, with sources naming the block
+	// of each
+	scan := func(blocks map[string][]LookupResult) ([]LookupResult, []string) {
+		var results []LookupResult
+		var sources []string
+		for _, name := range slices.Sorted(maps.Keys(blocks)) {
+			for _, r := range blocks[name] {
+				r.Index = len(results)
+				results = append(results, r)
+				sources = append(sources, name)
+			}
+		}
+		return results, sources
+	}
+
+	tests := []struct {
+		name   string
+		blocks map[string][]LookupResult
+		want   []Wildcard
+	}{
+		{"whole block", map[string][]LookupResult{"a": block(2, 256, same)}, []Wildcard{{"a", "wild.example.com", 256, 256}}},
+		{"nearly whole block", map[string][]LookupResult{"a": block(2, 256, mostly)}, []Wildcard{{"a", "wild.example.com", 240, 256}}},
+		{"templated names", map[string][]LookupResult{"a": block(2, 256, templated)}, nil},
+		{"half the block", map[string][]LookupResult{"a": block(2, 256, half)}, nil},
+		{"too few addresses", map[string][]LookupResult{"a": block(2, 8, same)}, nil},
+		{
+			// The wildcarded block is a fifth of the scan, but still found
+			"one block of several",
+			map[string][]LookupResult{
+				"192.0.1.0/24": block(1, 256, templated),
+				"192.0.2.0/24": block(2, 256, same),
+				"192.0.3.0/24": block(3, 256, templated),
+				"192.0.4.0/24": block(4, 256, half),
+				"192.0.5.0/24": block(5, 256, templated),
+			},
+			[]Wildcard{{"192.0.2.0/24", "wild.example.com", 256, 256}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, sources := scan(tt.blocks)
+			if got := DetectWildcards(results, sources); !slices.Equal(got, tt.want) {
+				t.Errorf("DetectWildcards = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteOutputJSONCompact(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("192.0.2.0").To4(), PTR: "host.example.com"},
+		{IP: net.ParseIP("192.0.2.1").To4(), PTR: "host.example.com"},
+	}
+
+	for _, expand := range []bool{false, true} {
+		var buf bytes.Buffer
+		opts := OutputOptions{Format: "json", Expand: expand, JSONCompact: true}
+		if err := WriteOutput(&buf, results, opts); err != nil {
+			t.Fatalf("WriteOutput error: %v", err)
+		}
+		out := buf.String()
+		if strings.Count(out, "\n") != 1 || strings.Contains(out, "  ") {
+			t.Errorf("expand=%v: want a single unindented line, got %q", expand, out)
+		}
+		if !json.Valid(buf.Bytes()) {
+			t.Errorf("expand=%v: invalid JSON %q", expand, out)
+		}
+	}
+}
+
+func TestShowArpa(t *testing.T) {
+	r := LookupResult{IP: net.ParseIP("2001:db8::1"), PTR: "host.example.com"}
+	opts := OutputOptions{Expand: true, ShowArpa: true}
+	arpa := "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"
+
+	if line := textLine(r, opts); line != "host.example.com (arpa: "+arpa+")" {
+		t.Errorf("textLine = %q", line)
+	}
+	if jr := toJSONResult(r, opts); jr.Arpa != arpa {
+		t.Errorf("JSON arpa = %q, want %q", jr.Arpa, arpa)
+	}
+	if jr := toJSONResult(r, OutputOptions{}); jr.Arpa != "" {
+		t.Errorf("JSON arpa = %q without --show-arpa, want empty", jr.Arpa)
 	}
-	return n
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// defaultPager is run by --pager when $PAGER is unset.
+const defaultPager = "less"
+
+// pagerWriter feeds output to a pager (--pager). The pager starts on the
+// first write rather than up front, so the progress line on stderr isn't
+// drawn underneath it while lookups run. If the pager can't be started,
+// output goes to stdout directly.
+type pagerWriter struct {
+	command []string  // Pager and its arguments
+	stdout  io.Writer // Where the pager, or the fallback, writes
+
+	once sync.Once
+	cmd  *exec.Cmd
+	pipe io.WriteCloser
+}
+
+// newPager returns a pagerWriter running command, split on whitespace, or
+// defaultPager if command is empty. As with git, less gets LESS=FRX unless
+// $LESS is set, so output that fits on one screen is printed and left
+// behind.
+func newPager(command string, stdout io.Writer) *pagerWriter {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		fields = []string{defaultPager}
+	}
+	return &pagerWriter{command: fields, stdout: stdout}
+}
+
+func (p *pagerWriter) start() {
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Stdout = p.stdout
+	cmd.Stderr = os.Stderr
+	if _, ok := os.LookupEnv("LESS"); !ok {
+		cmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+	pipe, err := cmd.StdinPipe()
+	if err != nil {
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		return
+	}
+	p.cmd, p.pipe = cmd, pipe
+}
+
+func (p *pagerWriter) Write(b []byte) (int, error) {
+	p.once.Do(p.start)
+	if p.pipe == nil {
+		return p.stdout.Write(b)
+	}
+	return p.pipe.Write(b)
+}
+
+// Close ends the pager's input and waits for the user to quit it.
+func (p *pagerWriter) Close() error {
+	p.once.Do(func() {}) // nothing written: never start the pager
+	if p.pipe == nil {
+		return nil
+	}
+	p.pipe.Close()
+	// The pager's exit status (such as less quit early) is not our error
+	_ = p.cmd.Wait()
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+)
+
+func TestPagerWriter(t *testing.T) {
+	if _, err := exec.LookPath("cat"); err != nil {
+		t.Skip("no cat to stand in for a pager")
+	}
+	var buf bytes.Buffer
+	p := newPager("cat -u", &buf)
+	if _, err := p.Write([]byte("192.0.2.0/24    host.example.com\n")); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	if p.cmd == nil {
+		t.Fatal("pager not started on first write")
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	if buf.String() != "192.0.2.0/24    host.example.com\n" {
+		t.Errorf("paged output = %q", buf.String())
+	}
+}
+
+func TestPagerWriterFallback(t *testing.T) {
+	var buf bytes.Buffer
+	p := newPager("no-such-pager-for-sr", &buf)
+	if _, err := p.Write([]byte("direct\n")); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	if buf.String() != "direct\n" {
+		t.Errorf("output = %q, want it written directly", buf.String())
+	}
+}
+
+func TestPagerWriterUnused(t *testing.T) {
+	p := newPager("", &bytes.Buffer{})
+	if p.command[0] != defaultPager {
+		t.Errorf("command = %v, want %s", p.command, defaultPager)
+	}
+	// Nothing written: the pager never starts
+	if err := p.Close(); err != nil || p.cmd != nil {
+		t.Errorf("Close = %v, started = %v; want no pager", err, p.cmd != nil)
+	}
+}
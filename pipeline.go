@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"time"
+)
+
+// Config describes a scan for Run, independent of the command line. The
+// zero value of each option leaves the corresponding feature off.
+type Config struct {
+	Targets  []string // CIDRs or IPs; hostnames with FromHost
+	Resolver Resolver // nil uses DefaultResolver()
+
+	Concurrency int    // Worker count; < 1 uses 1
+	MaxIPs      uint64 // Cap on addresses queried; 0 means no cap
+	PerCIDRMax  bool   // Apply MaxIPs to each target instead of all of them
+
+	Family          string   // "ipv4" or "ipv6" restricts targets to that family
+	DropOtherFamily bool     // With Family, skip other-family targets instead of failing
+	Excludes        []string // CIDRs or IPs left out of the targets
+	FirstHost       bool     // Query only the first usable host of each target
+	UsableOnly      bool     // Skip network, broadcast, subnet-router anycast, and unspecified addresses
+	FromHost        bool     // Targets are hostnames; query their A/AAAA addresses
+
+	Workers WorkerOptions // Queue size, inference, shuffling
+
+	Verify       bool     // Forward-confirm each PTR, through VerifyResolver if set
+	SearchDomain string   // Appended to relative PTR names when verifying
+	DualStack    bool     // Record the address families each PTR name resolves in
+	Compare      Resolver // If set, also query this resolver and note differing PTRs
+
+	// VerifyResolver, if set, answers the forward half of Verify instead of
+	// Resolver, which must otherwise be a ForwardResolver. The CLI sets it
+	// to the system resolver unless --verify-same-server is given.
+	VerifyResolver ForwardResolver
+
+	// Status, if set, receives a live progress line while lookups run if
+	// ShowProgress is set, once ProgressDelay has passed, and the slowest
+	// pending lookups if Workers.InFlight is set.
+	Status        io.Writer
+	ShowProgress  bool
+	ProgressDelay time.Duration
+}
+
+// ScanPlan is a scan's expanded input.
+type ScanPlan struct {
+	IPs     []net.IP // Addresses to query, in input order
+	Sources []string // Target each IP came from, by index
+	Total   *big.Int // Addresses the targets cover, before MaxIPs
+	Inputs  int      // Targets left after family filtering and exclusions
+}
+
+// PlanScan expands cfg's targets into the addresses to query, without
+// looking any up (other than hostnames, with FromHost).
+func PlanScan(ctx context.Context, cfg Config) (*ScanPlan, error) {
+	if len(cfg.Targets) == 0 {
+		return nil, fmt.Errorf("no targets")
+	}
+
+	plan := &ScanPlan{}
+	var err error
+	if cfg.FromHost {
+		fwd, ok := resolverOrDefault(cfg).(ForwardResolver)
+		if !ok {
+			return nil, fmt.Errorf("resolver does not support forward lookups")
+		}
+		plan.IPs, plan.Sources, err = ResolveHosts(ctx, cfg.Targets, fwd, cfg.Family)
+		if err != nil {
+			return nil, err
+		}
+		plan.Total = big.NewInt(int64(len(plan.IPs)))
+		plan.Inputs = len(cfg.Targets)
+	} else {
+		targets, err := FilterFamily(cfg.Targets, cfg.Family, cfg.DropOtherFamily)
+		if err != nil {
+			return nil, err
+		}
+		targets, err = ExcludeCIDRs(targets, cfg.Excludes)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case cfg.FirstHost:
+			plan.IPs, err = FirstHosts(targets)
+			plan.Sources = targets
+		case cfg.UsableOnly:
+			plan.IPs, plan.Sources, err = parseCIDRs(targets, cfg.MaxIPs, cfg.PerCIDRMax, true)
+		case cfg.PerCIDRMax:
+			plan.IPs, plan.Sources, err = ParseCIDRsPerCIDR(targets, cfg.MaxIPs)
+		default:
+			plan.IPs, plan.Sources, err = ParseCIDRsWithSources(targets, cfg.MaxIPs)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if plan.Total, err = TotalAddresses(targets); err != nil {
+			return nil, err
+		}
+		plan.Inputs = len(targets)
+	}
+
+	if len(plan.IPs) == 0 {
+		return nil, fmt.Errorf("no IP addresses in specified CIDR blocks")
+	}
+	return plan, nil
+}
+
+// ExecutePlan looks up every address in plan and applies cfg's
+// verification, dual-stack, and comparison passes. Results are in
+// completion order; Index refers to plan.IPs. If ctx is cancelled, the
+// results gathered so far are returned with its error.
+func ExecutePlan(ctx context.Context, cfg Config, plan *ScanPlan) ([]LookupResult, error) {
+	resolver := resolverOrDefault(cfg)
+	var fwd ForwardResolver
+	if (cfg.Verify && cfg.VerifyResolver == nil) || cfg.DualStack {
+		var ok bool
+		if fwd, ok = resolver.(ForwardResolver); !ok {
+			return nil, fmt.Errorf("resolver does not support forward lookups")
+		}
+	}
+	verifyResolver := fwd
+	if cfg.VerifyResolver != nil {
+		verifyResolver = cfg.VerifyResolver
+	}
+
+	concurrency := max(cfg.Concurrency, 1)
+	resultChan := LookupWorkersWith(ctx, plan.IPs, concurrency, resolver, cfg.Workers)
+	results := collectResults(resultChan, len(plan.IPs), cfg.ShowProgress && cfg.Status != nil, cfg.ProgressDelay, cfg.Status, cfg.Workers.InFlight)
+
+	// With several inputs, record which one each IP came from so consolidated
+	// JSON can show the inputs behind each network
+	if plan.Inputs > 1 {
+		SetSources(results, plan.Sources)
+	}
+
+	if cfg.Verify {
+		VerifyResults(ctx, results, concurrency, verifyResolver, cfg.SearchDomain)
+	}
+	if cfg.DualStack {
+		DualStackResults(ctx, results, concurrency, fwd)
+	}
+	if cfg.Compare != nil {
+		CompareResults(ctx, results, concurrency, cfg.Compare)
+	}
+	return results, ctx.Err()
+}
+
+// Run scans cfg's targets and returns the lookup results: PlanScan followed
+// by ExecutePlan.
+func Run(ctx context.Context, cfg Config) ([]LookupResult, error) {
+	plan, err := PlanScan(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return ExecutePlan(ctx, cfg, plan)
+}
+
+func resolverOrDefault(cfg Config) Resolver {
+	if cfg.Resolver == nil {
+		return DefaultResolver()
+	}
+	return cfg.Resolver
+}
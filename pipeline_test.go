@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestRun(t *testing.T) {
+	resolver := NewMockResolver()
+	resolver.AddResult("192.0.2.1", "host1.example.com.")
+	resolver.AddResult("192.0.2.2", "host2.example.com.")
+
+	results, err := Run(context.Background(), Config{
+		Targets:     []string{"192.0.2.0/30"},
+		Resolver:    resolver,
+		Concurrency: 4,
+	})
+	if err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("got %d results, want 4", len(results))
+	}
+	SortResults(results)
+	if results[1].PTR != "host1.example.com" || results[2].PTR != "host2.example.com" || results[0].PTR != "" {
+		t.Errorf("unexpected results: %+v", results)
+	}
+}
+
+func TestRunThroughDNSClient(t *testing.T) {
+	// The whole pipeline in-process, down to DNS packets
+	srv := startFakeDNS(t)
+	srv.AddPTR("1.2.0.192.in-addr.arpa.", "host.example.com.")
+	client, err := NewDNSClient(srv.Addr())
+	if err != nil {
+		t.Fatalf("NewDNSClient error: %v", err)
+	}
+
+	results, err := Run(context.Background(), Config{
+		Targets:     []string{"192.0.2.0/31"},
+		Resolver:    client,
+		Concurrency: 2,
+	})
+	if err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	SortResults(results)
+	if len(results) != 2 || results[1].PTR != "host.example.com" || results[0].Error != nil {
+		t.Errorf("unexpected results: %+v", results)
+	}
+}
+
+func TestPlanScan(t *testing.T) {
+	plan, err := PlanScan(context.Background(), Config{
+		Targets:  []string{"10.0.0.0/24", "2001:db8::/120", "192.0.2.0/30"},
+		Family:   "ipv4",
+		Excludes: []string{"192.0.2.0/31"},
+		MaxIPs:   100,
+
+		DropOtherFamily: true,
+	})
+	if err != nil {
+		t.Fatalf("PlanScan error: %v", err)
+	}
+	if len(plan.IPs) != 100 || plan.Total.Int64() != 258 || plan.Inputs != 2 {
+		t.Errorf("plan = %d IPs of %s from %d inputs, want 100 of 258 from 2", len(plan.IPs), plan.Total, plan.Inputs)
+	}
+}
+
+func TestPlanScanErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{"no targets", Config{}, "no targets"},
+		{"other family", Config{Targets: []string{"2001:db8::/126"}, Family: "ipv4"}, "IPv6"},
+		{"all excluded", Config{Targets: []string{"192.0.2.0/30"}, Excludes: []string{"192.0.2.0/24"}}, "no IP addresses"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := PlanScan(context.Background(), tt.cfg)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunSourcesAndVerify(t *testing.T) {
+	resolver := NewMockResolver()
+	resolver.AddResult("192.0.2.1", "good.example.com")
+	resolver.AddResult("198.51.100.1", "stale.example.com")
+	resolver.AddForward("good.example.com", "192.0.2.1")
+
+	results, err := Run(context.Background(), Config{
+		Targets:     []string{"192.0.2.1/32", "198.51.100.1/32"},
+		Resolver:    resolver,
+		Concurrency: 2,
+		Verify:      true,
+	})
+	if err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	for _, r := range results {
+		wantVerified := r.IP.Equal(net.ParseIP("192.0.2.1"))
+		if r.Verified != wantVerified {
+			t.Errorf("%s verified = %v, want %v", r.IP, r.Verified, wantVerified)
+		}
+		if !strings.HasPrefix(r.Source, r.IP.String()) {
+			t.Errorf("%s source = %q, want its own input", r.IP, r.Source)
+		}
+	}
+}
+
+func TestRunVerifyUsesSameServer(t *testing.T) {
+	// Without VerifyResolver (--verify-same-server), the forward half of
+	// verification goes to the server that answered the PTR, not the system
+	// resolver, which can't see this split-horizon name
+	srv := startFakeDNS(t)
+	srv.AddPTR("1.2.0.192.in-addr.arpa.", "internal.example.test.")
+	srv.AddA("internal.example.test.", "192.0.2.1")
+	resolver, err := BoundResolver(srv.Addr(), nil, 0)
+	if err != nil {
+		t.Fatalf("BoundResolver error: %v", err)
+	}
+
+	results, err := Run(context.Background(), Config{
+		Targets:     []string{"192.0.2.1/32"},
+		Resolver:    resolver,
+		Concurrency: 1,
+		Verify:      true,
+	})
+	if err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	if len(results) != 1 || !results[0].Verified {
+		t.Fatalf("results = %+v, want 192.0.2.1 verified", results)
+	}
+	var forward bool
+	for _, q := range srv.Queries() {
+		if q.Questions[0].Type == dnsmessage.TypeA {
+			forward = true
+		}
+	}
+	if !forward {
+		t.Error("the server saw no A query; the forward lookup went elsewhere")
+	}
+}
+
+func TestRunVerifyResolver(t *testing.T) {
+	// With VerifyResolver (the CLI default: the system resolver), forward
+	// lookups go there, so the split-horizon name is not confirmed
+	srv := startFakeDNS(t)
+	srv.AddPTR("1.2.0.192.in-addr.arpa.", "internal.example.test.")
+	srv.AddA("internal.example.test.", "192.0.2.1")
+	resolver, err := BoundResolver(srv.Addr(), nil, 0)
+	if err != nil {
+		t.Fatalf("BoundResolver error: %v", err)
+	}
+	public := NewMockResolver()
+	public.AddForward("public.example.com", "198.51.100.1")
+
+	results, err := Run(context.Background(), Config{
+		Targets:        []string{"192.0.2.1/32"},
+		Resolver:       resolver,
+		Concurrency:    1,
+		Verify:         true,
+		VerifyResolver: public,
+	})
+	if err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	if len(results) != 1 || results[0].PTR != "internal.example.test" || results[0].Verified {
+		t.Fatalf("results = %+v, want 192.0.2.1 answered but not verified", results)
+	}
+	for _, q := range srv.Queries() {
+		if q.Questions[0].Type != dnsmessage.TypePTR {
+			t.Errorf("the server saw a %v query; forward lookups should go to VerifyResolver", q.Questions[0].Type)
+		}
+	}
+
+	// The PTR resolver then need not do forward lookups at all
+	mock := NewMockResolver()
+	mock.AddResult("198.51.100.1", "public.example.com")
+	results, err = Run(context.Background(), Config{
+		Targets:        []string{"198.51.100.1/32"},
+		Resolver:       ptrOnlyResolver{mock},
+		Verify:         true,
+		VerifyResolver: public,
+	})
+	if err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	if len(results) != 1 || !results[0].Verified {
+		t.Errorf("results = %+v, want 198.51.100.1 verified", results)
+	}
+}
+
+// ptrOnlyResolver hides any forward lookups of the resolver it wraps.
+type ptrOnlyResolver struct{ r Resolver }
+
+func (p ptrOnlyResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return p.r.LookupAddr(ctx, addr)
+}
+
+func TestRunVerifyNeedsForwardResolver(t *testing.T) {
+	_, err := Run(context.Background(), Config{
+		Targets:  []string{"192.0.2.1/32"},
+		Resolver: &countingResolver{},
+		Verify:   true,
+	})
+	if err == nil || !strings.Contains(err.Error(), "forward lookups") {
+		t.Errorf("error = %v, want forward lookup error", err)
+	}
+}
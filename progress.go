@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	progressInterval = 500 * time.Millisecond // How often the progress line refreshes
+	rateWindowSpan   = 5 * time.Second        // Sliding window for the queries/sec figure
+	stallTicks       = 6                      // Intervals without completions before hinting a stall
+
+	slowReportInterval = 5 * time.Second // How often --verbose lists slow in-flight lookups
+	slowThreshold      = time.Second     // Minimum pending time for a lookup to be listed
+	slowReportMax      = 5               // Lookups listed per report
+)
+
+// DefaultProgressDelay is the quiet period before the first progress line,
+// so fast runs don't flicker one onto the terminal.
+const DefaultProgressDelay = 2 * time.Second
+
+// InFlightLookup is a lookup a worker is currently running.
+type InFlightLookup struct {
+	IP    net.IP
+	Since time.Time
+}
+
+// InFlight tracks the lookup each worker is currently running, so stuck
+// addresses can be reported. A nil *InFlight ignores all updates.
+type InFlight struct {
+	mu      sync.Mutex
+	pending map[int]InFlightLookup // worker -> current lookup
+}
+
+// NewInFlight returns an empty tracker.
+func NewInFlight() *InFlight {
+	return &InFlight{pending: make(map[int]InFlightLookup)}
+}
+
+// start records that worker began looking up ip.
+func (f *InFlight) start(worker int, ip net.IP) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	f.pending[worker] = InFlightLookup{IP: ip, Since: time.Now()}
+	f.mu.Unlock()
+}
+
+// done records that worker finished its lookup.
+func (f *InFlight) done(worker int) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	delete(f.pending, worker)
+	f.mu.Unlock()
+}
+
+// Slowest returns up to n lookups pending since before cutoff, longest first.
+func (f *InFlight) Slowest(n int, cutoff time.Time) []InFlightLookup {
+	f.mu.Lock()
+	var slow []InFlightLookup
+	for _, l := range f.pending {
+		if l.Since.Before(cutoff) {
+			slow = append(slow, l)
+		}
+	}
+	f.mu.Unlock()
+
+	sort.Slice(slow, func(i, j int) bool { return slow[i].Since.Before(slow[j].Since) })
+	if len(slow) > n {
+		slow = slow[:n]
+	}
+	return slow
+}
+
+// rateSample is a completion count observed at a point in time.
+type rateSample struct {
+	at    time.Time
+	count int
+}
+
+// rateWindow computes a completion rate over a sliding window of samples.
+type rateWindow struct {
+	span    time.Duration
+	samples []rateSample
+}
+
+// add records the completed count at time t and drops samples older than the span.
+func (w *rateWindow) add(t time.Time, count int) {
+	w.samples = append(w.samples, rateSample{at: t, count: count})
+	cutoff := t.Add(-w.span)
+	drop := 0
+	for drop < len(w.samples)-1 && w.samples[drop].at.Before(cutoff) {
+		drop++
+	}
+	w.samples = w.samples[drop:]
+}
+
+// rate returns completions per second across the window, or 0 with fewer than two samples.
+func (w *rateWindow) rate() float64 {
+	if len(w.samples) < 2 {
+		return 0
+	}
+	first, last := w.samples[0], w.samples[len(w.samples)-1]
+	elapsed := last.at.Sub(first.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(last.count-first.count) / elapsed
+}
+
+// progressLine formats the progress status shown on stderr.
+func progressLine(done, total int, rate float64, stalled bool) string {
+	line := fmt.Sprintf("Looking up IPs... %d/%d (%d%%) %.0f q/s", done, total, 100*done/total, rate)
+	if stalled {
+		line += " stalled?"
+	}
+	return line
+}
+
+// writeSlowLookups lists the lookups pending longest, one per line.
+func writeSlowLookups(w io.Writer, slow []InFlightLookup, now time.Time) {
+	for _, l := range slow {
+		fmt.Fprintf(w, "slow: %s pending %.1fs\n", l.IP, now.Sub(l.Since).Seconds())
+	}
+}
+
+// collectResults drains resultChan into a slice. If showProgress is set, a
+// progress line with the current resolve rate is written to w once delay
+// has passed (at once if delay is 0) and cleared when done. If inFlight is
+// set, the slowest pending lookups are listed on w periodically.
+func collectResults(resultChan <-chan LookupResult, total int, showProgress bool, delay time.Duration, w io.Writer, inFlight *InFlight) []LookupResult {
+	results := make([]LookupResult, 0, total)
+	drainResults(resultChan, total, showProgress, delay, w, inFlight, func(r LookupResult) {
+		results = append(results, r)
+	})
+	return results
+}
+
+// drainResults is collectResults passing each result to add instead of
+// keeping it.
+func drainResults(resultChan <-chan LookupResult, total int, showProgress bool, delay time.Duration, w io.Writer, inFlight *InFlight, add func(LookupResult)) {
+	if !showProgress && inFlight == nil {
+		for result := range resultChan {
+			add(result)
+		}
+		return
+	}
+
+	start := time.Now()
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	window := rateWindow{span: rateWindowSpan}
+	done := 0
+	idleTicks := 0
+	lastCount := 0
+	lastReport := start
+	if showProgress && delay <= 0 && total > 0 {
+		fmt.Fprintf(w, "\r%-70s", progressLine(0, total, 0, false))
+	}
+
+	for {
+		select {
+		case result, ok := <-resultChan:
+			if !ok {
+				if showProgress {
+					// Clear the progress line
+					fmt.Fprintf(w, "\r%-70s\r", "")
+				}
+				return
+			}
+			add(result)
+			done++
+		case now := <-ticker.C:
+			window.add(now, done)
+			if done == lastCount {
+				idleTicks++
+			} else {
+				idleTicks = 0
+				lastCount = done
+			}
+			if inFlight != nil && now.Sub(lastReport) >= slowReportInterval {
+				lastReport = now
+				if slow := inFlight.Slowest(slowReportMax, now.Add(-slowThreshold)); len(slow) > 0 {
+					if showProgress {
+						fmt.Fprintf(w, "\r%-70s\r", "")
+					}
+					writeSlowLookups(w, slow, now)
+				}
+			}
+			if showProgress && time.Since(start) >= delay {
+				fmt.Fprintf(w, "\r%-70s", progressLine(done, total, window.rate(), idleTicks >= stallTicks))
+			}
+		}
+	}
+}
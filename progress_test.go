@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateWindow(t *testing.T) {
+	w := rateWindow{span: 2 * time.Second}
+	base := time.Now()
+
+	if w.rate() != 0 {
+		t.Errorf("empty window rate = %v, want 0", w.rate())
+	}
+
+	w.add(base, 0)
+	w.add(base.Add(time.Second), 100)
+	if got := w.rate(); got != 100 {
+		t.Errorf("rate = %v, want 100", got)
+	}
+
+	// Old samples fall out of the window, so a stall shows as zero
+	w.add(base.Add(4*time.Second), 100)
+	w.add(base.Add(5*time.Second), 100)
+	if got := w.rate(); got != 0 {
+		t.Errorf("rate after stall = %v, want 0", got)
+	}
+}
+
+func TestProgressLine(t *testing.T) {
+	line := progressLine(50, 200, 12.4, false)
+	if !strings.Contains(line, "50/200 (25%)") || !strings.Contains(line, "12 q/s") {
+		t.Errorf("progressLine = %q", line)
+	}
+	if strings.Contains(line, "stalled") {
+		t.Errorf("unexpected stall hint: %q", line)
+	}
+	if !strings.HasSuffix(progressLine(50, 200, 0, true), "stalled?") {
+		t.Error("missing stall hint")
+	}
+}
+
+func TestCollectResults(t *testing.T) {
+	ch := make(chan LookupResult, 3)
+	for i := 0; i < 3; i++ {
+		ch <- LookupResult{IP: net.IPv4(10, 0, 0, byte(i))}
+	}
+	close(ch)
+
+	var buf bytes.Buffer
+	results := collectResults(ch, 3, true, DefaultProgressDelay, &buf, nil)
+	if len(results) != 3 {
+		t.Errorf("got %d results, want 3", len(results))
+	}
+}
+
+func TestCollectResultsProgressDelay(t *testing.T) {
+	for _, tt := range []struct {
+		delay time.Duration
+		shown bool
+	}{
+		{0, true},
+		{DefaultProgressDelay, false},
+	} {
+		ch := make(chan LookupResult, 1)
+		ch <- LookupResult{IP: net.IPv4(10, 0, 0, 1)}
+		close(ch)
+
+		var buf bytes.Buffer
+		collectResults(ch, 1, true, tt.delay, &buf, nil)
+		if got := strings.Contains(buf.String(), "/1"); got != tt.shown {
+			t.Errorf("delay %v: progress shown = %v, want %v (output %q)", tt.delay, got, tt.shown, buf.String())
+		}
+	}
+}
+
+func TestInFlightSlowest(t *testing.T) {
+	f := NewInFlight()
+	now := time.Now()
+	f.pending[0] = InFlightLookup{IP: net.ParseIP("10.0.0.1"), Since: now.Add(-3 * time.Second)}
+	f.pending[1] = InFlightLookup{IP: net.ParseIP("10.0.0.2"), Since: now.Add(-500 * time.Millisecond)}
+	f.pending[2] = InFlightLookup{IP: net.ParseIP("10.0.0.3"), Since: now.Add(-8 * time.Second)}
+
+	slow := f.Slowest(5, now.Add(-time.Second))
+	if len(slow) != 2 || slow[0].IP.String() != "10.0.0.3" || slow[1].IP.String() != "10.0.0.1" {
+		t.Errorf("Slowest = %v, want 10.0.0.3 then 10.0.0.1", slow)
+	}
+	if got := f.Slowest(1, now); len(got) != 1 || got[0].IP.String() != "10.0.0.3" {
+		t.Errorf("Slowest(1) = %v, want only 10.0.0.3", got)
+	}
+
+	var buf bytes.Buffer
+	writeSlowLookups(&buf, slow[:1], now)
+	if buf.String() != "slow: 10.0.0.3 pending 8.0s\n" {
+		t.Errorf("writeSlowLookups = %q", buf.String())
+	}
+
+	f.done(2)
+	if got := f.Slowest(5, now); len(got) != 2 {
+		t.Errorf("after done, %d pending, want 2", len(got))
+	}
+
+	var nilTracker *InFlight
+	nilTracker.start(0, net.ParseIP("10.0.0.1")) // must not panic
+	nilTracker.done(0)
+}
+
+func TestLookupWorkersInFlight(t *testing.T) {
+	resolver := NewMockResolver()
+	ips := []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3")}
+
+	f := NewInFlight()
+	count := 0
+	for range LookupWorkersWith(context.Background(), ips, 2, resolver, WorkerOptions{InFlight: f}) {
+		count++
+	}
+	if count != len(ips) {
+		t.Errorf("got %d results, want %d", count, len(ips))
+	}
+	if pending := f.Slowest(10, time.Now().Add(time.Hour)); len(pending) != 0 {
+		t.Errorf("lookups still tracked after completion: %v", pending)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import "strings"
+
+// providerSuffixes maps PTR domain suffixes to the hosting provider that
+// owns them. Matching is on label boundaries; the longest suffix wins.
+var providerSuffixes = map[string]string{
+	"amazonaws.com":          "AWS",
+	"cloudfront.net":         "AWS",
+	"googleusercontent.com":  "GCP",
+	"1e100.net":              "Google",
+	"cloudapp.azure.com":     "Azure",
+	"cloudapp.net":           "Azure",
+	"linodeusercontent.com":  "Linode",
+	"members.linode.com":     "Linode",
+	"vultrusercontent.com":   "Vultr",
+	"your-server.de":         "Hetzner",
+	"ovh.net":                "OVH",
+	"akamaitechnologies.com": "Akamai",
+	"oraclecloud.com":        "Oracle Cloud",
+	"scw.cloud":              "Scaleway",
+	"contaboserver.net":      "Contabo",
+	"fastly.net":             "Fastly",
+}
+
+// DetectProvider guesses the hosting provider from a PTR name (or a
+// consolidation pattern like "*.compute.amazonaws.com"). Returns "" if the
+// suffix is unknown.
+func DetectProvider(ptr string) string {
+	name := strings.ToLower(strings.TrimSuffix(ptr, "."))
+	best, bestLen := "", 0
+	for suffix, provider := range providerSuffixes {
+		if len(suffix) <= bestLen {
+			continue
+		}
+		if name == suffix || strings.HasSuffix(name, "."+suffix) {
+			best, bestLen = provider, len(suffix)
+		}
+	}
+	return best
+}
+
+// tagProviders returns a copy of results with Provider set from each PTR.
+func tagProviders(results []LookupResult) []LookupResult {
+	tagged := make([]LookupResult, len(results))
+	for i, r := range results {
+		r.Provider = DetectProvider(r.PTR)
+		tagged[i] = r
+	}
+	return tagged
+}
+
+// tagConsolidatedProviders sets Provider on each consolidated entry in place.
+// Patterns like "*.compute.amazonaws.com" are matched by suffix as well.
+func tagConsolidatedProviders(consolidated []ConsolidatedResult) {
+	for i := range consolidated {
+		consolidated[i].Provider = DetectProvider(consolidated[i].PTR)
+	}
+}
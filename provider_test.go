@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestDetectProvider(t *testing.T) {
+	tests := []struct {
+		ptr  string
+		want string
+	}{
+		{"ec2-52-1-2-3.compute-1.amazonaws.com", "AWS"},
+		{"ec2-52-1-2-3.compute-1.amazonaws.com.", "AWS"},
+		{"*.compute-1.amazonaws.com", "AWS"},
+		{"3.2.1.34.bc.googleusercontent.com", "GCP"},
+		{"lhr25s34-in-f14.1e100.net", "Google"},
+		{"static.1.2.0.192.clients.your-server.de", "Hetzner"},
+		{"HOST.CLOUDAPP.AZURE.COM", "Azure"},
+		{"amazonaws.com", "AWS"},
+		{"notamazonaws.com", ""},
+		{"host.example.com", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ptr, func(t *testing.T) {
+			if got := DetectProvider(tt.ptr); got != tt.want {
+				t.Errorf("DetectProvider(%q) = %q, want %q", tt.ptr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteOutputTagProvider(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("192.0.2.1"), PTR: "ec2-192-0-2-1.compute-1.amazonaws.com"},
+		{IP: net.ParseIP("192.0.2.2"), PTR: "host.example.com"},
+		{IP: net.ParseIP("192.0.2.3")},
+	}
+
+	var buf bytes.Buffer
+	opts := OutputOptions{Format: "text", Expand: true, TagProvider: true}
+	if err := WriteOutput(&buf, results, opts); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "amazonaws.com (AWS)") {
+		t.Errorf("expected AWS tag, got:\n%s", out)
+	}
+	if strings.Contains(out, "example.com (") {
+		t.Errorf("unknown suffix should not be tagged, got:\n%s", out)
+	}
+	if results[0].Provider != "" {
+		t.Error("WriteOutput should not modify the input")
+	}
+
+	buf.Reset()
+	opts.Format = "json"
+	if err := WriteOutput(&buf, results, opts); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	out = buf.String()
+	if !strings.Contains(out, `"provider": "AWS"`) || !strings.Contains(out, `"provider": ""`) {
+		t.Errorf("expected provider fields in JSON, got:\n%s", out)
+	}
+
+	buf.Reset()
+	opts.Expand = false
+	if err := WriteOutput(&buf, results, opts); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"provider": "AWS"`) {
+		t.Errorf("expected provider in consolidated JSON, got:\n%s", buf.String())
+	}
+
+	// Without the flag, no provider field is emitted
+	buf.Reset()
+	opts.TagProvider = false
+	if err := WriteOutput(&buf, results, opts); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	if strings.Contains(buf.String(), "provider") {
+		t.Errorf("unexpected provider field, got:\n%s", buf.String())
+	}
+}
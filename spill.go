@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+)
+
+// Spill keeps completed results in a temporary file instead of memory
+// (--spill), for scans whose results would not fit. Only the offset of each
+// result's record is held, by Index, so the results can be written back in
+// input order once the scan is done. A record is:
+//
+//	status   1 byte   binaryPTR, binaryNXDomain, or binaryError
+//	flags    1 byte   spillInferred
+//	iplen    1 byte   4 or 16
+//	ip       iplen bytes
+//	text     uvarint length, then the PTR or error message
+//	category uvarint length, then ErrorCategory of the error
+//	warning  uvarint length, then the Warning message
+//
+// Fields a record does not keep (CNAMEs, verification, comparison) are why
+// --spill excludes the flags that set them.
+type Spill struct {
+	f       *os.File
+	w       *bufio.Writer
+	offsets []int64 // Record offset by Index; -1 if the result never arrived
+	size    int64   // Bytes written so far
+	buf     []byte
+}
+
+// Record flag bits in a spill file.
+const spillInferred byte = 1
+
+// NewSpill creates an empty spill file in dir (the system temporary
+// directory if ""), for the results of n addresses. Close removes it.
+func NewSpill(dir string, n int) (*Spill, error) {
+	f, err := os.CreateTemp(dir, "sr-spill-*")
+	if err != nil {
+		return nil, err
+	}
+	offsets := make([]int64, n)
+	for i := range offsets {
+		offsets[i] = -1
+	}
+	return &Spill{f: f, w: bufio.NewWriter(f), offsets: offsets}, nil
+}
+
+// Add appends r to the file.
+func (s *Spill) Add(r LookupResult) error {
+	if r.Index < 0 || r.Index >= len(s.offsets) {
+		return fmt.Errorf("spill: result index %d out of range", r.Index)
+	}
+	status, text, category, warning := binaryPTR, r.PTR, "", ""
+	switch {
+	case r.Error != nil:
+		status, text, category = binaryError, r.Error.Error(), ErrorCategory(r.Error)
+	case r.PTR == "":
+		status = binaryNXDomain
+	}
+	if r.Warning != nil {
+		warning = r.Warning.Error()
+	}
+	var flags byte
+	if r.Inferred {
+		flags |= spillInferred
+	}
+
+	ip := canonicalIP(r.IP)
+	buf := append(s.buf[:0], status, flags, byte(len(ip)))
+	buf = append(buf, ip...)
+	for _, field := range []string{text, category, warning} {
+		buf = binary.AppendUvarint(buf, uint64(len(field)))
+		buf = append(buf, field...)
+	}
+	s.buf = buf
+	if _, err := s.w.Write(buf); err != nil {
+		return err
+	}
+	s.offsets[r.Index] = s.size
+	s.size += int64(len(buf))
+	return nil
+}
+
+// Each calls fn with every result added, in input order, stopping at the
+// first error.
+func (s *Spill) Each(fn func(LookupResult) error) error {
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	br := bufio.NewReader(nil)
+	for idx, off := range s.offsets {
+		if off < 0 {
+			continue
+		}
+		br.Reset(io.NewSectionReader(s.f, off, s.size-off))
+		r, err := readSpillRecord(br)
+		if err != nil {
+			return fmt.Errorf("spill file %s: %w", s.f.Name(), err)
+		}
+		r.Index = idx
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write writes every result added to w in input order, filtered, as
+// StreamNDJSON (--output ndjson) or StreamText would.
+func (s *Spill) Write(w io.Writer, opts OutputOptions) error {
+	bw := newBatchWriter(w, opts.BatchSize)
+	write := textLineWriter(bw, opts)
+	if opts.Format == "ndjson" {
+		write = ndjsonLineWriter(bw, opts)
+	}
+	err := s.Each(func(r LookupResult) error {
+		for _, f := range FilterResults([]LookupResult{r}, opts) {
+			if err := write(f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return bw.close(err)
+}
+
+// Close closes and removes the spill file.
+func (s *Spill) Close() error {
+	err := s.f.Close()
+	if rmErr := os.Remove(s.f.Name()); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// readSpillRecord decodes one spill record.
+func readSpillRecord(br *bufio.Reader) (LookupResult, error) {
+	var head [3]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		return LookupResult{}, truncated(err)
+	}
+	status, flags, ipLen := head[0], head[1], head[2]
+	if ipLen != net.IPv4len && ipLen != net.IPv6len {
+		return LookupResult{}, fmt.Errorf("invalid spill record: IP length %d", ipLen)
+	}
+	r := LookupResult{IP: make(net.IP, ipLen), Inferred: flags&spillInferred != 0}
+	if _, err := io.ReadFull(br, r.IP); err != nil {
+		return LookupResult{}, truncated(err)
+	}
+	var fields [3]string
+	for i := range fields {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return LookupResult{}, truncated(err)
+		}
+		if n > maxBinaryText {
+			return LookupResult{}, fmt.Errorf("invalid spill record for %s: text length %d", r.IP, n)
+		}
+		field := make([]byte, n)
+		if _, err := io.ReadFull(br, field); err != nil {
+			return LookupResult{}, truncated(err)
+		}
+		fields[i] = string(field)
+	}
+	text, category, warning := fields[0], fields[1], fields[2]
+
+	switch status {
+	case binaryPTR:
+		r.PTR = text
+	case binaryNXDomain:
+	case binaryError:
+		r.Error = &spilledError{msg: text, category: category}
+	default:
+		return LookupResult{}, fmt.Errorf("invalid spill record for %s: status %d", r.IP, status)
+	}
+	if warning != "" {
+		r.Warning = errors.New(warning)
+	}
+	return r, nil
+}
+
+// spilledError is a lookup error read back from a spill file. It keeps the
+// original error's category, which the message alone does not carry.
+type spilledError struct {
+	msg      string
+	category string
+}
+
+func (e *spilledError) Error() string { return e.msg }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestSpillRoundTrip(t *testing.T) {
+	timeout := &net.DNSError{Err: "i/o timeout", IsTimeout: true}
+	results := []LookupResult{
+		{IP: net.ParseIP("2001:db8::1"), PTR: "v6.example.com", Index: 3},
+		{IP: net.ParseIP("192.0.2.1").To4(), Error: timeout, Index: 1},
+		{IP: net.ParseIP("192.0.2.0").To4(), PTR: "host.example.com", Warning: errors.New("truncated"), Index: 0},
+		{IP: net.ParseIP("192.0.2.3").To4(), PTR: "*.example.com", Inferred: true, Index: 4},
+	}
+	sp, err := NewSpill(t.TempDir(), 6) // Index 2 and 5 never arrive
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	for _, r := range results {
+		if err := sp.Add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []LookupResult
+	if err := sp.Each(func(r LookupResult) error {
+		got = append(got, r)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("got %d results, want 4", len(got))
+	}
+	// Input order, whatever order they were added in
+	for i, want := range []int{0, 1, 3, 4} {
+		if got[i].Index != want {
+			t.Errorf("result %d has Index %d, want %d", i, got[i].Index, want)
+		}
+	}
+	if got[0].PTR != "host.example.com" || got[0].Warning == nil || got[0].Warning.Error() != "truncated" {
+		t.Errorf("PTR with warning = %+v", got[0])
+	}
+	if got[1].Error == nil || got[1].Error.Error() != timeout.Error() || ErrorCategory(got[1].Error) != ErrorTimeout {
+		t.Errorf("error = %v (%s), want the timeout and its category", got[1].Error, ErrorCategory(got[1].Error))
+	}
+	if !got[2].IP.Equal(net.ParseIP("2001:db8::1")) || got[2].PTR != "v6.example.com" {
+		t.Errorf("IPv6 result = %+v", got[2])
+	}
+	if !got[3].Inferred {
+		t.Errorf("inferred result = %+v, want Inferred", got[3])
+	}
+
+	var buf bytes.Buffer
+	if err := sp.Write(&buf, OutputOptions{Format: "text", ResolvedOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	want := "192.0.2.0\thost.example.com (partial answer: truncated)\n2001:db8::1\tv6.example.com\n192.0.2.3\t*.example.com (inferred)\n"
+	if buf.String() != want {
+		t.Errorf("Write = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestSpillClose(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := NewSpill(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.Add(LookupResult{IP: net.ParseIP("192.0.2.1")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.Add(LookupResult{IP: net.ParseIP("192.0.2.2"), Index: 1}); err == nil {
+		t.Error("expected error for an Index beyond the addresses")
+	}
+	if err := sp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("spill file left behind: %v", entries)
+	}
+}
+
+func TestSpillCancelled(t *testing.T) {
+	// A cancelled scan adds only what completed, and Close still cleans up
+	dir := t.TempDir()
+	ips, _ := ExpandCIDR("192.0.2.0/28", 0)
+	sp, err := NewSpill(dir, len(ips))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for r := range LookupWorkersWith(ctx, ips, 4, &countingResolver{}, WorkerOptions{}) {
+		if err := sp.Add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sp.Each(func(LookupResult) error { return nil }); err != nil {
+		t.Errorf("Each after cancel: %v", err)
+	}
+	if err := sp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("spill file left behind: %v", entries)
+	}
+}
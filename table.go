@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// tableBorders are the characters a table is drawn with.
+type tableBorders struct {
+	horizontal, vertical               string
+	topLeft, topMid, topRight          string
+	midLeft, midMid, midRight          string
+	bottomLeft, bottomMid, bottomRight string
+}
+
+var (
+	boxBorders = tableBorders{
+		horizontal: "─", vertical: "│",
+		topLeft: "┌", topMid: "┬", topRight: "┐",
+		midLeft: "├", midMid: "┼", midRight: "┤",
+		bottomLeft: "└", bottomMid: "┴", bottomRight: "┘",
+	}
+	asciiBorders = tableBorders{
+		horizontal: "-", vertical: "|",
+		topLeft: "+", topMid: "+", topRight: "+",
+		midLeft: "+", midMid: "+", midRight: "+",
+		bottomLeft: "+", bottomMid: "+", bottomRight: "+",
+	}
+)
+
+// formatTable writes per-IP results as a bordered IP/PTR table.
+func formatTable(w io.Writer, results []LookupResult, opts OutputOptions) error {
+	rows := make([][2]string, len(results))
+	for i, r := range results {
+		rows[i] = [2]string{ipString(r.IP, opts.ExpandIPv6), textLine(r, opts)}
+	}
+	return writeTable(w, [2]string{"IP", "PTR"}, rows, opts)
+}
+
+// formatTableConsolidated writes consolidated results as a bordered
+// network/PTR table.
+func formatTableConsolidated(w io.Writer, results []ConsolidatedResult, opts OutputOptions) error {
+	return writeTable(w, [2]string{"NETWORK", "PTR"}, consolidatedRows(results, opts), opts)
+}
+
+// writeTable draws a two-column table with a header row. If opts.TableWidth
+// is set, the second column is truncated so lines fit within it.
+func writeTable(w io.Writer, header [2]string, rows [][2]string, opts OutputOptions) error {
+	b := boxBorders
+	if opts.TableASCII {
+		b = asciiBorders
+	}
+
+	var widths [2]int
+	for _, row := range append([][2]string{header}, rows...) {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	if opts.TableWidth > 0 {
+		// "│ a │ b │" spends 7 columns on borders and padding
+		widths[1] = max(min(widths[1], opts.TableWidth-widths[0]-7), utf8.RuneCountInString(header[1]))
+	}
+
+	rule := func(left, mid, right string) string {
+		return left + strings.Repeat(b.horizontal, widths[0]+2) + mid +
+			strings.Repeat(b.horizontal, widths[1]+2) + right + "\n"
+	}
+	line := func(row [2]string) string {
+		s := b.vertical
+		for i, cell := range row {
+			cell = truncateCell(cell, widths[i])
+			s += " " + cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)) + " " + b.vertical
+		}
+		return s + "\n"
+	}
+
+	if _, err := io.WriteString(w, rule(b.topLeft, b.topMid, b.topRight)+line(header)+rule(b.midLeft, b.midMid, b.midRight)); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if _, err := io.WriteString(w, line(row)); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, rule(b.bottomLeft, b.bottomMid, b.bottomRight))
+	return err
+}
+
+// truncateCell shortens s to width runes, marking the cut with "…".
+func truncateCell(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	if width <= 1 {
+		return "…"
+	}
+	return string([]rune(s)[:width-1]) + "…"
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestFormatTable(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("192.0.2.1"), PTR: "host.example.com"},
+		{IP: net.ParseIP("192.0.2.10")},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "table", Expand: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	want := "┌────────────┬──────────────────┐\n" +
+		"│ IP         │ PTR              │\n" +
+		"├────────────┼──────────────────┤\n" +
+		"│ 192.0.2.1  │ host.example.com │\n" +
+		"│ 192.0.2.10 │ NXDOMAIN         │\n" +
+		"└────────────┴──────────────────┘\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestFormatTableASCIIConsolidated(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("192.0.2.0").To4(), PTR: "host.example.com"},
+		{IP: net.ParseIP("192.0.2.1").To4(), PTR: "host.example.com"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "table", TableASCII: true}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	want := "+--------------+------------------+\n" +
+		"| NETWORK      | PTR              |\n" +
+		"+--------------+------------------+\n" +
+		"| 192.0.2.0/31 | host.example.com |\n" +
+		"+--------------+------------------+\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestFormatTableWidth(t *testing.T) {
+	results := []LookupResult{
+		{IP: net.ParseIP("192.0.2.1"), PTR: strings.Repeat("a", 50) + ".example.com"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOutput(&buf, results, OutputOptions{Format: "table", Expand: true, TableWidth: 40}); err != nil {
+		t.Fatalf("WriteOutput error: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if n := utf8.RuneCountInString(line); n != 40 {
+			t.Errorf("line is %d columns, want 40: %q", n, line)
+		}
+	}
+	if !strings.Contains(buf.String(), "…") {
+		t.Errorf("expected truncated PTR, got:\n%s", buf.String())
+	}
+}